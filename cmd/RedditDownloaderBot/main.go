@@ -3,64 +3,223 @@ package main
 import (
 	"RedditDownloaderBot/internal/bot"
 	"RedditDownloaderBot/internal/cache"
+	"RedditDownloaderBot/internal/config"
+	"RedditDownloaderBot/internal/fileserver"
+	"RedditDownloaderBot/internal/usage"
 	"RedditDownloaderBot/pkg/common"
+	"RedditDownloaderBot/pkg/logging"
 	"RedditDownloaderBot/pkg/reddit"
 	"RedditDownloaderBot/pkg/util"
+	"context"
+	"crypto/sha256"
+	"flag"
+	"fmt"
 	"github.com/go-faster/errors"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strconv"
-	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
 	errors.DisableTrace()
-	var err error
-	log.Println("Reddit Downloader Bot v" + common.Version)
-	if !util.DoesFfmpegExists() {
-		log.Println("Warning: FFmpeg is not installed on your computer.")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path of the YAML configuration file")
+	flag.Parse()
+	// Load the config
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		// The logger is not set up yet
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "See the Readme file on GitHub for the configuration options.")
+		os.Exit(1)
 	}
-	// Load the variables
-	clientID := os.Getenv("CLIENT_ID")
-	clientSecret := os.Getenv("CLIENT_SECRET")
-	botToken := os.Getenv("BOT_TOKEN")
-	if clientID == "" || clientSecret == "" || botToken == "" {
-		log.Fatalln("Please set CLIENT_ID, CLIENT_SECRET, and BOT_TOKEN according to the Readme file on GitHub.")
+	setupLogging(cfg.Log)
+	slog.Info("Reddit Downloader Bot v" + common.Version)
+	if !util.DoesFfmpegExists() {
+		slog.Warn("FFmpeg is not installed on your computer.")
 	}
-	botClient := bot.Client{}
+	logging.AddSecret(cfg.Reddit.ClientSecret)
+	logging.AddSecret(cfg.Telegram.Token)
+	logging.AddSecret(cfg.Redis.Password)
+	logging.AddSecret(cfg.Webhook.Secret)
+	logging.AddSecret(cfg.Files.Secret)
+	botClient := &bot.Client{}
 	// Start up database
-	if redisAddress, redisPort := os.Getenv("REDIS_ADDRESS"), os.Getenv("REDIS_PORT"); redisAddress != "" && redisPort != "" {
-		// Parse ttl
-		ttl, _ := time.ParseDuration(os.Getenv("REDIS_TTL"))
-		if ttl <= 0 {
-			ttl = 5 * time.Minute
-		}
-		botClient.CallbackCache, err = cache.NewRedisCache(redisAddress+":"+redisPort, os.Getenv("REDIS_PASSWORD"), ttl)
+	if cfg.Redis.Address != "" {
+		botClient.CallbackCache, err = cache.NewRedisCache(cfg.Redis.Address+":"+strconv.Itoa(cfg.Redis.Port), cfg.Redis.Password, cfg.Redis.TTL)
 		if err != nil {
-			log.Fatalln("Cannot connect to Redis:", err)
+			logging.Fatal("Cannot connect to Redis", "error", err)
 		}
 	} else { // Simple in cache memory
 		botClient.CallbackCache = cache.NewMemoryCache(5*time.Minute, 10*time.Minute)
+		if !cfg.Telegram.DropPendingUpdates {
+			slog.Warn("The processed updates are not remembered across restarts without Redis, so the pending updates might be processed twice")
+		}
 	}
 	defer botClient.CallbackCache.Close()
+	// Start the usage store
+	if cfg.Redis.Address != "" {
+		botClient.Usage, err = usage.NewRedisStore(cfg.Redis.Address+":"+strconv.Itoa(cfg.Redis.Port), cfg.Redis.Password)
+	} else {
+		botClient.Usage, err = usage.NewFileStore(cfg.Usage.File)
+	}
+	if err != nil {
+		logging.Fatal("Cannot open the usage store", "error", err)
+	}
+	defer func() {
+		if err := botClient.Usage.Close(); err != nil {
+			slog.Error("Cannot close the usage store", "error", err)
+		}
+	}()
+	botClient.BotAPI = botAPIOptions(cfg)
+	// Start the file server
+	if cfg.Files.Directory != "" {
+		botClient.Files, err = fileserver.New(fileserver.Options{
+			Directory:   cfg.Files.Directory,
+			PublicURL:   cfg.Files.PublicURL,
+			Secret:      filesSecret(cfg),
+			TTL:         cfg.Files.TTL,
+			Quota:       cfg.Files.QuotaMB * 1000 * 1000,
+			MaxFileSize: cfg.Files.MaxFileMB * 1000 * 1000,
+		})
+		if err != nil {
+			logging.Fatal("Cannot start the file server", "error", err)
+		}
+		defer botClient.Files.Close()
+		botClient.FilesAddress = cfg.Files.Listen
+	}
 	// Start the reddit oauth
-	botClient.RedditOauth, err = reddit.NewRedditOauth(clientID, clientSecret)
+	botClient.RedditOauth, err = reddit.NewRedditOauth(reddit.Options{
+		ClientID:        cfg.Reddit.ClientID,
+		ClientSecret:    cfg.Reddit.ClientSecret,
+		ImgurProxy:      cfg.Reddit.ImgurProxy,
+		MaxDownloadSize: botClient.MaxDownloadSize(),
+		ReencodeWorkers: cfg.Video.ReencodeWorkers,
+		ReencodeTimeout: cfg.Video.ReencodeTimeout,
+		Settings:        redditSettings(cfg),
+	})
 	if err != nil {
-		log.Fatalln("Cannot initialize the Reddit OAuth:", err.Error())
+		logging.Fatal("Cannot initialize the Reddit OAuth", "error", err)
 	}
-	botClient.RunBot(botToken, getAllowedUsers())
+	defer botClient.RedditOauth.Close()
+	botClient.Webhook = webhookOptions(cfg)
+	botClient.DropPendingUpdates = cfg.Telegram.DropPendingUpdates
+	botClient.ShutdownTimeout = cfg.ShutdownTimeout
+	botClient.Queue = bot.QueueOptions{
+		Workers:        cfg.Queue.Workers,
+		MaxJobsPerUser: cfg.Queue.MaxJobsPerUser,
+	}
+	botClient.MonitoringAddress = cfg.Monitoring.Listen
+	botClient.UpdateSettings(botSettings(cfg))
+	// Reload the config on SIGHUP
+	go reloadOnSignal(*configPath, cfg, botClient)
+	// Stop gracefully on SIGINT and SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	botClient.RunBot(ctx, cfg.Telegram.Token)
 }
 
-// getAllowedUsers gets the list of users which are allowed to use the bot
-func getAllowedUsers() []int64 {
-	usersString := strings.Split(os.Getenv("ALLOWED_USERS"), ",")
-	allowedIDs := make([]int64, 0, len(usersString))
-	for _, idString := range usersString {
-		id, err := strconv.ParseInt(idString, 10, 64)
-		if err == nil {
-			allowedIDs = append(allowedIDs, id)
+// setupLogging sets up the logger. The config must be validated.
+func setupLogging(options config.Log) {
+	level, _ := logging.ParseLevel(options.Level)
+	format, _ := logging.ParseFormat(options.Format)
+	logging.Setup(level, format)
+}
+
+// reloadOnSignal loads the config again each time that the bot receives SIGHUP and applies
+// the settings which can be changed while the bot is running. If the new config is invalid,
+// the current one is kept.
+func reloadOnSignal(configPath string, current *config.Config, botClient *bot.Client) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		newConfig, err := config.Load(configPath)
+		if err != nil {
+			slog.Error("Cannot reload the config; keeping the current one", "error", err)
+			continue
 		}
+		if changed := current.ChangedStructuralSettings(newConfig); len(changed) != 0 {
+			slog.Warn("Some changed settings need a restart to be applied", "sections", changed)
+		}
+		// The structural settings stay as they were applied on start
+		current = current.WithReloadable(newConfig)
+		level, _ := logging.ParseLevel(current.Log.Level)
+		logging.SetLevel(level)
+		botClient.UpdateSettings(botSettings(current))
+		botClient.RedditOauth.UpdateSettings(redditSettings(current))
+		slog.Info("Config reloaded")
+	}
+}
+
+// botSettings gets the reloadable settings of the bot from the config
+func botSettings(cfg *config.Config) bot.Settings {
+	settings := bot.Settings{
+		AllowedUsers: cfg.Telegram.AllowedUsers,
+		Admins:       cfg.Telegram.AdminUsers,
+		AdminChats:   cfg.Telegram.AdminChats,
+		MaxUpdateAge: cfg.Telegram.MaxUpdateAge,
+		RateLimit: bot.RateLimitOptions{
+			Default: bot.UserLimits{
+				RequestsPerMinute: cfg.RateLimit.RequestsPerMinute,
+				ConcurrentJobs:    cfg.RateLimit.ConcurrentJobs,
+				BytesPerDay:       cfg.RateLimit.MBPerDay * 1000 * 1000,
+			},
+			Overrides: make(map[int64]bot.UserLimits, len(cfg.RateLimit.Overrides)),
+		},
+		DisableLinkInCaption:    cfg.Telegram.DisableLinkInCaption,
+		ReencodeOversizedVideos: cfg.Video.ReencodeOversized,
+		AutoQuality:             cfg.Telegram.AutoQuality,
+	}
+	for _, override := range cfg.RateLimit.Overrides {
+		settings.RateLimit.Overrides[override.User] = bot.UserLimits{
+			RequestsPerMinute: override.RequestsPerMinute,
+			ConcurrentJobs:    override.ConcurrentJobs,
+			BytesPerDay:       override.MBPerDay * 1000 * 1000,
+		}
+	}
+	return settings
+}
+
+// redditSettings gets the reloadable settings of the Reddit client from the config
+func redditSettings(cfg *config.Config) reddit.Settings {
+	return reddit.Settings{DenyNSFW: cfg.Reddit.DenyNSFW}
+}
+
+// botAPIOptions gets the options of the self-hosted Bot API server from the config.
+// Returns nil if the bot must use api.telegram.org.
+func botAPIOptions(cfg *config.Config) *bot.BotAPIOptions {
+	if cfg.Telegram.APIURL == "" {
+		return nil
+	}
+	return &bot.BotAPIOptions{
+		URL:        cfg.Telegram.APIURL,
+		LocalFiles: cfg.Telegram.LocalFiles,
+	}
+}
+
+// filesSecret returns the key which the links of the file server are signed with.
+// If it is not set, it is derived from the bot token so the links stay valid after a restart.
+func filesSecret(cfg *config.Config) []byte {
+	if cfg.Files.Secret != "" {
+		return []byte(cfg.Files.Secret)
+	}
+	hash := sha256.Sum256([]byte("files:" + cfg.Telegram.Token))
+	return hash[:]
+}
+
+// webhookOptions gets the webhook options from the config.
+// Returns nil if the bot must use long polling.
+func webhookOptions(cfg *config.Config) *bot.WebhookOptions {
+	if cfg.Webhook.URL == "" {
+		return nil
+	}
+	return &bot.WebhookOptions{
+		ListenAddress: cfg.Webhook.Listen,
+		PublicURL:     cfg.Webhook.URL,
+		SecretToken:   cfg.Webhook.Secret,
+		CertFile:      cfg.Webhook.TLSCert,
+		KeyFile:       cfg.Webhook.TLSKey,
 	}
-	return allowedIDs
 }
@@ -0,0 +1,311 @@
+package bot
+
+import (
+	"RedditDownloaderBot/internal/usage"
+	"RedditDownloaderBot/pkg/reddit"
+	"cmp"
+	"context"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/go-faster/errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// broadcastInterval is the time between the messages of a broadcast. Telegram allows
+// about 30 messages per second to different chats.
+const broadcastInterval = 50 * time.Millisecond
+
+// broadcastFooter is added to the end of the announcements
+const broadcastFooter = "\n\nSend /unsubscribe to stop receiving announcements."
+
+// topSubredditsCount is the number of subreddits which /stats shows
+const topSubredditsCount = 5
+
+// whoisRequestsCount is the number of recent requests which /whois shows
+const whoisRequestsCount = 10
+
+// subredditRegex extracts the subreddit of a post link
+var subredditRegex = regexp.MustCompile(`(?i)reddit\.com/r/([A-Za-z0-9_]+)`)
+
+// handleAdminCommand handles the commands which only the admins can use.
+// Returns false if text is not an admin command.
+func (c *Client) handleAdminCommand(bot *gotgbot.Bot, ctx *ext.Context, text string) (bool, error) {
+	if _, ok := cutCommand(text, "/stats", bot.Username); ok {
+		return true, c.handleStatsCommand(bot, ctx)
+	}
+	if argument, ok := cutCommand(text, "/broadcast", bot.Username); ok {
+		return true, c.handleBroadcastCommand(bot, ctx, argument)
+	}
+	if argument, ok := cutCommand(text, "/whois", bot.Username); ok {
+		return true, c.handleWhoisCommand(bot, ctx, argument)
+	}
+	return false, nil
+}
+
+// recordUser adds the sender of a private message to the known users
+func (c *Client) recordUser(ctx *ext.Context) {
+	if ctx.EffectiveChat.Type != gotgbot.ChatTypePrivate {
+		return
+	}
+	err := c.Usage.TouchUser(usage.User{
+		ID:        ctx.EffectiveUser.Id,
+		Username:  ctx.EffectiveUser.Username,
+		FirstName: ctx.EffectiveUser.FirstName,
+		LastSeen:  time.Now(),
+	})
+	if err != nil {
+		updateLogger(ctx).Warn("Cannot record the user", "error", err)
+	}
+}
+
+// recordUsage records a request of a user in the usage store
+func (c *Client) recordUsage(ctx *ext.Context, postUrl string, result any, fetchErr *reddit.FetchError) {
+	request := usage.Request{
+		UserID:    ctx.EffectiveUser.Id,
+		Time:      time.Now(),
+		PostURL:   postUrl,
+		Subreddit: subredditOfLink(postUrl),
+	}
+	if fetchErr != nil {
+		request.Error = string(fetchErr.Category)
+	} else {
+		request.Type = postTypeLabel(result)
+	}
+	if err := c.Usage.AddRequest(request); err != nil {
+		updateLogger(ctx).Warn("Cannot record the request", "error", err)
+	}
+}
+
+// subredditOfLink returns the subreddit of a post link. Returns an empty string if the
+// link does not contain the subreddit.
+func subredditOfLink(link string) string {
+	if match := subredditRegex.FindStringSubmatch(link); match != nil {
+		return strings.ToLower(match[1])
+	}
+	return ""
+}
+
+// handleStatsCommand sends the statistics of today and the status of the bot
+func (c *Client) handleStatsCommand(bot *gotgbot.Bot, ctx *ext.Context) error {
+	stats, err := c.Usage.DailyStats(time.Now())
+	if err != nil {
+		updateLogger(ctx).Error("Cannot get the statistics", "error", err)
+		_, err = ctx.EffectiveMessage.Reply(bot, "Cannot get the statistics.", nil)
+		return err
+	}
+	waiting, running := c.queue.stats()
+	var text strings.Builder
+	text.WriteString("Uptime: " + time.Since(c.startTime).Round(time.Second).String() + "\n")
+	text.WriteString("Queue: " + strconv.Itoa(waiting) + " waiting, " + strconv.Itoa(running) + " running\n")
+	text.WriteString("\nRequests today (UTC): " + strconv.FormatInt(stats.Requests, 10) + "\n")
+	if stats.Requests > 0 {
+		failureRate := float64(stats.Failures) / float64(stats.Requests) * 100
+		text.WriteString("Failed: " + strconv.FormatInt(stats.Failures, 10) + " (" + strconv.FormatFloat(failureRate, 'f', 1, 64) + "%)\n")
+	}
+	for _, category := range sortedByCount(stats.FailuresByCategory, len(stats.FailuresByCategory)) {
+		text.WriteString("  " + category + ": " + strconv.FormatInt(stats.FailuresByCategory[category], 10) + "\n")
+	}
+	if len(stats.Subreddits) > 0 {
+		text.WriteString("\nTop subreddits:\n")
+		for i, subreddit := range sortedByCount(stats.Subreddits, topSubredditsCount) {
+			text.WriteString(strconv.Itoa(i+1) + ". r/" + subreddit + ": " + strconv.FormatInt(stats.Subreddits[subreddit], 10) + "\n")
+		}
+	}
+	if users, err := c.Usage.Users(); err == nil {
+		var subscribed int
+		for _, user := range users {
+			if !user.OptedOut && !user.Blocked {
+				subscribed++
+			}
+		}
+		text.WriteString("\nKnown users: " + strconv.Itoa(len(users)) + " (" + strconv.Itoa(subscribed) + " receive announcements)")
+	}
+	_, err = ctx.EffectiveMessage.Reply(bot, text.String(), nil)
+	return err
+}
+
+// sortedByCount returns at most n keys of counts with the highest counts
+func sortedByCount(counts map[string]int64, n int) []string {
+	keys := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+		return cmp.Or(cmp.Compare(counts[b], counts[a]), strings.Compare(a, b))
+	})
+	return keys[:min(n, len(keys))]
+}
+
+// handleWhoisCommand sends the information, the recent requests, and the quota usage of a user
+func (c *Client) handleWhoisCommand(bot *gotgbot.Bot, ctx *ext.Context, argument string) error {
+	userID, err := strconv.ParseInt(argument, 10, 64)
+	if err != nil {
+		_, err = ctx.EffectiveMessage.Reply(bot, "Please send the user ID like /whois 123456789.", nil)
+		return err
+	}
+	var text strings.Builder
+	text.WriteString("User " + strconv.FormatInt(userID, 10) + "\n")
+	user, err := c.Usage.GetUser(userID)
+	switch {
+	case err == nil:
+		if user.Username != "" {
+			text.WriteString("Username: @" + user.Username + "\n")
+		}
+		text.WriteString("Name: " + user.FirstName + "\n")
+		text.WriteString("First seen: " + user.FirstSeen.UTC().Format(time.DateTime) + "\n")
+		text.WriteString("Last seen: " + user.LastSeen.UTC().Format(time.DateTime) + "\n")
+		switch {
+		case user.Blocked:
+			text.WriteString("Has blocked the bot\n")
+		case user.OptedOut:
+			text.WriteString("Does not receive announcements\n")
+		}
+	case errors.Is(err, usage.NotFoundErr):
+		text.WriteString("Has never sent a message to the bot\n")
+	default:
+		updateLogger(ctx).Error("Cannot get the user", "error", err)
+		text.WriteString("Cannot get the user\n")
+	}
+	// Quota usage
+	limits := c.limiter.limits(userID)
+	used := c.limiter.usage(userID)
+	text.WriteString("\nRequests this minute: " + formatUsage(int64(used.RequestsPerMinute), int64(limits.RequestsPerMinute), formatCount) + "\n")
+	text.WriteString("Running jobs: " + formatUsage(int64(used.ConcurrentJobs), int64(limits.ConcurrentJobs), formatCount) + "\n")
+	text.WriteString("Downloaded today: " + formatUsage(used.BytesPerDay, limits.BytesPerDay, formatSize) + "\n")
+	// Recent requests
+	requests, err := c.Usage.RecentRequests(userID)
+	if err != nil {
+		updateLogger(ctx).Error("Cannot get the requests of the user", "error", err)
+	} else if len(requests) > 0 {
+		text.WriteString("\nRecent requests:\n")
+		for _, request := range requests[:min(whoisRequestsCount, len(requests))] {
+			outcome := request.Type
+			if request.Error != "" {
+				outcome = "failed: " + request.Error
+			}
+			text.WriteString(request.Time.UTC().Format(time.DateTime) + " " + outcome + "\n" + request.PostURL + "\n")
+		}
+	}
+	_, err = ctx.EffectiveMessage.Reply(bot, truncateUTF8(text.String(), maxTextSize), &gotgbot.SendMessageOpts{
+		LinkPreviewOptions: &gotgbot.LinkPreviewOptions{IsDisabled: true},
+	})
+	return err
+}
+
+// formatUsage formats the usage of a limit. Limits which are zero are shown as no limit.
+func formatUsage(used, limit int64, format func(int64) string) string {
+	if limit <= 0 {
+		return format(used) + " (no limit)"
+	}
+	return format(used) + " of " + format(limit)
+}
+
+// formatCount formats a number for formatUsage
+func formatCount(count int64) string {
+	return strconv.FormatInt(count, 10)
+}
+
+// handleBroadcastCommand sends an announcement to all the users who have not opted out.
+// The announcement is the text after the command or the message which the command replies to.
+// The messages are sent in the background and the admin is informed when it is done.
+func (c *Client) handleBroadcastCommand(bot *gotgbot.Bot, ctx *ext.Context, text string) error {
+	replyTo := ctx.EffectiveMessage.ReplyToMessage
+	if text == "" && replyTo == nil {
+		_, err := ctx.EffectiveMessage.Reply(bot, "Please send the announcement like /broadcast Hello! or reply /broadcast to a message.", nil)
+		return err
+	}
+	users, err := c.Usage.Users()
+	if err != nil {
+		updateLogger(ctx).Error("Cannot get the users", "error", err)
+		_, err = ctx.EffectiveMessage.Reply(bot, "Cannot get the users.", nil)
+		return err
+	}
+	recipients := make([]int64, 0, len(users))
+	for _, user := range users {
+		if !user.OptedOut && !user.Blocked {
+			recipients = append(recipients, user.ID)
+		}
+	}
+	adminChatID := ctx.EffectiveChat.Id
+	jobCtx, _, done, ok := c.jobs.start(adminChatID, ctx.EffectiveUser.Id)
+	if !ok {
+		_, err = ctx.EffectiveMessage.Reply(bot, restartingMessage, nil)
+		return err
+	}
+	logger := updateLogger(ctx)
+	logger.Info("Broadcasting an announcement", "recipients", len(recipients))
+	go func() {
+		defer done()
+		send := func(chatID int64) error {
+			if replyTo != nil {
+				_, err := bot.CopyMessageWithContext(jobCtx, chatID, adminChatID, replyTo.MessageId, nil)
+				return err
+			}
+			_, err := bot.SendMessageWithContext(jobCtx, chatID, text+broadcastFooter, nil)
+			return err
+		}
+		sent, blocked, failed := c.broadcast(jobCtx, logger, recipients, send)
+		result := "Announcement sent to " + strconv.Itoa(sent) + " users."
+		if blocked > 0 {
+			result += "\n" + strconv.Itoa(blocked) + " users have blocked the bot."
+		}
+		if failed > 0 {
+			result += "\n" + strconv.Itoa(failed) + " messages could not be sent."
+		}
+		if jobCtx.Err() != nil {
+			result += "\nThe broadcast was interrupted by a restart."
+		}
+		logger.Info("Broadcast finished", "sent", sent, "blocked", blocked, "failed", failed)
+		_, _ = bot.SendMessage(adminChatID, result, nil)
+	}()
+	_, err = ctx.EffectiveMessage.Reply(bot, "Sending the announcement to "+strconv.Itoa(len(recipients))+" users…", nil)
+	return err
+}
+
+// broadcast calls send for each recipient with a pause between them. The users who have
+// blocked the bot are marked in the usage store. Stops when ctx is canceled.
+func (c *Client) broadcast(ctx context.Context, logger *slog.Logger, recipients []int64, send func(chatID int64) error) (sent, blocked, failed int) {
+	ticker := time.NewTicker(broadcastInterval)
+	defer ticker.Stop()
+	for _, chatID := range recipients {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := send(chatID)
+		var telegramErr *gotgbot.TelegramError
+		switch {
+		case err == nil:
+			sent++
+		case errors.As(err, &telegramErr) && telegramErr.Code == http.StatusForbidden:
+			blocked++
+			if err = c.Usage.SetBlocked(chatID, true); err != nil {
+				logger.Warn("Cannot mark the user as blocked", "user_id", chatID, "error", err)
+			}
+		default:
+			failed++
+			logger.Warn("Cannot send the announcement", "user_id", chatID, "error", err)
+		}
+	}
+	return
+}
+
+// handleSubscriptionCommand changes if the user receives the announcements
+func (c *Client) handleSubscriptionCommand(bot *gotgbot.Bot, ctx *ext.Context, subscribe bool) error {
+	err := c.Usage.SetOptedOut(ctx.EffectiveUser.Id, !subscribe)
+	if err != nil && !errors.Is(err, usage.NotFoundErr) {
+		updateLogger(ctx).Error("Cannot change the subscription", "error", err)
+		_, err = ctx.EffectiveMessage.Reply(bot, "Cannot change your subscription. Please try again later.", nil)
+		return err
+	}
+	text := "You will not receive announcements anymore. Send /subscribe to receive them again."
+	if subscribe {
+		text = "You will receive announcements."
+	}
+	_, err = ctx.EffectiveMessage.Reply(bot, text, nil)
+	return err
+}
@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"time"
+)
+
+// backlogHandlerGroup is the handler group of backlogHandler. It must be less than the
+// group of other handlers in order to run before them.
+const backlogHandlerGroup = -1
+
+// backlogHandler runs before all other handlers and drops the updates which have been
+// processed before or have been waiting for too long.
+//
+// Duplicate updates might be received when the bot is restarted without dropping the
+// pending updates, or when Telegram resends a webhook request which we failed to answer.
+type backlogHandler struct {
+	client *Client
+}
+
+func (h backlogHandler) CheckUpdate(_ *gotgbot.Bot, _ *ext.Context) bool {
+	return true
+}
+
+func (h backlogHandler) HandleUpdate(bot *gotgbot.Bot, ctx *ext.Context) error {
+	logger := updateLogger(ctx)
+	h.client.telegram.updateReceived()
+	duplicate, err := h.client.CallbackCache.MarkUpdateProcessed(ctx.UpdateId)
+	if err != nil {
+		// Better to process an update twice than not processing it at all
+		logger.Warn("Cannot mark the update as processed", "error", err)
+	} else if duplicate {
+		logger.Debug("Dropping a duplicate update")
+		return ext.EndGroups
+	}
+	// Check the age of messages
+	if maxUpdateAge := h.client.settings().MaxUpdateAge; ctx.Message != nil && maxUpdateAge > 0 {
+		if time.Since(time.Unix(ctx.Message.Date, 0)) > maxUpdateAge {
+			// The other users are ignored by the message handler, so they get no reply either
+			if ctx.Message.From == nil || !h.client.settings().AllowedUsers.IsAllowed(ctx.Message.From.Id) {
+				return ext.EndGroups
+			}
+			_, err = ctx.Message.Reply(bot, "Sorry, this message arrived while I was offline. Please send it again.", nil)
+			if err != nil {
+				logger.Warn("Cannot reply to an old message", "error", err)
+			}
+			return ext.EndGroups
+		}
+	}
+	return nil
+}
+
+func (h backlogHandler) Name() string {
+	return "backlog"
+}
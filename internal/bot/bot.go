@@ -3,14 +3,18 @@ package bot
 import (
 	"RedditDownloaderBot/internal/cache"
 	"RedditDownloaderBot/pkg/common"
+	"RedditDownloaderBot/pkg/logging"
 	"RedditDownloaderBot/pkg/reddit"
 	"RedditDownloaderBot/pkg/util"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext"
 	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
-	"log"
+	"log/slog"
+	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -18,52 +22,125 @@ import (
 	"github.com/google/uuid"
 )
 
-// RunBot runs the bot with the specified token
-func (c *Client) RunBot(token string, allowedUsers AllowedUsers) {
+// RunBot runs the bot with the specified token until ctx is canceled. On cancellation,
+// it waits for the running jobs to finish before returning.
+func (c *Client) RunBot(ctx context.Context, token string) {
 	// Setup the bot
+	c.startTime = time.Now()
+	c.telegram = new(telegramStatus)
 	bot, err := gotgbot.NewBot(token, &gotgbot.BotOpts{
-		BotClient: gotgbot.BotClient(&gotgbot.BaseBotClient{
-			DefaultRequestOpts: &gotgbot.RequestOpts{
-				Timeout: time.Second * 20,
+		BotClient: newFloodControlClient(telegramStatusClient{
+			BotClient: &gotgbot.BaseBotClient{
+				DefaultRequestOpts: &gotgbot.RequestOpts{
+					Timeout: time.Second * 20,
+					APIURL:  c.apiURL(),
+				},
 			},
+			status: c.telegram,
 		}),
 	})
 	if err != nil {
-		log.Fatal("Cannot initialize the bot:", err.Error())
+		logging.Fatal("Cannot initialize the bot", "error", err)
 	}
-	log.Println("Bot authorized on account.", bot.Username)
+	slog.Info("Bot authorized", "username", bot.Username)
 	dispatcher := ext.NewDispatcher(&ext.DispatcherOpts{
-		Error: func(_ *gotgbot.Bot, _ *ext.Context, err error) ext.DispatcherAction {
-			log.Println("An error occurred while handling update: ", err.Error())
+		Error: func(_ *gotgbot.Bot, ctx *ext.Context, err error) ext.DispatcherAction {
+			updateLogger(ctx).Error("An error occurred while handling update", "error", err)
 			return ext.DispatcherActionNoop
 		},
 		MaxRoutines: ext.DefaultMaxRoutines,
 	})
 	updater := ext.NewUpdater(dispatcher, nil)
+	c.jobs = newJobTracker()
+	c.notifier = newAdminNotifier(bot, func() []int64 { return c.settings().AdminChats })
+	c.queue = newJobQueue(bot, c.jobs, c.Queue, c.isAdmin, c.reportPanic)
+	c.limiter = rateLimiter{cache: c.CallbackCache, options: func() RateLimitOptions { return c.settings().RateLimit }}
 	// Add handlers
+	dispatcher.AddHandlerToGroup(backlogHandler{client: c}, backlogHandlerGroup)
 	dispatcher.AddHandler(handlers.NewCallback(func(_ *gotgbot.CallbackQuery) bool {
 		return true
-	}, c.handleCallback))
+	}, c.trackedHandler(c.handleCallback)))
 	dispatcher.AddHandler(handlers.NewMessage(func(msg *gotgbot.Message) bool {
-		return allowedUsers.IsAllowed(msg.From.Id)
-	}, c.handleMessage))
+		return c.settings().AllowedUsers.IsAllowed(msg.From.Id)
+	}, c.trackedHandler(c.handleMessage)))
+	dispatcher.AddHandler(handlers.NewInlineQuery(func(query *gotgbot.InlineQuery) bool {
+		return c.settings().AllowedUsers.IsAllowed(query.From.Id)
+	}, c.handleInlineQuery))
+	monitoringServer := c.startMonitoringServer()
+	fileServer := c.startFileServer()
 	// Wait for updates
-	err = updater.StartPolling(bot, &ext.PollingOpts{
-		DropPendingUpdates: true,
-		GetUpdatesOpts: &gotgbot.GetUpdatesOpts{
-			Timeout: 60,
-			RequestOpts: &gotgbot.RequestOpts{
-				Timeout: time.Second * 60,
+	var webhookServer *http.Server
+	if c.Webhook != nil {
+		webhookServer, err = c.startWebhook(bot, updater)
+		if err != nil {
+			panic("Failed to start webhook: " + err.Error())
+		}
+	} else {
+		err = updater.StartPolling(bot, &ext.PollingOpts{
+			DropPendingUpdates:    c.DropPendingUpdates,
+			EnableWebhookDeletion: true,
+			GetUpdatesOpts: &gotgbot.GetUpdatesOpts{
+				Timeout: 60,
+				RequestOpts: &gotgbot.RequestOpts{
+					Timeout: time.Second * 60,
+				},
 			},
-		},
-	})
-	if err != nil {
-		panic("Failed to start polling: " + err.Error())
+		})
+		if err != nil {
+			panic("Failed to start polling: " + err.Error())
+		}
 	}
-	log.Printf("%s has been started . . .\n", bot.User.Username)
+	c.telegram.start(c.Webhook != nil)
+	slog.Info("Bot has been started", "username", bot.User.Username)
 
-	// Idle, to keep updates coming in, and avoid bot stopping.
-	updater.Idle()
+	// Wait until we are asked to stop
+	<-ctx.Done()
+	c.shutdown(bot, updater, webhookServer, monitoringServer, fileServer)
+}
+
+// shutdown stops receiving new updates, drops the queued jobs, and waits for the running jobs to finish.
+// If they take longer than ShutdownTimeout, they are canceled and their users are
+// asked to send their requests again.
+func (c *Client) shutdown(bot *gotgbot.Bot, updater *ext.Updater, webhookServer, monitoringServer, fileServer *http.Server) {
+	slog.Info("Shutting down the bot")
+	c.telegram.stop()
+	if webhookServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+		if err := webhookServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Cannot shut down the webhook server", "error", err)
+		}
+		cancel()
+	}
+	updater.StopAllBots()
+	// Inform the users whose requests have not been started yet
+	for _, job := range c.queue.close() {
+		job.finish(0)
+		if job.statusMessageID != 0 {
+			_, _ = bot.DeleteMessage(job.chatID, job.statusMessageID, nil)
+		}
+		_, err := bot.SendMessage(job.chatID, restartingMessage, nil)
+		if err != nil {
+			slog.Warn("Cannot inform the user about the shutdown", "chat_id", job.chatID, "error", err)
+		}
+	}
+	canceledChats := c.jobs.shutdown(c.ShutdownTimeout)
+	for _, chatID := range canceledChats {
+		_, err := bot.SendMessage(chatID, restartingMessage, nil)
+		if err != nil {
+			slog.Warn("Cannot inform the user about the shutdown", "chat_id", chatID, "error", err)
+		}
+	}
+	if !c.queue.wait(jobCancelGracePeriod) {
+		slog.Warn("Some queue workers did not stop")
+	}
+	updater.Dispatcher.Stop()
+	if monitoringServer != nil {
+		_ = monitoringServer.Close()
+	}
+	if fileServer != nil {
+		_ = fileServer.Close()
+	}
+	slog.Info("Bot stopped")
 }
 
 func (c *Client) handleMessage(bot *gotgbot.Bot, ctx *ext.Context) error {
@@ -72,29 +149,59 @@ func (c *Client) handleMessage(bot *gotgbot.Bot, ctx *ext.Context) error {
 		_, err := ctx.EffectiveChat.SendMessage(bot, "Please send a Reddit post.", nil)
 		return err
 	}
+	c.recordUser(ctx)
 	// Check if the message is command. I don't use command handler because I'll lose
 	// the userID control.
-	switch ctx.Message.Text {
+	command := ctx.Message.Text
+	// Deep links from inline mode contain the fullname of the post in the start parameter
+	if startParameter, ok := strings.CutPrefix(command, "/start "); ok {
+		if link := reddit.LinkFromFullname(startParameter); link != "" {
+			return c.fetchPostDetailsAndSend(bot, ctx, link, false)
+		}
+		command = "/start"
+	}
+	if argument, ok := cutCommand(command, "/mute", bot.Username); ok && c.canMuteReports(ctx) {
+		return c.handleMuteCommand(bot, ctx, argument)
+	}
+	if argument, ok := cutCommand(command, "/quality", bot.Username); ok {
+		return c.handleQualityCommand(bot, ctx, argument)
+	}
+	if c.isAdmin(ctx.EffectiveUser.Id) {
+		if handled, err := c.handleAdminCommand(bot, ctx, command); handled {
+			return err
+		}
+	}
+	switch command {
 	case "/start":
 		_, err := ctx.EffectiveChat.SendMessage(bot, "Hey!\n\nJust send me a post or comment, and I’ll download it for you.", nil)
 		return err
 	case "/about":
 		_, err := ctx.EffectiveChat.SendMessage(bot, "Reddit Downloader Bot v"+common.Version+"\nBy Hirbod Behnam\nSource: https://github.com/HirbodBehnam/RedditDownloaderBot", nil)
 		return err
+	case "/subscribe", "/unsubscribe":
+		return c.handleSubscriptionCommand(bot, ctx, command == "/subscribe")
 	case "/help":
 		_, err := ctx.EffectiveChat.SendMessage(bot, "You can send me Reddit posts or comments. If it’s text only, I’ll send a text message. If it’s an image or video, I’ll upload and send the content along with the title and link.", nil)
 		return err
 	default:
-		return c.fetchPostDetailsAndSend(bot, ctx)
+		return c.fetchPostDetailsAndSend(bot, ctx, ctx.Message.Text, false)
 	}
 }
 
-// fetchPostDetailsAndSend gets the basic info about the post being sent to us
-func (c *Client) fetchPostDetailsAndSend(bot *gotgbot.Bot, ctx *ext.Context) error {
-	result, realPostUrl, fetchErr := c.RedditOauth.StartFetch(ctx.Message.Text)
+// fetchPostDetailsAndSend gets the basic info about the post being sent to us.
+// If chooseQuality is true, the user is asked for the quality even in the auto quality mode.
+func (c *Client) fetchPostDetailsAndSend(bot *gotgbot.Bot, ctx *ext.Context, postUrl string, chooseQuality bool) error {
+	if ok, retryAfter := c.limiter.allowRequest(ctx.EffectiveUser.Id); !ok {
+		_, err := ctx.EffectiveMessage.Reply(bot, "You’re sending links too fast. Please try again in "+formatRetryAfter(retryAfter)+".", nil)
+		return err
+	}
+	result, realPostUrl, fullname, fetchErr := c.RedditOauth.StartFetch(updateContext(ctx), postUrl)
+	recordRequest(result, fetchErr)
+	c.recordUsage(ctx, postUrl, result, fetchErr)
+	c.reportFetchError(postUrl, fetchErr)
 	if fetchErr != nil {
 		if fetchErr.NormalError != "" {
-			log.Println("Cannot fetch the post", ctx.Message.Text, ":", fetchErr.NormalError)
+			updateLogger(ctx).Warn("Cannot fetch the post", "url", postUrl, "category", fetchErr.Category, "error", fetchErr.NormalError)
 		}
 		_, err := ctx.EffectiveMessage.Reply(bot, fetchErr.BotError, nil)
 		return err
@@ -106,9 +213,9 @@ func (c *Client) fetchPostDetailsAndSend(bot *gotgbot.Bot, ctx *ext.Context) err
 	}
 	switch data := result.(type) {
 	case reddit.FetchResultText:
-		toSendText = addLinkIfNeeded(data.Title+"\n"+data.Text, realPostUrl)
+		toSendText = c.addLinkIfNeeded(data.Title+"\n"+data.Text, realPostUrl)
 	case reddit.FetchResultComment:
-		toSendText = addLinkIfNeeded(data.Text, realPostUrl)
+		toSendText = c.addLinkIfNeeded(data.Text, realPostUrl)
 	case reddit.FetchResultMedia:
 		if len(data.Medias) == 0 {
 			toSendText = "No media found."
@@ -119,32 +226,52 @@ func (c *Client) fetchPostDetailsAndSend(bot *gotgbot.Bot, ctx *ext.Context) err
 		if len(data.Medias) == 1 && data.Type != reddit.FetchResultMediaTypePhoto {
 			switch data.Type {
 			case reddit.FetchResultMediaTypeGif:
-				return c.handleGifUpload(bot, data.Medias[0].Link, data.Title, data.ThumbnailLinks.SelectThumbnail(maxThumbnailDimensions), realPostUrl, data.Description, data.Medias[0].Dim, ctx.EffectiveChat.Id)
+				return c.enqueueJob(bot, ctx, realPostUrl, func(jobCtx context.Context) error {
+					return c.handleGifUpload(jobCtx, bot, data.Medias[0].Link, data.Title, data.ThumbnailLinks.SelectThumbnail(maxThumbnailDimensions), realPostUrl, fullname, data.Description, data.Medias[0].Dim, ctx.EffectiveChat.Id)
+				})
 			case reddit.FetchResultMediaTypeVideo:
 				// If the video does have an audio, ask user if they want the audio
 				if _, hasAudio := data.HasAudio(); !hasAudio {
 					// Otherwise, just download the video
-					return c.handleVideoUpload(bot, data.Medias[0].Link, "", data.Title, data.ThumbnailLinks.SelectThumbnail(maxThumbnailDimensions), realPostUrl, data.Description, data.Medias[0].Dim, data.Duration, ctx.EffectiveChat.Id)
+					return c.enqueueJob(bot, ctx, realPostUrl, func(jobCtx context.Context) error {
+						return c.handleVideoUpload(jobCtx, bot, data.Medias[0].Link, "", data.Title, data.ThumbnailLinks.SelectThumbnail(maxThumbnailDimensions), realPostUrl, fullname, data.Description, data.Medias[0].Dim, data.Duration, ctx.EffectiveChat.Id)
+					})
 				}
 			default:
 				panic("Shash")
 			}
 		}
+		// Download the best quality which fits unless the user wants to choose it
+		if !chooseQuality && c.autoQuality(ctx.EffectiveUser.Id) {
+			if sent, err := c.sendBestQuality(bot, ctx, data, realPostUrl, fullname); sent {
+				return err
+			}
+		}
 		// Allow the user to select quality
 		toSendText = "Please select the quality."
+		c.RedditOauth.EstimateSizes(updateContext(ctx), data.Medias)
+		tooLargeNote, sendLimit := c.tooLargeNote(data.Type)
+		labels, hasTooLarge, hasUnsendable := qualityLabels(data, c.MaxUploadSize(), sendLimit)
+		if hasUnsendable {
+			tooLargeNote = unsendableNote
+		}
+		if hasTooLarge {
+			toSendText += "\n\n" + tooLargeNote
+		}
 		idString := util.UUIDToBase64(uuid.New())
 		audioIndex, _ := data.HasAudio()
 		switch data.Type {
 		case reddit.FetchResultMediaTypePhoto:
-			toSendOpt.ReplyMarkup = createPhotoInlineKeyboard(idString, data)
+			toSendOpt.ReplyMarkup = createPhotoInlineKeyboard(idString, labels)
 		case reddit.FetchResultMediaTypeGif:
-			toSendOpt.ReplyMarkup = createGifInlineKeyboard(idString, data)
+			toSendOpt.ReplyMarkup = createGifInlineKeyboard(idString, labels)
 		case reddit.FetchResultMediaTypeVideo:
-			toSendOpt.ReplyMarkup = createVideoInlineKeyboard(idString, data)
+			toSendOpt.ReplyMarkup = createVideoInlineKeyboard(idString, labels)
 		}
 		// Insert the id in cache
 		err := c.CallbackCache.SetMediaCache(idString, cache.CallbackDataCached{
 			PostLink:      realPostUrl,
+			PostFullname:  fullname,
 			Links:         getLinkMapOfFetchResultMediaEntries(data.Medias),
 			Title:         data.Title,
 			ThumbnailLink: data.ThumbnailLinks.SelectThumbnail(maxThumbnailDimensions),
@@ -154,16 +281,17 @@ func (c *Client) fetchPostDetailsAndSend(bot *gotgbot.Bot, ctx *ext.Context) err
 			AudioIndex:    audioIndex,
 		})
 		if err != nil {
-			log.Println("Cannot set the media cache in database:", err)
+			updateLogger(ctx).Error("Cannot set the media cache in database", "error", err)
 		}
 	case reddit.FetchResultAlbum:
 		idString := util.UUIDToBase64(uuid.New())
 		err := c.CallbackCache.SetAlbumCache(idString, cache.CallbackAlbumCached{
-			PostLink: realPostUrl,
-			Album:    data,
+			PostLink:     realPostUrl,
+			PostFullname: fullname,
+			Album:        data,
 		})
 		if err != nil {
-			log.Println("Cannot set the album cache in database:", err)
+			updateLogger(ctx).Error("Cannot set the album cache in database", "error", err)
 		}
 		toSendText = "Download album as media or file?"
 		toSendOpt.ReplyMarkup = gotgbot.InlineKeyboardMarkup{
@@ -185,7 +313,7 @@ func (c *Client) fetchPostDetailsAndSend(bot *gotgbot.Bot, ctx *ext.Context) err
 			}},
 		}
 	default:
-		log.Printf("unknown type: %T\n", result)
+		updateLogger(ctx).Error("Unknown type of fetch result", "type", fmt.Sprintf("%T", result))
 		toSendText = "Unknown type (Please report this on the main GitHub project.)"
 	}
 	// Check the toSendText size
@@ -212,18 +340,27 @@ func (c *Client) fetchPostDetailsAndSend(bot *gotgbot.Bot, ctx *ext.Context) err
 
 // handleCallback handles the callback query of selecting a quality for any media type
 func (c *Client) handleCallback(bot *gotgbot.Bot, ctx *ext.Context) error {
+	// The link of the post, once it is read from the cache
+	var postURL string
 	// Don't crash!
 	defer func() {
 		if r := recover(); r != nil {
 			_, _ = ctx.EffectiveChat.SendMessage(bot, "Cannot get data. (panic)", nil)
-			log.Println("Recovering from panic:", r)
+			updateLogger(ctx).Error("Recovering from panic", "panic", r)
+			c.reportPanic(postURL, r)
 		}
 	}()
-	// Delete the message
-	_, _ = bot.DeleteMessage(ctx.EffectiveChat.Id, ctx.EffectiveMessage.GetMessageId(), nil)
 	// Parse the data
 	var data CallbackButtonData
 	err := json.Unmarshal([]byte(ctx.CallbackQuery.Data), &data)
+	if err == nil && data.Mode == CallbackButtonDataModeCancel {
+		return c.handleCancelCallback(bot, ctx, data)
+	}
+	if err == nil && data.Mode == CallbackButtonDataModeChooseQuality {
+		return c.handleChooseQualityCallback(bot, ctx, data)
+	}
+	// Delete the message
+	_, _ = bot.DeleteMessage(ctx.EffectiveChat.Id, ctx.EffectiveMessage.GetMessageId(), nil)
 	if err != nil {
 		_, err = ctx.EffectiveChat.SendMessage(bot, "Broken callback data", nil)
 		return err
@@ -235,7 +372,10 @@ func (c *Client) handleCallback(bot *gotgbot.Bot, ctx *ext.Context) error {
 		var album cache.CallbackAlbumCached
 		album, err = c.CallbackCache.GetAndDeleteAlbumCache(data.ID)
 		if err == nil {
-			return c.handleAlbumUpload(bot, album.Album, album.PostLink, ctx.EffectiveChat.Id, data.Mode == CallbackButtonDataModeFile)
+			postURL = album.PostLink
+			return c.enqueueJob(bot, ctx, album.PostLink, func(jobCtx context.Context) error {
+				return c.handleAlbumUpload(jobCtx, bot, album.Album, album.PostLink, album.PostFullname, ctx.EffectiveChat.Id, data.Mode == CallbackButtonDataModeFile)
+			})
 		} else if errors.Is(err, cache.NotFoundErr) {
 			// It does not exist...
 			_, err = ctx.EffectiveChat.SendMessage(bot, "Please resend the link.", nil)
@@ -245,10 +385,11 @@ func (c *Client) handleCallback(bot *gotgbot.Bot, ctx *ext.Context) error {
 	}
 	// Check other errors
 	if err != nil {
-		log.Println("Cannot get Callback ID from database:", err)
+		updateLogger(ctx).Error("Cannot get Callback ID from database", "error", err)
 		_, err = ctx.EffectiveChat.SendMessage(bot, "Internal error", nil)
 		return err
 	}
+	postURL = cachedData.PostLink
 	// Check the link
 	link, exists := cachedData.Links[data.LinkKey]
 	if !exists {
@@ -262,17 +403,45 @@ func (c *Client) handleCallback(bot *gotgbot.Bot, ctx *ext.Context) error {
 	// Check the media type
 	switch cachedData.Type {
 	case reddit.FetchResultMediaTypeGif:
-		return c.handleGifUpload(bot, link.Link, cachedData.Title, cachedData.ThumbnailLink, cachedData.PostLink, cachedData.Description, dim, ctx.EffectiveChat.Id)
+		return c.enqueueJob(bot, ctx, cachedData.PostLink, func(jobCtx context.Context) error {
+			return c.handleGifUpload(jobCtx, bot, link.Link, cachedData.Title, cachedData.ThumbnailLink, cachedData.PostLink, cachedData.PostFullname, cachedData.Description, dim, ctx.EffectiveChat.Id)
+		})
 	case reddit.FetchResultMediaTypePhoto:
-		return c.handlePhotoUpload(bot, link.Link, cachedData.Title, cachedData.ThumbnailLink, cachedData.PostLink, cachedData.Description, ctx.EffectiveChat.Id, data.Mode == CallbackButtonDataModePhoto)
+		return c.enqueueJob(bot, ctx, cachedData.PostLink, func(jobCtx context.Context) error {
+			return c.handlePhotoUpload(jobCtx, bot, link.Link, cachedData.Title, cachedData.ThumbnailLink, cachedData.PostLink, cachedData.PostFullname, cachedData.Description, ctx.EffectiveChat.Id, data.Mode == CallbackButtonDataModePhoto)
+		})
 	case reddit.FetchResultMediaTypeVideo:
 		if data.LinkKey == cachedData.AudioIndex {
-			return c.handleAudioUpload(bot, link.Link, cachedData.Title, cachedData.PostLink, cachedData.Description, cachedData.Duration, ctx.EffectiveChat.Id)
+			return c.enqueueJob(bot, ctx, cachedData.PostLink, func(jobCtx context.Context) error {
+				return c.handleAudioUpload(jobCtx, bot, link.Link, cachedData.Title, cachedData.PostLink, cachedData.PostFullname, cachedData.Description, cachedData.Duration, ctx.EffectiveChat.Id)
+			})
 		} else {
 			audioURL := cachedData.Links[cachedData.AudioIndex]
-			return c.handleVideoUpload(bot, link.Link, audioURL.Link, cachedData.Title, cachedData.ThumbnailLink, cachedData.PostLink, cachedData.Description, dim, cachedData.Duration, ctx.EffectiveChat.Id)
+			return c.enqueueJob(bot, ctx, cachedData.PostLink, func(jobCtx context.Context) error {
+				return c.handleVideoUpload(jobCtx, bot, link.Link, audioURL.Link, cachedData.Title, cachedData.ThumbnailLink, cachedData.PostLink, cachedData.PostFullname, cachedData.Description, dim, cachedData.Duration, ctx.EffectiveChat.Id)
+			})
 		}
 	}
 	// What
 	panic("Unknown media type: " + strconv.Itoa(int(cachedData.Type)))
 }
+
+// handleCancelCallback cancels a job when the user clicks on the cancel button of its progress message
+func (c *Client) handleCancelCallback(bot *gotgbot.Bot, ctx *ext.Context, data CallbackButtonData) error {
+	jobID, err := strconv.ParseUint(data.ID, 10, 64)
+	if err != nil {
+		_, err = ctx.CallbackQuery.Answer(bot, &gotgbot.AnswerCallbackQueryOpts{Text: "This request is already done."})
+		return err
+	}
+	userID := ctx.CallbackQuery.From.Id
+	exists, allowed := c.jobs.cancel(jobID, ctx.EffectiveChat.Id, userID, c.isAdmin(userID))
+	text := "Canceling…"
+	switch {
+	case !exists:
+		text = "This request is already done."
+	case !allowed:
+		text = "Only the user who sent this request can cancel it."
+	}
+	_, err = ctx.CallbackQuery.Answer(bot, &gotgbot.AnswerCallbackQueryOpts{Text: text})
+	return err
+}
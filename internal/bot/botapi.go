@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"os"
+	"path/filepath"
+)
+
+// localMaxUploadSize is the maximum size of the files which can be uploaded to a
+// self-hosted Bot API server running in the local mode
+const localMaxUploadSize = 2000 * 1000 * 1000
+
+// BotAPIOptions configures the bot to use a self-hosted Bot API server instead of api.telegram.org.
+// The server must run with --local, otherwise the uploads larger than 50 MB are rejected.
+type BotAPIOptions struct {
+	// URL is the base URL of the server. For example, http://localhost:8081
+	URL string
+	// If true, the server can read the temporary files of the bot, so the files are sent by
+	// their paths instead of being uploaded over HTTP
+	LocalFiles bool
+}
+
+// MaxUploadSize returns the maximum size of the files which the bot can upload to Telegram
+func (c *Client) MaxUploadSize() int64 {
+	if c.BotAPI != nil {
+		return localMaxUploadSize
+	}
+	return regularMaxUploadSize
+}
+
+// MaxDownloadSize returns the maximum size of the files which the bot downloads. The files which are
+// too large for Telegram are only downloaded if the file server can keep them.
+func (c *Client) MaxDownloadSize() int64 {
+	if c.Files != nil {
+		return max(c.MaxUploadSize(), c.Files.MaxFileSize())
+	}
+	return c.MaxUploadSize()
+}
+
+// apiURL returns the base URL of the Bot API. Empty means the default one.
+func (c *Client) apiURL() string {
+	if c.BotAPI != nil {
+		return c.BotAPI.URL
+	}
+	return ""
+}
+
+// sendsLocalFiles checks if the files are sent to the Bot API server by their paths
+func (c *Client) sendsLocalFiles() bool {
+	return c.BotAPI != nil && c.BotAPI.LocalFiles
+}
+
+// inputFile creates the gotgbot.InputFileOrString which uploads a file to Telegram
+func (c *Client) inputFile(file *os.File) gotgbot.InputFileOrString {
+	return inputFileFromOsFile(file, c.sendsLocalFiles())
+}
+
+// inputFileFromOsFile creates the gotgbot.InputFileOrString of a file. If local is true,
+// the file is sent by its path, which only a local Bot API server can read.
+func inputFileFromOsFile(file *os.File, local bool) gotgbot.InputFileOrString {
+	if !local {
+		return fileReaderFromOsFile(file)
+	}
+	path, err := filepath.Abs(file.Name())
+	if err != nil {
+		return fileReaderFromOsFile(file)
+	}
+	return gotgbot.InputFileByURL("file://" + filepath.ToSlash(path))
+}
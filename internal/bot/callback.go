@@ -25,6 +25,11 @@ const (
 	CallbackButtonDataModePhoto CallbackButtonDataMode = iota
 	// CallbackButtonDataModeFile means that we should use file instead of photo to send it to Telegram
 	CallbackButtonDataModeFile
+	// CallbackButtonDataModeCancel means that the job which its ID is in CallbackButtonData.ID must be canceled
+	CallbackButtonDataModeCancel
+	// CallbackButtonDataModeChooseQuality means that the qualities of the post whose fullname is in
+	// CallbackButtonData.ID must be shown to the user
+	CallbackButtonDataModeChooseQuality
 )
 
 // String returns the json format of CallbackButtonData
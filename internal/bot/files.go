@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"RedditDownloaderBot/pkg/logging"
+	"context"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// startFileServer starts the HTTP server of the files which are too large for Telegram
+// if it has its own address. Otherwise, the files are served by the webhook server.
+func (c *Client) startFileServer() *http.Server {
+	if c.Files == nil || c.FilesAddress == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle(c.Files.Pattern(), c.Files)
+	listener, err := net.Listen("tcp", c.FilesAddress)
+	if err != nil {
+		logging.Fatal("Cannot listen on the file server address", "error", err)
+	}
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("File server failed", "error", err)
+		}
+	}()
+	slog.Info("Serving the large files", "address", listener.Addr().String())
+	return server
+}
+
+// sendTooLargeFile tells the user that a file is too large to upload on Telegram. If the file
+// server is enabled, the file is moved into it and the user receives its link. Otherwise, or if
+// the file server is full, fallbackLinks is sent.
+func (c *Client) sendTooLargeFile(ctx context.Context, bot *gotgbot.Bot, chatID int64, file *os.File, postFullname, fallbackLinks string) error {
+	if c.Files != nil {
+		name := strings.TrimPrefix(postFullname, "t3_") + filepath.Ext(file.Name())
+		link, expires, err := c.Files.Add(file.Name(), name)
+		if err == nil {
+			_, err = bot.SendMessage(chatID, "This file is too large to upload on Telegram. You can download it until "+
+				expires.UTC().Format("2006-01-02 15:04")+" UTC from here:\n"+link, nil)
+			return err
+		}
+		logging.FromContext(ctx).Warn("Cannot add the file to the file server", "error", err)
+	}
+	return sendTooLargeLinks(bot, chatID, fallbackLinks)
+}
+
+// sendTooLargeLinks sends the links of a media which is too large to be sent or downloaded.
+// It is used when reddit.FileTooBigError is returned from the downloads.
+func sendTooLargeLinks(bot *gotgbot.Bot, chatID int64, fallbackLinks string) error {
+	_, err := bot.SendMessage(chatID, "This file is too large to upload on Telegram.\n"+fallbackLinks, nil)
+	return err
+}
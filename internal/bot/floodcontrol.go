@@ -0,0 +1,215 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/go-faster/errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The intervals between the messages which we send to a chat. Telegram allows about one
+// message per second in private chats and 20 messages per minute in groups.
+const (
+	privateChatMessageInterval = time.Second
+	groupChatMessageInterval   = time.Minute / 20
+)
+
+// floodWaitMaxRetries is the number of times which a request is retried after Telegram
+// responds with a flood-wait error
+const floodWaitMaxRetries = 3
+
+// floodWaitMaxDelay is the maximum retry_after which we wait for. Requests with longer
+// waits fail immediately.
+const floodWaitMaxDelay = 2 * time.Minute
+
+// floodControlCleanUpInterval is the interval which the state of idle chats is removed
+const floodControlCleanUpInterval = 10 * time.Minute
+
+// floodControlClient is a gotgbot.BotClient which applies the Telegram flood limits to all
+// requests of the bot. It spaces the messages sent to each chat and when Telegram responds
+// with a flood-wait error, it waits for retry_after and retries the request.
+type floodControlClient struct {
+	gotgbot.BotClient
+	// The state of each chat
+	chats map[string]*floodControlChat
+	// The last time which chats was cleaned up
+	lastCleanUp time.Time
+	lock        sync.Mutex
+}
+
+// floodControlChat is the flood control state of a single chat
+type floodControlChat struct {
+	// The time which the next message can be sent in
+	nextMessage time.Time
+	// Telegram has asked us not to send anything to this chat before this time
+	blockedUntil time.Time
+}
+
+// newFloodControlClient wraps a bot client in flood control
+func newFloodControlClient(client gotgbot.BotClient) *floodControlClient {
+	return &floodControlClient{
+		BotClient:   client,
+		chats:       make(map[string]*floodControlChat),
+		lastCleanUp: time.Now(),
+	}
+}
+
+func (c *floodControlClient) RequestWithContext(ctx context.Context, token string, method string, params map[string]string, data map[string]gotgbot.FileReader, opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+	chatID := params["chat_id"]
+	for attempt := 0; ; attempt++ {
+		// Wait for our turn
+		if chatID != "" {
+			if method == "sendChatAction" {
+				// Chat actions are not important. Just drop them if the chat is limited.
+				if c.isBlocked(chatID) {
+					return nil, errors.New("chat action dropped because of flood control")
+				}
+			} else if isMessageMethod(method) {
+				if err := sleepContext(ctx, c.reserveMessage(chatID)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		result, err := c.BotClient.RequestWithContext(ctx, token, method, params, data, opts)
+		retryAfter, isFloodWait := floodWaitDuration(err)
+		if !isFloodWait || attempt >= floodWaitMaxRetries || retryAfter > floodWaitMaxDelay || !rewindFiles(data) {
+			return result, err
+		}
+		slog.Warn("Flood wait", "retry_after", retryAfter, "method", method, "chat_id", chatID)
+		if chatID != "" {
+			c.block(chatID, retryAfter)
+		}
+		// Message methods wait for the block in reserveMessage
+		if chatID == "" || !isMessageMethod(method) {
+			if err = sleepContext(ctx, retryAfter); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// reserveMessage reserves a time to send a message to a chat and returns how long
+// we must wait until that time
+func (c *floodControlClient) reserveMessage(chatID string) time.Duration {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.cleanUp(now)
+	chat := c.getChat(chatID)
+	sendTime := now
+	if chat.nextMessage.After(sendTime) {
+		sendTime = chat.nextMessage
+	}
+	if chat.blockedUntil.After(sendTime) {
+		sendTime = chat.blockedUntil
+	}
+	if strings.HasPrefix(chatID, "-") || strings.HasPrefix(chatID, "@") {
+		chat.nextMessage = sendTime.Add(groupChatMessageInterval)
+	} else {
+		chat.nextMessage = sendTime.Add(privateChatMessageInterval)
+	}
+	return sendTime.Sub(now)
+}
+
+// block stops sending messages to a chat for a while
+func (c *floodControlClient) block(chatID string, d time.Duration) {
+	c.lock.Lock()
+	chat := c.getChat(chatID)
+	if until := time.Now().Add(d); until.After(chat.blockedUntil) {
+		chat.blockedUntil = until
+	}
+	c.lock.Unlock()
+}
+
+// isBlocked checks if Telegram has asked us to stop sending requests to a chat
+func (c *floodControlClient) isBlocked(chatID string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	chat, exists := c.chats[chatID]
+	return exists && time.Now().Before(chat.blockedUntil)
+}
+
+// getChat returns the state of a chat and creates it if needed. The lock must be held.
+func (c *floodControlClient) getChat(chatID string) *floodControlChat {
+	chat, exists := c.chats[chatID]
+	if !exists {
+		chat = new(floodControlChat)
+		c.chats[chatID] = chat
+	}
+	return chat
+}
+
+// cleanUp removes the chats which can send messages right now. The lock must be held.
+func (c *floodControlClient) cleanUp(now time.Time) {
+	if now.Sub(c.lastCleanUp) < floodControlCleanUpInterval {
+		return
+	}
+	c.lastCleanUp = now
+	for chatID, chat := range c.chats {
+		if now.After(chat.nextMessage) && now.After(chat.blockedUntil) {
+			delete(c.chats, chatID)
+		}
+	}
+}
+
+// isMessageMethod checks if a method sends or edits a message in a chat.
+// These methods are limited by Telegram in each chat.
+func isMessageMethod(method string) bool {
+	return strings.HasPrefix(method, "send") ||
+		strings.HasPrefix(method, "editMessage") ||
+		strings.HasPrefix(method, "copyMessage") ||
+		strings.HasPrefix(method, "forwardMessage")
+}
+
+// floodWaitDuration checks if an error is a flood-wait error and returns the time
+// which Telegram has asked us to wait
+func floodWaitDuration(err error) (time.Duration, bool) {
+	var telegramErr *gotgbot.TelegramError
+	if !errors.As(err, &telegramErr) || telegramErr.Code != http.StatusTooManyRequests {
+		return 0, false
+	}
+	retryAfter := time.Second
+	if telegramErr.ResponseParams != nil && telegramErr.ResponseParams.RetryAfter > 0 {
+		retryAfter = time.Duration(telegramErr.ResponseParams.RetryAfter) * time.Second
+	}
+	return retryAfter, true
+}
+
+// rewindFiles seeks the uploaded files back to their beginning in order to upload them
+// again. Returns false if any of them cannot be rewound.
+func rewindFiles(data map[string]gotgbot.FileReader) bool {
+	for _, file := range data {
+		if file.Data == nil {
+			continue
+		}
+		seeker, ok := file.Data.(io.Seeker)
+		if !ok {
+			return false
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sleepContext sleeps for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/go-faster/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBotClient is a gotgbot.BotClient which returns the given errors in order and
+// then succeeds. It records the content of the uploaded file of each request.
+type fakeBotClient struct {
+	gotgbot.BotClient
+	errors  []error
+	uploads []string
+}
+
+func (c *fakeBotClient) RequestWithContext(_ context.Context, _ string, _ string, _ map[string]string, data map[string]gotgbot.FileReader, _ *gotgbot.RequestOpts) (json.RawMessage, error) {
+	upload := ""
+	if file, exists := data["document"]; exists {
+		content, _ := io.ReadAll(file.Data)
+		upload = string(content)
+	}
+	c.uploads = append(c.uploads, upload)
+	if len(c.uploads) <= len(c.errors) {
+		return nil, c.errors[len(c.uploads)-1]
+	}
+	return json.RawMessage("true"), nil
+}
+
+// floodWaitError returns the error which Telegram responds with when we must wait
+func floodWaitError(retryAfter int64) error {
+	return &gotgbot.TelegramError{
+		Method:         "sendDocument",
+		Code:           http.StatusTooManyRequests,
+		Description:    "Too Many Requests",
+		ResponseParams: &gotgbot.ResponseParameters{RetryAfter: retryAfter},
+	}
+}
+
+// unseekableReader is an io.Reader which cannot be rewound
+type unseekableReader struct {
+	io.Reader
+}
+
+func TestReserveMessage(t *testing.T) {
+	tests := []struct {
+		Name     string
+		ChatID   string
+		Interval time.Duration
+	}{
+		{Name: "private_chat", ChatID: "1234", Interval: privateChatMessageInterval},
+		{Name: "group", ChatID: "-1001234", Interval: groupChatMessageInterval},
+		{Name: "channel_username", ChatID: "@channel", Interval: groupChatMessageInterval},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			client := newFloodControlClient(nil)
+			for i := range 3 {
+				assert.InDelta(t, time.Duration(i)*test.Interval, client.reserveMessage(test.ChatID), float64(100*time.Millisecond))
+			}
+			// The other chats are not affected
+			assert.Zero(t, client.reserveMessage("5678"))
+		})
+	}
+	// The messages wait until the chat is not blocked anymore
+	client := newFloodControlClient(nil)
+	client.block("1234", time.Minute)
+	assert.InDelta(t, time.Minute, client.reserveMessage("1234"), float64(100*time.Millisecond))
+}
+
+func TestFloodWaitDuration(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Err           error
+		Expected      time.Duration
+		ExpectedFlood bool
+	}{
+		{Name: "no_error"},
+		{Name: "other_error", Err: errors.New("connection reset by peer")},
+		{Name: "other_telegram_error", Err: &gotgbot.TelegramError{Code: http.StatusBadRequest}},
+		{Name: "without_retry_after", Err: &gotgbot.TelegramError{Code: http.StatusTooManyRequests}, Expected: time.Second, ExpectedFlood: true},
+		{Name: "retry_after", Err: floodWaitError(5), Expected: 5 * time.Second, ExpectedFlood: true},
+		{Name: "wrapped", Err: errors.Wrap(floodWaitError(5), "Unable to send the file"), Expected: 5 * time.Second, ExpectedFlood: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			retryAfter, isFloodWait := floodWaitDuration(test.Err)
+			assert.Equal(t, test.Expected, retryAfter)
+			assert.Equal(t, test.ExpectedFlood, isFloodWait)
+		})
+	}
+}
+
+func TestRewindFiles(t *testing.T) {
+	read := strings.NewReader("data")
+	_, _ = io.ReadAll(read)
+	tests := []struct {
+		Name     string
+		Data     map[string]gotgbot.FileReader
+		Expected bool
+	}{
+		{Name: "no_files", Expected: true},
+		{Name: "without_data", Data: map[string]gotgbot.FileReader{"document": {Name: "document"}}, Expected: true},
+		{Name: "seeker", Data: map[string]gotgbot.FileReader{"document": {Data: read}}, Expected: true},
+		{Name: "not_seeker", Data: map[string]gotgbot.FileReader{"document": {Data: unseekableReader{strings.NewReader("data")}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, rewindFiles(test.Data))
+		})
+	}
+	content, _ := io.ReadAll(read)
+	assert.Equal(t, "data", string(content))
+}
+
+func TestFloodControlRetries(t *testing.T) {
+	tests := []struct {
+		Name string
+		// The errors of the requests in order. The requests after them succeed.
+		Errors []error
+		File   io.Reader
+		// The number of requests which reach Telegram
+		ExpectedRequests int
+		HasError         bool
+	}{
+		{Name: "success", ExpectedRequests: 1},
+		{Name: "retry_until_success", Errors: []error{floodWaitError(1)}, ExpectedRequests: 2},
+		{
+			Name:             "too_many_retries",
+			Errors:           []error{floodWaitError(1), floodWaitError(1), floodWaitError(1), floodWaitError(1)},
+			ExpectedRequests: floodWaitMaxRetries + 1,
+			HasError:         true,
+		},
+		{
+			Name:             "long_wait",
+			Errors:           []error{floodWaitError(int64((floodWaitMaxDelay + time.Second) / time.Second))},
+			ExpectedRequests: 1,
+			HasError:         true,
+		},
+		{
+			Name:             "file_not_rewindable",
+			Errors:           []error{floodWaitError(1)},
+			File:             unseekableReader{strings.NewReader("data")},
+			ExpectedRequests: 1,
+			HasError:         true,
+		},
+		{Name: "other_error", Errors: []error{errors.New("bad request")}, ExpectedRequests: 1, HasError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			t.Parallel()
+			fake := &fakeBotClient{errors: test.Errors}
+			client := newFloodControlClient(fake)
+			file := test.File
+			if file == nil {
+				file = strings.NewReader("data")
+			}
+			_, err := client.RequestWithContext(context.Background(), "token", "sendDocument",
+				map[string]string{"chat_id": "1234"}, map[string]gotgbot.FileReader{"document": {Data: file}}, nil)
+			if test.HasError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			// The file is uploaded completely in each request
+			expectedUploads := make([]string, test.ExpectedRequests)
+			for i := range expectedUploads {
+				expectedUploads[i] = "data"
+			}
+			assert.Equal(t, expectedUploads, fake.uploads)
+		})
+	}
+}
+
+func TestFloodControlDropsChatActions(t *testing.T) {
+	fake := &fakeBotClient{}
+	client := newFloodControlClient(fake)
+	params := map[string]string{"chat_id": "1234", "action": "upload_video"}
+	_, err := client.RequestWithContext(context.Background(), "token", "sendChatAction", params, nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, fake.uploads, 1)
+	client.block("1234", time.Minute)
+	_, err = client.RequestWithContext(context.Background(), "token", "sendChatAction", params, nil, nil)
+	assert.Error(t, err)
+	assert.Len(t, fake.uploads, 1)
+	// The other chats are not affected
+	params["chat_id"] = "5678"
+	_, err = client.RequestWithContext(context.Background(), "token", "sendChatAction", params, nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, fake.uploads, 2)
+}
@@ -0,0 +1,230 @@
+package bot
+
+import (
+	"RedditDownloaderBot/pkg/logging"
+	"RedditDownloaderBot/pkg/util"
+	"context"
+	"encoding/json"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// pollingStaleAfter is the time after which the long polling is considered broken if no
+// getUpdates request has succeeded. Each getUpdates request takes at most one minute.
+const pollingStaleAfter = 3 * time.Minute
+
+// minFreeTempSpace is the free space which the temporary directory needs in order to download
+// and merge the medias of all workers
+const minFreeTempSpace = 500 * 1000 * 1000
+
+// healthCheckTimeout is the time which all the checks of a health endpoint can take
+const healthCheckTimeout = 5 * time.Second
+
+// telegramStatus tracks if we are receiving the updates from Telegram
+type telegramStatus struct {
+	// True if the polling or the webhook has been started
+	started bool
+	// True if the bot is shutting down
+	stopping bool
+	webhook  bool
+	// The last time which a getUpdates request succeeded and the error of the last failed one
+	lastPoll    time.Time
+	lastPollErr error
+	// The last time which an update was received
+	lastUpdate time.Time
+	lock       sync.Mutex
+}
+
+// start marks the polling or the webhook as started
+func (s *telegramStatus) start(webhook bool) {
+	s.lock.Lock()
+	s.started, s.webhook, s.lastPoll = true, webhook, time.Now()
+	s.lock.Unlock()
+}
+
+// stop marks the bot as shutting down
+func (s *telegramStatus) stop() {
+	s.lock.Lock()
+	s.stopping = true
+	s.lock.Unlock()
+}
+
+// updateReceived records that an update has been received
+func (s *telegramStatus) updateReceived() {
+	s.lock.Lock()
+	s.lastUpdate = time.Now()
+	s.lock.Unlock()
+}
+
+// pollResult records the result of a getUpdates request
+func (s *telegramStatus) pollResult(err error) {
+	s.lock.Lock()
+	if err == nil {
+		s.lastPoll, s.lastPollErr = time.Now(), nil
+	} else {
+		s.lastPollErr = err
+	}
+	s.lock.Unlock()
+}
+
+// check reports if we are receiving the updates
+func (s *telegramStatus) check() healthCheckResult {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch {
+	case s.stopping:
+		return healthCheckResult{Details: "shutting down"}
+	case !s.started:
+		return healthCheckResult{Details: "not started yet"}
+	}
+	var details string
+	if s.webhook {
+		details = "webhook"
+	} else {
+		details = "polling, last poll " + formatAgo(s.lastPoll)
+	}
+	if !s.lastUpdate.IsZero() {
+		details += ", last update " + formatAgo(s.lastUpdate)
+	}
+	if !s.webhook && time.Since(s.lastPoll) > pollingStaleAfter {
+		if s.lastPollErr != nil {
+			details += ": " + healthError(s.lastPollErr)
+		}
+		return healthCheckResult{Details: details}
+	}
+	return healthCheckResult{OK: true, Details: details}
+}
+
+// telegramStatusClient is a gotgbot.BotClient which records the results of the
+// getUpdates requests in a telegramStatus
+type telegramStatusClient struct {
+	gotgbot.BotClient
+	status *telegramStatus
+}
+
+func (c telegramStatusClient) RequestWithContext(ctx context.Context, token string, method string, params map[string]string, data map[string]gotgbot.FileReader, opts *gotgbot.RequestOpts) (json.RawMessage, error) {
+	result, err := c.BotClient.RequestWithContext(ctx, token, method, params, data, opts)
+	if method == "getUpdates" && ctx.Err() == nil {
+		c.status.pollResult(err)
+	}
+	return result, err
+}
+
+// healthCheckResult is the result of a single health check
+type healthCheckResult struct {
+	OK      bool   `json:"ok"`
+	Details string `json:"details,omitempty"`
+}
+
+// healthCheck is a named check of a health endpoint
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) healthCheckResult
+}
+
+// livenessChecks are the checks of /healthz. They fail only if restarting the bot
+// might fix them.
+func (c *Client) livenessChecks() []healthCheck {
+	return []healthCheck{
+		{name: "telegram", check: func(context.Context) healthCheckResult { return c.telegram.check() }},
+		{name: "reddit_token", check: c.checkRedditToken},
+	}
+}
+
+// readinessChecks are the checks of /readyz. They fail if the bot cannot serve the users.
+func (c *Client) readinessChecks() []healthCheck {
+	return append(c.livenessChecks(),
+		healthCheck{name: "cache", check: c.checkCache},
+		healthCheck{name: "ffmpeg", check: checkFfmpeg},
+		healthCheck{name: "temp_dir", check: c.checkTempDir},
+	)
+}
+
+// checkRedditToken checks if the Reddit access token is still valid
+func (c *Client) checkRedditToken(context.Context) healthCheckResult {
+	expiry, lastRefreshErr := c.RedditOauth.TokenStatus()
+	result := healthCheckResult{OK: time.Now().Before(expiry)}
+	if result.OK {
+		result.Details = "expires in " + time.Until(expiry).Round(time.Second).String()
+	} else if expiry.IsZero() {
+		result.Details = "no token"
+	} else {
+		result.Details = "expired " + formatAgo(expiry)
+	}
+	if lastRefreshErr != nil {
+		result.Details += ", last refresh failed: " + healthError(lastRefreshErr)
+	}
+	return result
+}
+
+// checkCache checks if the cache backend is reachable
+func (c *Client) checkCache(ctx context.Context) healthCheckResult {
+	if err := c.CallbackCache.Ping(ctx); err != nil {
+		return healthCheckResult{Details: healthError(err)}
+	}
+	return healthCheckResult{OK: true}
+}
+
+// checkFfmpeg checks if ffmpeg and ffprobe are installed
+func checkFfmpeg(context.Context) healthCheckResult {
+	switch {
+	case !util.DoesFfmpegExists():
+		return healthCheckResult{Details: "ffmpeg is not installed"}
+	case !util.DoesFfprobeExists():
+		return healthCheckResult{Details: "ffprobe is not installed"}
+	}
+	return healthCheckResult{OK: true}
+}
+
+// checkTempDir checks if the temporary directory has enough free space
+func (c *Client) checkTempDir(context.Context) healthCheckResult {
+	free, err := util.FreeDiskSpace(c.RedditOauth.TempDir())
+	if err != nil {
+		return healthCheckResult{Details: healthError(err)}
+	}
+	return healthCheckResult{
+		OK:      free >= minFreeTempSpace,
+		Details: formatSize(int64(free)) + " free",
+	}
+}
+
+// healthHandler serves a health endpoint. It responds with 200 if all the checks pass
+// and with 503 otherwise. The body contains the result of each check.
+func healthHandler(checks func() []healthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+		response := struct {
+			Status string                       `json:"status"`
+			Checks map[string]healthCheckResult `json:"checks"`
+		}{
+			Status: "ok",
+			Checks: make(map[string]healthCheckResult),
+		}
+		for _, check := range checks() {
+			result := check.check(ctx)
+			if !result.OK {
+				response.Status = "fail"
+			}
+			response.Checks[check.name] = result
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if response.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}
+}
+
+// healthError formats an error for the health endpoints. The endpoints are public, so the secrets
+// like the bot token in the URLs of the failed Bot API requests are removed.
+func healthError(err error) string {
+	return logging.Redact(err.Error())
+}
+
+// formatAgo formats the time since t for the health checks
+func formatAgo(t time.Time) string {
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
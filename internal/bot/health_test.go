@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"RedditDownloaderBot/pkg/logging"
+	"context"
+	"github.com/go-faster/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealthHandlerRedactsErrors(t *testing.T) {
+	const token = "123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw"
+	tests := []struct {
+		Name   string
+		Secret bool
+	}{
+		{Name: "pattern"},
+		{Name: "registered_secret", Secret: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			if test.Secret {
+				logging.AddSecret(token)
+				defer logging.RemoveSecret(token)
+			}
+			status := &telegramStatus{started: true, lastPoll: time.Now().Add(-time.Hour)}
+			status.pollResult(&url.Error{
+				Op:  "Post",
+				URL: "https://api.telegram.org/bot" + token + "/getUpdates",
+				Err: errors.New("connection reset by peer"),
+			})
+			handler := healthHandler(func() []healthCheck {
+				return []healthCheck{{name: "telegram", check: func(ctx context.Context) healthCheckResult { return status.check() }}}
+			})
+			recorder := httptest.NewRecorder()
+			handler(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+			body := recorder.Body.String()
+			assert.NotContains(t, body, token)
+			assert.NotContains(t, body, strings.Split(token, ":")[1])
+			assert.Contains(t, body, "connection reset by peer")
+		})
+	}
+}
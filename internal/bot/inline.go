@@ -0,0 +1,254 @@
+package bot
+
+import (
+	"RedditDownloaderBot/internal/cache"
+	"RedditDownloaderBot/pkg/reddit"
+	"context"
+	"fmt"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxInlineResults is the maximum number of results which we can answer an inline query with
+const maxInlineResults = 50
+
+// inlineCacheTime is the time in seconds which Telegram can cache our answer of an inline query.
+// This is low because the cached media of a post might change when someone downloads it in the bot.
+const inlineCacheTime = 30
+
+// inlineDescriptionLength is the maximum number of characters shown as the description of an inline result
+const inlineDescriptionLength = 100
+
+// handleInlineQuery answers the inline queries which contain a Reddit link.
+// Texts are returned as articles and medias are only returned if they have been uploaded
+// before. Otherwise, the user is asked to download the post in the bot.
+func (c *Client) handleInlineQuery(bot *gotgbot.Bot, ctx *ext.Context) error {
+	query := strings.TrimSpace(ctx.InlineQuery.Query)
+	if query == "" {
+		_, err := ctx.InlineQuery.Answer(bot, []gotgbot.InlineQueryResult{}, nil)
+		return err
+	}
+	requestCtx := updateContext(ctx)
+	result, realPostUrl, fullname, fetchErr := c.RedditOauth.StartFetch(requestCtx, query)
+	// The queries are sent while the user is typing. Only count the complete links.
+	completeLink := fetchErr == nil || fetchErr.Category != reddit.FetchErrorCategoryInvalidURL
+	if completeLink {
+		if ok, retryAfter := c.limiter.allowRequest(ctx.InlineQuery.From.Id); !ok {
+			_, err := ctx.InlineQuery.Answer(bot, []gotgbot.InlineQueryResult{}, &gotgbot.AnswerInlineQueryOpts{
+				IsPersonal: true,
+				Button: &gotgbot.InlineQueryResultsButton{
+					Text:           "Too many requests. Try again in " + formatRetryAfter(retryAfter) + ".",
+					StartParameter: "inline",
+				},
+			})
+			return err
+		}
+	}
+	recordRequest(result, fetchErr)
+	if completeLink {
+		c.recordUsage(ctx, query, result, fetchErr)
+	}
+	c.reportFetchError(query, fetchErr)
+	if fetchErr != nil {
+		if fetchErr.NormalError != "" {
+			updateLogger(ctx).Warn("Cannot fetch the post in inline mode", "url", query, "category", fetchErr.Category, "error", fetchErr.NormalError)
+		}
+		// Show the error as the button above the results
+		_, err := ctx.InlineQuery.Answer(bot, []gotgbot.InlineQueryResult{}, &gotgbot.AnswerInlineQueryOpts{
+			CacheTime: inlineCacheTime,
+			Button: &gotgbot.InlineQueryResultsButton{
+				Text:           fetchErr.BotError,
+				StartParameter: "inline",
+			},
+		})
+		return err
+	}
+	answerOpts := &gotgbot.AnswerInlineQueryOpts{CacheTime: inlineCacheTime}
+	var results []gotgbot.InlineQueryResult
+	switch data := result.(type) {
+	case reddit.FetchResultText:
+		results = []gotgbot.InlineQueryResult{c.createInlineTextResult(data.Title, data.Title+"\n"+data.Text, realPostUrl)}
+	case reddit.FetchResultComment:
+		results = []gotgbot.InlineQueryResult{c.createInlineTextResult("Comment", data.Text, realPostUrl)}
+	case reddit.FetchResultMedia:
+		caption := c.addLinkIfNeeded(escapeMarkdown(data.Title), realPostUrl)
+		audioIndex, hasAudio := data.HasAudio()
+		for i, media := range data.Medias {
+			// The videos are stored with the audio which is merged into them
+			audioLink := ""
+			if hasAudio && i != audioIndex {
+				audioLink = data.Medias[audioIndex].Link
+			}
+			results = c.appendInlineCachedResults(requestCtx, results, fullname, media.Link, audioLink, media.Quality, caption, inlineFileTypesOfMedia(data.Type))
+		}
+		if len(results) == 0 {
+			results = []gotgbot.InlineQueryResult{createInlineDownloadInBotResult(bot, data.Title, caption, fullname)}
+		}
+		answerOpts.Button = createInlineDownloadInBotButton(fullname)
+	case reddit.FetchResultAlbum:
+		for i, media := range data.Album {
+			caption := escapeMarkdown(media.Caption)
+			if caption == "" {
+				caption = c.addLinkIfNeeded(escapeMarkdown(data.Title), realPostUrl)
+			}
+			results = c.appendInlineCachedResults(requestCtx, results, fullname, media.Link, "", strconv.Itoa(i+1), caption, inlineFileTypesOfAlbumEntry(media.Type))
+		}
+		if len(results) == 0 {
+			results = []gotgbot.InlineQueryResult{createInlineDownloadInBotResult(bot, data.Title, c.addLinkIfNeeded(escapeMarkdown(data.Title), realPostUrl), fullname)}
+		}
+		answerOpts.Button = createInlineDownloadInBotButton(fullname)
+	default:
+		updateLogger(ctx).Error("Unknown type of fetch result in inline query", "type", fmt.Sprintf("%T", result))
+	}
+	if len(results) > maxInlineResults {
+		results = results[:maxInlineResults]
+	}
+	_, err := ctx.InlineQuery.Answer(bot, results, answerOpts)
+	return err
+}
+
+// appendInlineCachedResults searches the cache for each file type of a media and appends
+// the uploaded files to the results. See uploadedFileKey for audioLink.
+func (c *Client) appendInlineCachedResults(ctx context.Context, results []gotgbot.InlineQueryResult, fullname, link, audioLink, quality, caption string, fileTypes []cache.TelegramFileType) []gotgbot.InlineQueryResult {
+	for _, fileType := range fileTypes {
+		uploadedFile, found := c.getUploadedFile(ctx, fullname, link, audioLink, fileType)
+		if !found {
+			continue
+		}
+		results = append(results, createInlineCachedResult(strconv.Itoa(len(results)), quality, caption, uploadedFile))
+	}
+	return results
+}
+
+// createInlineCachedResult creates an inline result from a file which we have uploaded before
+func createInlineCachedResult(id, quality, caption string, uploadedFile cache.UploadedFile) gotgbot.InlineQueryResult {
+	switch uploadedFile.Type {
+	case cache.TelegramFileTypePhoto:
+		return gotgbot.InlineQueryResultCachedPhoto{
+			Id:          id,
+			PhotoFileId: uploadedFile.FileID,
+			Title:       quality,
+			Caption:     caption,
+			ParseMode:   gotgbot.ParseModeMarkdownV2,
+		}
+	case cache.TelegramFileTypeVideo:
+		return gotgbot.InlineQueryResultCachedVideo{
+			Id:          id,
+			VideoFileId: uploadedFile.FileID,
+			Title:       quality,
+			Caption:     caption,
+			ParseMode:   gotgbot.ParseModeMarkdownV2,
+		}
+	case cache.TelegramFileTypeAnimation:
+		return gotgbot.InlineQueryResultCachedMpeg4Gif{
+			Id:          id,
+			Mpeg4FileId: uploadedFile.FileID,
+			Title:       quality,
+			Caption:     caption,
+			ParseMode:   gotgbot.ParseModeMarkdownV2,
+		}
+	case cache.TelegramFileTypeAudio:
+		return gotgbot.InlineQueryResultCachedAudio{
+			Id:          id,
+			AudioFileId: uploadedFile.FileID,
+			Caption:     caption,
+			ParseMode:   gotgbot.ParseModeMarkdownV2,
+		}
+	default:
+		return gotgbot.InlineQueryResultCachedDocument{
+			Id:             id,
+			DocumentFileId: uploadedFile.FileID,
+			Title:          "File " + quality,
+			Caption:        caption,
+			ParseMode:      gotgbot.ParseModeMarkdownV2,
+		}
+	}
+}
+
+// createInlineTextResult creates an article result which sends a text post or comment
+func (c *Client) createInlineTextResult(title, text, postUrl string) gotgbot.InlineQueryResult {
+	// We cannot fall back to other parse modes like the normal messages. So we escape everything
+	messageText := escapeMarkdown(text)
+	if len(messageText) > maxTextSize-200 { // leave some space for the link
+		messageText = truncateUTF8(messageText, maxTextSize-200)
+		messageText = strings.TrimSuffix(messageText, "\\") + "…"
+	}
+	return gotgbot.InlineQueryResultArticle{
+		Id:          "text",
+		Title:       title,
+		Description: truncateUTF8(text, inlineDescriptionLength),
+		InputMessageContent: gotgbot.InputTextMessageContent{
+			MessageText: c.addLinkIfNeeded(messageText, postUrl),
+			ParseMode:   gotgbot.ParseModeMarkdownV2,
+		},
+	}
+}
+
+// createInlineDownloadInBotResult creates an article which tells the user that this post
+// must be downloaded in the bot before being sent in inline mode
+func createInlineDownloadInBotResult(bot *gotgbot.Bot, title, caption, fullname string) gotgbot.InlineQueryResult {
+	return gotgbot.InlineQueryResultArticle{
+		Id:          "download",
+		Title:       title,
+		Description: "This post has not been downloaded yet. Download it in the bot first.",
+		InputMessageContent: gotgbot.InputTextMessageContent{
+			MessageText: caption,
+			ParseMode:   gotgbot.ParseModeMarkdownV2,
+		},
+		ReplyMarkup: &gotgbot.InlineKeyboardMarkup{
+			InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
+				Text: "Download in bot",
+				Url:  "https://t.me/" + bot.Username + "?start=" + fullname,
+			}}},
+		},
+	}
+}
+
+// createInlineDownloadInBotButton creates the button which is shown above the inline results
+// to download the post in the bot
+func createInlineDownloadInBotButton(fullname string) *gotgbot.InlineQueryResultsButton {
+	return &gotgbot.InlineQueryResultsButton{
+		Text:           "Download in bot",
+		StartParameter: fullname,
+	}
+}
+
+// inlineFileTypesOfMedia returns the file types which a media might have been uploaded as
+func inlineFileTypesOfMedia(mediaType reddit.FetchResultMediaType) []cache.TelegramFileType {
+	switch mediaType {
+	case reddit.FetchResultMediaTypePhoto:
+		return []cache.TelegramFileType{cache.TelegramFileTypePhoto, cache.TelegramFileTypeDocument}
+	case reddit.FetchResultMediaTypeGif:
+		return []cache.TelegramFileType{cache.TelegramFileTypeAnimation}
+	case reddit.FetchResultMediaTypeVideo:
+		// The audio of the videos are stored as audio
+		return []cache.TelegramFileType{cache.TelegramFileTypeVideo, cache.TelegramFileTypeAudio}
+	}
+	return nil
+}
+
+// inlineFileTypesOfAlbumEntry returns the file types which a media of an album might have
+// been uploaded as. Note that GIFs in albums are uploaded as videos.
+func inlineFileTypesOfAlbumEntry(mediaType reddit.FetchResultMediaType) []cache.TelegramFileType {
+	switch mediaType {
+	case reddit.FetchResultMediaTypePhoto:
+		return []cache.TelegramFileType{cache.TelegramFileTypePhoto, cache.TelegramFileTypeDocument}
+	case reddit.FetchResultMediaTypeGif, reddit.FetchResultMediaTypeVideo:
+		return []cache.TelegramFileType{cache.TelegramFileTypeVideo, cache.TelegramFileTypeDocument}
+	}
+	return nil
+}
+
+// truncateUTF8 truncates a string to at most maxBytes bytes without breaking the UTF-8 characters
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	for maxBytes > 0 && !utf8.RuneStart(s[maxBytes]) {
+		maxBytes--
+	}
+	return s[:maxBytes]
+}
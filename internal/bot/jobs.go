@@ -0,0 +1,153 @@
+package bot
+
+import (
+	"context"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext/handlers"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// jobTrackerPollInterval is the interval which jobTracker.shutdown checks if all jobs are done
+const jobTrackerPollInterval = 100 * time.Millisecond
+
+// jobCancelGracePeriod is the time which we wait for the jobs to clean up after their
+// context is canceled on shutdown
+const jobCancelGracePeriod = 5 * time.Second
+
+// restartingMessage is sent to users whose requests could not be completed because of shutdown
+const restartingMessage = "Sorry, I’m restarting and couldn’t finish your request. Please send the link again in a minute."
+
+// trackedJob is a job which is running in the bot
+type trackedJob struct {
+	// The chat which this job is working for. Zero if unknown.
+	chatID int64
+	// The user who has requested this job. Zero if unknown.
+	userID int64
+	// Cancels the context of the job
+	cancel context.CancelFunc
+}
+
+// jobTracker keeps track of the running jobs in order to wait for them on shutdown
+type jobTracker struct {
+	jobs   map[uint64]trackedJob
+	nextID uint64
+	// If true, no new job is accepted
+	closing bool
+	lock    sync.Mutex
+}
+
+// newJobTracker creates an empty jobTracker
+func newJobTracker() *jobTracker {
+	return &jobTracker{jobs: make(map[uint64]trackedJob)}
+}
+
+// start registers a new job of a user in a chat. The returned context is canceled when the job
+// must be aborted and done must be called when the job is finished. If the tracker is shutting
+// down, ok is false and the job must not be started.
+func (t *jobTracker) start(chatID, userID int64) (ctx context.Context, id uint64, done func(), ok bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closing {
+		return nil, 0, nil, false
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	id = t.nextID
+	t.nextID++
+	t.jobs[id] = trackedJob{chatID: chatID, userID: userID, cancel: cancel}
+	return ctx, id, func() {
+		cancel()
+		t.lock.Lock()
+		delete(t.jobs, id)
+		t.lock.Unlock()
+	}, true
+}
+
+// cancel cancels a running job on behalf of a user in a chat. The chat must match the chat of
+// the job, and only the user who has requested the job or the admins can cancel it, so the other
+// members of a group cannot cancel it. exists is false if the job is not found and allowed is
+// false if the user cannot cancel it.
+func (t *jobTracker) cancel(id uint64, chatID, userID int64, admin bool) (exists, allowed bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	job, exists := t.jobs[id]
+	if !exists || job.chatID != chatID {
+		return false, false
+	}
+	if job.userID != userID && !admin {
+		return true, false
+	}
+	job.cancel()
+	return true, true
+}
+
+// shutdown stops accepting new jobs and waits for the running jobs to finish. If they do not
+// finish before the timeout, they are canceled. Returns the chat IDs of the canceled jobs.
+func (t *jobTracker) shutdown(timeout time.Duration) []int64 {
+	t.lock.Lock()
+	t.closing = true
+	t.lock.Unlock()
+	if t.waitForJobs(timeout) {
+		return nil
+	}
+	// Cancel everything
+	t.lock.Lock()
+	chatIDs := make([]int64, 0, len(t.jobs))
+	for _, job := range t.jobs {
+		job.cancel()
+		if job.chatID != 0 {
+			chatIDs = append(chatIDs, job.chatID)
+		}
+	}
+	t.lock.Unlock()
+	// Let them clean up their files
+	if !t.waitForJobs(jobCancelGracePeriod) {
+		slog.Warn("Some jobs did not stop after being canceled")
+	}
+	return chatIDs
+}
+
+// waitForJobs waits until there is no running job. Returns false on timeout.
+func (t *jobTracker) waitForJobs(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	ticker := time.NewTicker(jobTrackerPollInterval)
+	defer ticker.Stop()
+	for {
+		t.lock.Lock()
+		remaining := len(t.jobs)
+		t.lock.Unlock()
+		if remaining == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		<-ticker.C
+	}
+}
+
+// trackedHandler wraps a handler in order to track it as a job which is waited for on shutdown.
+// If the bot is shutting down, the user is asked to send the request again later.
+func (c *Client) trackedHandler(handler handlers.Response) handlers.Response {
+	return func(bot *gotgbot.Bot, ctx *ext.Context) error {
+		var chatID, userID int64
+		if ctx.EffectiveChat != nil {
+			chatID = ctx.EffectiveChat.Id
+		}
+		if ctx.EffectiveUser != nil {
+			userID = ctx.EffectiveUser.Id
+		}
+		_, _, done, ok := c.jobs.start(chatID, userID)
+		if !ok {
+			if chatID != 0 {
+				_, err := bot.SendMessage(chatID, restartingMessage, nil)
+				return err
+			}
+			return nil
+		}
+		defer done()
+		return handler(bot, ctx)
+	}
+}
@@ -0,0 +1,41 @@
+package bot
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestJobTrackerCancel(t *testing.T) {
+	const chatID, ownerID, otherID = 100, 1, 2
+	tests := []struct {
+		Name            string
+		ChatID          int64
+		UserID          int64
+		Admin           bool
+		ExpectedExists  bool
+		ExpectedAllowed bool
+	}{
+		{Name: "owner", ChatID: chatID, UserID: ownerID, ExpectedExists: true, ExpectedAllowed: true},
+		{Name: "other_user", ChatID: chatID, UserID: otherID, ExpectedExists: true},
+		{Name: "admin", ChatID: chatID, UserID: otherID, Admin: true, ExpectedExists: true, ExpectedAllowed: true},
+		{Name: "other_chat", ChatID: chatID + 1, UserID: ownerID},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			tracker := newJobTracker()
+			ctx, id, done, ok := tracker.start(chatID, ownerID)
+			assert.True(t, ok)
+			defer done()
+			exists, allowed := tracker.cancel(id, test.ChatID, test.UserID, test.Admin)
+			assert.Equal(t, test.ExpectedExists, exists)
+			assert.Equal(t, test.ExpectedAllowed, allowed)
+			assert.Equal(t, test.ExpectedAllowed, ctx.Err() != nil)
+		})
+	}
+	// The job is gone when it is done
+	tracker := newJobTracker()
+	_, id, done, _ := tracker.start(chatID, ownerID)
+	done()
+	exists, _ := tracker.cancel(id, chatID, ownerID, false)
+	assert.False(t, exists)
+}
@@ -0,0 +1,36 @@
+package bot
+
+import (
+	"RedditDownloaderBot/pkg/logging"
+	"context"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"log/slog"
+)
+
+// updateLoggerKey is the key of the logger of an update in ext.Context.Data
+const updateLoggerKey = "logger"
+
+// updateLogger returns the logger of an update. Each update gets a new correlation ID
+// which is also logged by the fetches, downloads, and uploads of that update.
+func updateLogger(ctx *ext.Context) *slog.Logger {
+	if logger, ok := ctx.Data[updateLoggerKey].(*slog.Logger); ok {
+		return logger
+	}
+	logger := slog.Default().With(logging.CorrelationIDKey, logging.NewCorrelationID(), "update_id", ctx.UpdateId)
+	if ctx.EffectiveUser != nil {
+		logger = logger.With("user_id", ctx.EffectiveUser.Id)
+	}
+	if ctx.EffectiveChat != nil {
+		logger = logger.With("chat_id", ctx.EffectiveChat.Id)
+	}
+	if ctx.Data == nil {
+		ctx.Data = make(map[string]interface{})
+	}
+	ctx.Data[updateLoggerKey] = logger
+	return logger
+}
+
+// updateContext returns a context which carries the logger of an update
+func updateContext(ctx *ext.Context) context.Context {
+	return logging.WithLogger(context.Background(), updateLogger(ctx))
+}
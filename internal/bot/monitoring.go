@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"RedditDownloaderBot/pkg/logging"
+	"RedditDownloaderBot/pkg/metrics"
+	"RedditDownloaderBot/pkg/reddit"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// startMonitoringServer starts the HTTP server which serves the metrics and the health checks of the bot.
+// Returns nil if no monitoring address is configured.
+func (c *Client) startMonitoringServer() *http.Server {
+	if c.MonitoringAddress == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", healthHandler(c.livenessChecks))
+	mux.Handle("/readyz", healthHandler(c.readinessChecks))
+	listener, err := net.Listen("tcp", c.MonitoringAddress)
+	if err != nil {
+		logging.Fatal("Cannot listen on the monitoring address", "error", err)
+	}
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Monitoring server failed", "error", err)
+		}
+	}()
+	slog.Info("Serving metrics and health checks", "address", listener.Addr().String())
+	return server
+}
+
+// recordRequest records the outcome of fetching a post which a user has requested
+func recordRequest(result any, fetchErr *reddit.FetchError) {
+	if fetchErr != nil {
+		metrics.Requests.WithLabelValues("unknown", metrics.OutcomeFailure).Inc()
+		metrics.FetchErrors.WithLabelValues(string(fetchErr.Category)).Inc()
+		return
+	}
+	metrics.Requests.WithLabelValues(postTypeLabel(result), metrics.OutcomeSuccess).Inc()
+}
+
+// postTypeLabel returns the type of fetched post to be used as a metric label
+func postTypeLabel(result any) string {
+	switch data := result.(type) {
+	case reddit.FetchResultText:
+		return "text"
+	case reddit.FetchResultComment:
+		return "comment"
+	case reddit.FetchResultMedia:
+		switch data.Type {
+		case reddit.FetchResultMediaTypePhoto:
+			return "photo"
+		case reddit.FetchResultMediaTypeGif:
+			return "gif"
+		case reddit.FetchResultMediaTypeVideo:
+			return "video"
+		}
+	case reddit.FetchResultAlbum:
+		return "album"
+	}
+	return "unknown"
+}
@@ -0,0 +1,281 @@
+package bot
+
+import (
+	"RedditDownloaderBot/pkg/logging"
+	"RedditDownloaderBot/pkg/reddit"
+	"context"
+	"fmt"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"log/slog"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of the error reports which are sent to the admin chats
+const (
+	// Reports with the same category and error are sent once in this window.
+	// The number of the suppressed duplicates is sent with the next report.
+	reportDuplicateWindow = 10 * time.Minute
+	// At most this many reports are sent in each minute. Other reports are dropped.
+	maxReportsPerMinute = 10
+	// The default duration of /mute
+	defaultMuteDuration = time.Hour
+	// The maximum number of stack lines in each report
+	maxReportStackLines = 20
+)
+
+// errorReport is an error which is sent to the admin chats
+type errorReport struct {
+	// Category is the kind of the error, like "panic" or "upload"
+	Category string
+	// PostURL is the post which was being processed. Might be empty.
+	PostURL string
+	// Error is the error message
+	Error string
+	// Stack is the stack trace of a panic or of the place which reported the error
+	Stack string
+}
+
+// reportDuplicates tracks the duplicates of a report
+type reportDuplicates struct {
+	firstSent  time.Time
+	suppressed int
+}
+
+// adminNotifier sends de-duplicated and rate-limited error reports to the admin chats
+type adminNotifier struct {
+	bot *gotgbot.Bot
+	// Returns the chats which the reports are sent to
+	chats func() []int64
+	// The reports which have been sent in the duplicate window, keyed by their category and error
+	sent map[string]*reportDuplicates
+	// The times of the reports which have been sent in the last minute
+	recentlySent []time.Time
+	// The number of reports which were dropped because of the rate limit
+	dropped    int
+	mutedUntil time.Time
+	lock       sync.Mutex
+}
+
+// newAdminNotifier creates an adminNotifier which sends the reports to the chats returned by chats
+func newAdminNotifier(bot *gotgbot.Bot, chats func() []int64) *adminNotifier {
+	return &adminNotifier{
+		bot:   bot,
+		chats: chats,
+		sent:  make(map[string]*reportDuplicates),
+	}
+}
+
+// report sends an error report to the admin chats unless it is a duplicate, the notifications
+// are muted, or too many reports have been sent recently. It does not block.
+func (n *adminNotifier) report(report errorReport) {
+	chats := n.chats()
+	if len(chats) == 0 {
+		return
+	}
+	now := time.Now()
+	n.lock.Lock()
+	if now.Before(n.mutedUntil) {
+		n.lock.Unlock()
+		return
+	}
+	// Drop the duplicates
+	key := report.Category + "\x00" + report.Error
+	duplicates := n.sent[key]
+	if duplicates != nil && now.Sub(duplicates.firstSent) < reportDuplicateWindow {
+		duplicates.suppressed++
+		n.lock.Unlock()
+		return
+	}
+	// Apply the rate limit
+	for len(n.recentlySent) > 0 && now.Sub(n.recentlySent[0]) >= time.Minute {
+		n.recentlySent = n.recentlySent[1:]
+	}
+	if len(n.recentlySent) >= maxReportsPerMinute {
+		n.dropped++
+		n.lock.Unlock()
+		return
+	}
+	n.recentlySent = append(n.recentlySent, now)
+	for k, d := range n.sent {
+		if now.Sub(d.firstSent) >= reportDuplicateWindow {
+			delete(n.sent, k)
+		}
+	}
+	n.sent[key] = &reportDuplicates{firstSent: now}
+	var suppressed int
+	if duplicates != nil {
+		suppressed = duplicates.suppressed
+	}
+	dropped := n.dropped
+	n.dropped = 0
+	n.lock.Unlock()
+	text := formatErrorReport(report, suppressed, dropped)
+	go func() {
+		for _, chatID := range chats {
+			if _, err := n.bot.SendMessage(chatID, text, nil); err != nil {
+				slog.Warn("Cannot send the error report to the admin chat", "chat_id", chatID, "error", err)
+			}
+		}
+	}()
+}
+
+// toggleMute mutes the reports for duration if they are not muted. Otherwise, it unmutes them.
+// Returns true if the reports are muted now.
+func (n *adminNotifier) toggleMute(duration time.Duration) bool {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if time.Now().Before(n.mutedUntil) {
+		n.mutedUntil = time.Time{}
+		return false
+	}
+	n.mutedUntil = time.Now().Add(duration)
+	return true
+}
+
+// mute mutes the reports for duration, replacing the previous mute if there is any
+func (n *adminNotifier) mute(duration time.Duration) {
+	n.lock.Lock()
+	n.mutedUntil = time.Now().Add(duration)
+	n.lock.Unlock()
+}
+
+// formatErrorReport creates the message of an error report. The secrets are redacted.
+func formatErrorReport(report errorReport, suppressed, dropped int) string {
+	var text strings.Builder
+	text.WriteString("⚠️ Error: " + report.Category + "\n")
+	if report.PostURL != "" {
+		text.WriteString("Post: " + report.PostURL + "\n")
+	}
+	text.WriteString("\n" + report.Error + "\n")
+	if report.Stack != "" {
+		text.WriteString("\nStack:\n" + report.Stack + "\n")
+	}
+	if suppressed > 0 {
+		text.WriteString("\n" + strconv.Itoa(suppressed) + " similar errors were not reported in the last " + reportDuplicateWindow.String() + ".")
+	}
+	if dropped > 0 {
+		text.WriteString("\n" + strconv.Itoa(dropped) + " other errors were not reported because of the rate limit.")
+	}
+	return truncateUTF8(logging.Redact(text.String()), maxTextSize)
+}
+
+// trimStack keeps the first lines of a stack trace which are useful in a report. The frames
+// of the runtime and the recovering function are removed.
+func trimStack(stack string) string {
+	lines := strings.Split(strings.TrimSpace(stack), "\n")
+	// A panic stack looks like the goroutine header, then the frames of debug.Stack, the deferred
+	// function, and runtime.gopanic, each taking two lines. The panicking frame comes after them.
+	for i, line := range lines {
+		if strings.HasPrefix(line, "panic(") {
+			lines = lines[i+2:]
+			break
+		}
+	}
+	if len(lines) > maxReportStackLines {
+		lines = lines[:maxReportStackLines]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// callerStack returns the stack of the caller of the function which calls it
+func callerStack() string {
+	pcs := make([]uintptr, maxReportStackLines/2)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	var stack strings.Builder
+	for {
+		frame, more := frames.Next()
+		stack.WriteString(frame.Function + "\n\t" + frame.File + ":" + strconv.Itoa(frame.Line) + "\n")
+		if !more {
+			break
+		}
+	}
+	return strings.TrimSpace(stack.String())
+}
+
+// reportError reports an error to the admin chats
+func (c *Client) reportError(category, postURL string, err error) {
+	c.notifier.report(errorReport{
+		Category: category,
+		PostURL:  postURL,
+		Error:    err.Error(),
+		Stack:    callerStack(),
+	})
+}
+
+// reportPanic reports a recovered panic to the admin chats. It must be called in the
+// deferred function which has recovered the panic.
+func (c *Client) reportPanic(postURL string, recovered any) {
+	c.notifier.report(errorReport{
+		Category: "panic",
+		PostURL:  postURL,
+		Error:    fmt.Sprint(recovered),
+		Stack:    trimStack(string(debug.Stack())),
+	})
+}
+
+// withErrorReporting returns a context which reports the errors which the downloads of a post
+// swallow, like the failed merges, to the admin chats
+func (c *Client) withErrorReporting(ctx context.Context, postURL string) context.Context {
+	return reddit.WithErrorReporter(ctx, func(category string, err error) {
+		c.notifier.report(errorReport{
+			Category: category,
+			PostURL:  postURL,
+			Error:    err.Error(),
+		})
+	})
+}
+
+// reportFetchError reports the fetch errors which are caused by bugs to the admin chats
+func (c *Client) reportFetchError(postURL string, fetchErr *reddit.FetchError) {
+	if fetchErr == nil || fetchErr.Category != reddit.FetchErrorCategoryInternal {
+		return
+	}
+	c.notifier.report(errorReport{
+		Category: "fetch",
+		PostURL:  postURL,
+		Error:    fetchErr.NormalError,
+		Stack:    trimStack(fetchErr.Stack),
+	})
+}
+
+// canMuteReports checks if the sender of a message can mute the error reports. The admins
+// can mute them in any chat and everyone can mute them in the admin chats.
+func (c *Client) canMuteReports(ctx *ext.Context) bool {
+	settings := c.settings()
+	return c.isAdmin(ctx.EffectiveUser.Id) || slices.Contains(settings.AdminChats, ctx.EffectiveChat.Id)
+}
+
+// handleMuteCommand handles the /mute command. The argument is the duration of the mute, like 30m,
+// and always (re)mutes the reports. Without an argument, the command toggles the reports and
+// mutes them for an hour.
+func (c *Client) handleMuteCommand(bot *gotgbot.Bot, ctx *ext.Context, argument string) error {
+	if argument != "" {
+		duration, err := time.ParseDuration(argument)
+		if err != nil || duration <= 0 {
+			_, err = ctx.EffectiveMessage.Reply(bot, "Please send the duration like /mute 30m or /mute 2h.", nil)
+			return err
+		}
+		c.notifier.mute(duration)
+		updateLogger(ctx).Info("Error reports are muted", "duration", duration)
+		_, err = ctx.EffectiveMessage.Reply(bot, "Error reports are muted for "+duration.String()+". Send /mute to unmute them.", nil)
+		return err
+	}
+	duration := defaultMuteDuration
+	var text string
+	if c.notifier.toggleMute(duration) {
+		updateLogger(ctx).Info("Error reports are muted", "duration", duration)
+		text = "Error reports are muted for " + duration.String() + ". Send /mute again to unmute them."
+	} else {
+		updateLogger(ctx).Info("Error reports are unmuted")
+		text = "Error reports are unmuted."
+	}
+	_, err := ctx.EffectiveMessage.Reply(bot, text, nil)
+	return err
+}
@@ -0,0 +1,241 @@
+package bot
+
+import (
+	"RedditDownloaderBot/pkg/logging"
+	"RedditDownloaderBot/pkg/reddit"
+	"context"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// progressFirstMessageDelay is the time which we wait before sending the progress message.
+// Jobs which finish before this, like sending the cached files, do not show any progress.
+const progressFirstMessageDelay = time.Second
+
+// progressUpdateInterval is the interval which the progress message is edited in
+const progressUpdateInterval = 3 * time.Second
+
+// progressGroupUpdateInterval is progressUpdateInterval in groups. It is longer because
+// Telegram only allows 20 messages per minute in groups and edits count as messages.
+const progressGroupUpdateInterval = 15 * time.Second
+
+// The texts of the phases of a job
+const (
+	progressTextFetching     = "Fetching the media…"
+	progressTextMerging      = "Merging the video and audio…"
+	progressTextSplitting    = "Splitting the video into parts…"
+	progressTextQueuedEncode = "Waiting to re-encode the video…"
+	progressTextUploading    = "Uploading to Telegram…"
+	progressTextCanceled     = "Canceled."
+)
+
+// jobProgressKey is the key of jobProgress in contexts
+type jobProgressKey struct{}
+
+// jobProgress shows the progress of a job in a message which is edited periodically.
+// The message has a button to cancel the job.
+type jobProgress struct {
+	bot    *gotgbot.Bot
+	logger *slog.Logger
+	chatID int64
+	jobID  uint64
+	// The text which must be shown
+	text string
+	// The text which the message is showing
+	shownText string
+	// The ID of the progress message. Zero if not sent yet.
+	messageID int64
+	// The total bytes which this job has downloaded
+	downloadedBytes int64
+	// The phase and downloaded bytes of the last download report. Used to find out
+	// how many bytes were downloaded since the last report.
+	lastPhase      reddit.ProgressPhase
+	lastDownloaded int64
+	lock           sync.Mutex
+}
+
+// startJobProgress starts showing the progress of a job. The returned context must be passed
+// to the downloads and uploads of the job. stop must be called when the job is done; it
+// removes the progress message or replaces it with a cancellation notice and returns the
+// number of bytes which the job has downloaded.
+func startJobProgress(ctx context.Context, bot *gotgbot.Bot, chatID int64, jobID uint64) (context.Context, func() int64) {
+	p := &jobProgress{
+		bot:    bot,
+		logger: logging.FromContext(ctx),
+		chatID: chatID,
+		jobID:  jobID,
+		text:   progressTextFetching,
+	}
+	ctx = context.WithValue(ctx, jobProgressKey{}, p)
+	ctx = reddit.WithProgress(ctx, p.reportDownload)
+	stopChan := make(chan struct{})
+	stoppedChan := make(chan struct{})
+	go func() {
+		defer close(stoppedChan)
+		p.updater(ctx, stopChan)
+	}()
+	return ctx, func() int64 {
+		close(stopChan)
+		<-stoppedChan
+		p.finish(ctx.Err() != nil)
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.downloadedBytes
+	}
+}
+
+// jobProgressFromContext returns the jobProgress of a job. The result might be nil, which is
+// safe to use.
+func jobProgressFromContext(ctx context.Context) *jobProgress {
+	p, _ := ctx.Value(jobProgressKey{}).(*jobProgress)
+	return p
+}
+
+// setText changes the text of the progress message
+func (p *jobProgress) setText(text string) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	p.text = text
+	p.lock.Unlock()
+}
+
+// uploading reports that the job is uploading its files to Telegram
+func (p *jobProgress) uploading() {
+	p.setText(progressTextUploading)
+}
+
+// reportDownload is the reddit.ProgressFunc of the job
+func (p *jobProgress) reportDownload(phase reddit.ProgressPhase, downloaded, total int64) {
+	p.lock.Lock()
+	if phase == reddit.ProgressPhaseDownloading || phase == reddit.ProgressPhaseDownloadingAudio {
+		// A new download starts with a report of zero bytes
+		if phase == p.lastPhase && downloaded >= p.lastDownloaded {
+			p.downloadedBytes += downloaded - p.lastDownloaded
+		} else {
+			p.downloadedBytes += downloaded
+		}
+	}
+	p.lastPhase, p.lastDownloaded = phase, downloaded
+	p.text = formatDownloadProgress(phase, downloaded, total)
+	p.lock.Unlock()
+}
+
+// updater sends and edits the progress message until stopChan or ctx is done
+func (p *jobProgress) updater(ctx context.Context, stopChan <-chan struct{}) {
+	updateInterval := progressUpdateInterval
+	if p.chatID < 0 {
+		updateInterval = progressGroupUpdateInterval
+	}
+	timer := time.NewTimer(progressFirstMessageDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			p.update()
+			timer.Reset(updateInterval)
+		case <-stopChan:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// update sends the progress message or edits it if the text has changed
+func (p *jobProgress) update() {
+	p.lock.Lock()
+	text := p.text
+	p.lock.Unlock()
+	if text == p.shownText {
+		return
+	}
+	if p.messageID == 0 {
+		message, err := p.bot.SendMessage(p.chatID, text, &gotgbot.SendMessageOpts{
+			ReplyMarkup: p.cancelKeyboard(),
+		})
+		if err != nil {
+			p.logger.Warn("Cannot send the progress message", "error", err)
+			return
+		}
+		p.messageID = message.MessageId
+	} else {
+		_, _, err := p.bot.EditMessageText(text, &gotgbot.EditMessageTextOpts{
+			ChatId:      p.chatID,
+			MessageId:   p.messageID,
+			ReplyMarkup: p.cancelKeyboard(),
+		})
+		if err != nil {
+			return
+		}
+	}
+	p.shownText = text
+}
+
+// finish removes the progress message. If the job was canceled, the message is
+// replaced with a cancellation notice instead.
+func (p *jobProgress) finish(canceled bool) {
+	if p.messageID == 0 {
+		return
+	}
+	if canceled {
+		_, _, _ = p.bot.EditMessageText(progressTextCanceled, &gotgbot.EditMessageTextOpts{
+			ChatId:    p.chatID,
+			MessageId: p.messageID,
+		})
+		return
+	}
+	_, _ = p.bot.DeleteMessage(p.chatID, p.messageID, nil)
+}
+
+// cancelKeyboard creates the keyboard which has the cancel button of the job
+func (p *jobProgress) cancelKeyboard() gotgbot.InlineKeyboardMarkup {
+	return gotgbot.InlineKeyboardMarkup{
+		InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
+			Text: "Cancel",
+			CallbackData: CallbackButtonData{
+				ID:   strconv.FormatUint(p.jobID, 10),
+				Mode: CallbackButtonDataModeCancel,
+			}.String(),
+		}}},
+	}
+}
+
+// formatDownloadProgress creates the text of the progress message while downloading
+func formatDownloadProgress(phase reddit.ProgressPhase, downloaded, total int64) string {
+	var text string
+	switch phase {
+	case reddit.ProgressPhaseDownloadingAudio:
+		text = "Downloading the audio…"
+	case reddit.ProgressPhaseMerging:
+		return progressTextMerging
+	case reddit.ProgressPhaseSplitting:
+		return progressTextSplitting
+	case reddit.ProgressPhaseWaitingToEncode:
+		return progressTextQueuedEncode
+	case reddit.ProgressPhaseEncoding:
+		// The progress is in milliseconds of the video
+		text = "Re-encoding the video…"
+		if total > 0 {
+			text += " " + strconv.FormatInt(downloaded*100/total, 10) + "%"
+		}
+		return text
+	default:
+		text = "Downloading…"
+	}
+	if total > 0 {
+		text += " " + strconv.FormatInt(downloaded*100/total, 10) + "% (" + formatSize(downloaded) + " of " + formatSize(total) + ")"
+	} else {
+		text += " " + formatSize(downloaded)
+	}
+	return text
+}
+
+// formatSize formats a size in bytes as megabytes
+func formatSize(size int64) string {
+	return strconv.FormatFloat(float64(size)/(1000*1000), 'f', 1, 64) + " MB"
+}
@@ -0,0 +1,153 @@
+package bot
+
+import (
+	"RedditDownloaderBot/internal/usage"
+	"RedditDownloaderBot/pkg/reddit"
+	"RedditDownloaderBot/pkg/util"
+	"context"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/go-faster/errors"
+)
+
+// autoQuality checks if the best quality of the media must be downloaded for a user without asking them
+func (c *Client) autoQuality(userID int64) bool {
+	user, err := c.Usage.GetUser(userID)
+	if err == nil {
+		switch user.QualityMode {
+		case usage.QualityModeAuto:
+			return true
+		case usage.QualityModeAsk:
+			return false
+		}
+	}
+	return c.settings().AutoQuality
+}
+
+// sendBestQuality downloads the best quality of a media which can be uploaded to Telegram.
+// The user is told which quality is being downloaded and can choose another one with a button.
+// If the sizes of the qualities could not be estimated, nothing is sent and false is returned.
+func (c *Client) sendBestQuality(bot *gotgbot.Bot, ctx *ext.Context, data reddit.FetchResultMedia, postUrl, postFullname string) (bool, error) {
+	c.RedditOauth.EstimateSizes(updateContext(ctx), data.Medias)
+	index := data.SelectQuality(c.MaxUploadSize())
+	if index == -1 {
+		return false, nil
+	}
+	media := data.Medias[index]
+	size := media.Size
+	thumbnailLink := data.ThumbnailLinks.SelectThumbnail(maxThumbnailDimensions)
+	chatID := ctx.EffectiveChat.Id
+	var run func(jobCtx context.Context) error
+	switch data.Type {
+	case reddit.FetchResultMediaTypePhoto:
+		run = func(jobCtx context.Context) error {
+			return c.handlePhotoUpload(jobCtx, bot, media.Link, data.Title, thumbnailLink, postUrl, postFullname, data.Description, chatID, true)
+		}
+	case reddit.FetchResultMediaTypeGif:
+		run = func(jobCtx context.Context) error {
+			return c.handleGifUpload(jobCtx, bot, media.Link, data.Title, thumbnailLink, postUrl, postFullname, data.Description, media.Dim, chatID)
+		}
+	case reddit.FetchResultMediaTypeVideo:
+		audioLink := ""
+		if audioIndex, hasAudio := data.HasAudio(); hasAudio {
+			audioLink = data.Medias[audioIndex].Link
+			size += data.Medias[audioIndex].Size
+		}
+		run = func(jobCtx context.Context) error {
+			return c.handleVideoUpload(jobCtx, bot, media.Link, audioLink, data.Title, thumbnailLink, postUrl, postFullname, data.Description, media.Dim, data.Duration, chatID)
+		}
+	default:
+		return false, nil
+	}
+	_, err := ctx.EffectiveMessage.Reply(bot, "Downloading "+media.Quality+" (about "+formatSize(size)+").", &gotgbot.SendMessageOpts{
+		ReplyMarkup: gotgbot.InlineKeyboardMarkup{
+			InlineKeyboard: [][]gotgbot.InlineKeyboardButton{{{
+				Text: "Choose quality",
+				CallbackData: CallbackButtonData{
+					ID:   postFullname,
+					Mode: CallbackButtonDataModeChooseQuality,
+				}.String(),
+			}}},
+		},
+	})
+	if err != nil {
+		updateLogger(ctx).Warn("Cannot send the selected quality", "error", err)
+	}
+	return true, c.enqueueJob(bot, ctx, postUrl, run)
+}
+
+// tooLargeMarkedNote starts the notes of the qualities which are too large for Telegram
+const tooLargeMarkedNote = "⚠️ The sizes are estimates. The marked qualities are larger than what Telegram accepts"
+
+// unsendableNote is the note of the qualities which are too large to be sent in any way
+const unsendableNote = tooLargeMarkedNote + " and cannot be sent."
+
+// tooLargeNote explains what happens to the qualities of a media type which are too large for Telegram.
+// sendLimit is the size of the largest quality which can still be sent, for example by splitting it
+// into parts or with the file server. The larger ones are not even downloaded.
+func (c *Client) tooLargeNote(mediaType reddit.FetchResultMediaType) (note string, sendLimit int64) {
+	switch {
+	case mediaType == reddit.FetchResultMediaTypeVideo && util.DoesFfmpegExists():
+		// See reddit.Oauth.DownloadVideo
+		sendLimit = max(reddit.MaxSplittableVideoSize, c.MaxDownloadSize())
+		if c.settings().ReencodeOversizedVideos {
+			return tooLargeMarkedNote + ", so they will be re-encoded or split into parts.", sendLimit
+		}
+		return tooLargeMarkedNote + ", so they will be split into parts.", sendLimit
+	case c.Files != nil:
+		return tooLargeMarkedNote + ", so you will get a download link.", c.MaxDownloadSize()
+	default:
+		return unsendableNote, c.MaxUploadSize()
+	}
+}
+
+// handleChooseQualityCallback shows the qualities of a post which was downloaded in the auto quality mode
+func (c *Client) handleChooseQualityCallback(bot *gotgbot.Bot, ctx *ext.Context, data CallbackButtonData) error {
+	link := reddit.LinkFromFullname(data.ID)
+	if link == "" {
+		_, err := ctx.CallbackQuery.Answer(bot, &gotgbot.AnswerCallbackQueryOpts{Text: "Please resend the link."})
+		return err
+	}
+	_, _ = ctx.CallbackQuery.Answer(bot, nil)
+	// Remove the button, so the keyboard is not sent twice
+	_, _, _ = ctx.EffectiveMessage.EditReplyMarkup(bot, nil)
+	return c.fetchPostDetailsAndSend(bot, ctx, link, true)
+}
+
+// handleQualityCommand shows or changes how the quality of the media is chosen for the user
+func (c *Client) handleQualityCommand(bot *gotgbot.Bot, ctx *ext.Context, argument string) error {
+	var mode usage.QualityMode
+	var text string
+	switch argument {
+	case "":
+		current := "ask"
+		if c.autoQuality(ctx.EffectiveUser.Id) {
+			current = "auto"
+		}
+		_, err := ctx.EffectiveMessage.Reply(bot, "Quality mode: "+current+"\n\n"+
+			"/quality auto - Download the best quality which can be sent on Telegram\n"+
+			"/quality ask - Ask me for the quality\n"+
+			"/quality default - Use the default of the bot", nil)
+		return err
+	case string(usage.QualityModeAuto):
+		mode, text = usage.QualityModeAuto, "I’ll download the best quality which can be sent on Telegram. You can still choose another quality with the button below the message."
+	case string(usage.QualityModeAsk):
+		mode, text = usage.QualityModeAsk, "I’ll ask you for the quality."
+	case "default":
+		mode, text = usage.QualityModeDefault, "I’ll use the default quality mode."
+	default:
+		_, err := ctx.EffectiveMessage.Reply(bot, "Unknown mode. Send /quality to see the modes.", nil)
+		return err
+	}
+	err := c.Usage.SetQualityMode(ctx.EffectiveUser.Id, mode)
+	if errors.Is(err, usage.NotFoundErr) {
+		_, err = ctx.EffectiveMessage.Reply(bot, "Please send this command in a private chat with me.", nil)
+		return err
+	} else if err != nil {
+		updateLogger(ctx).Error("Cannot change the quality mode", "error", err)
+		_, err = ctx.EffectiveMessage.Reply(bot, "Cannot change your quality mode. Please try again later.", nil)
+		return err
+	}
+	_, err = ctx.EffectiveMessage.Reply(bot, text, nil)
+	return err
+}
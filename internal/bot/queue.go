@@ -0,0 +1,387 @@
+package bot
+
+import (
+	"RedditDownloaderBot/pkg/logging"
+	"RedditDownloaderBot/pkg/metrics"
+	"context"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/go-faster/errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QueueOptions configures the queue which the downloads and uploads are run in
+type QueueOptions struct {
+	// Workers is the number of jobs which can run at the same time
+	Workers int
+	// MaxJobsPerUser is the number of jobs of a single user which can run at the same time.
+	// Other jobs of the user wait in the queue. Zero means no limit.
+	MaxJobsPerUser int
+}
+
+// queuedJob is a download or upload which is waiting in the queue or is running
+type queuedJob struct {
+	userID int64
+	chatID int64
+	admin  bool
+	// The link of the post which this job downloads. It is included in the panic reports.
+	postURL string
+	// The logger of the update which has created this job
+	logger *slog.Logger
+	run    func(ctx context.Context) error
+	// Called with the downloaded bytes when the job is done or dropped. Might be nil.
+	finished func(downloadedBytes int64)
+	// The message which shows the position of this job in the queue. Zero if not sent yet.
+	statusMessageID int64
+	// The position which the status message is showing
+	shownPosition int
+	// True if a worker has picked this job
+	started bool
+}
+
+// finish calls the finished callback of the job, if there is any
+func (j *queuedJob) finish(downloadedBytes int64) {
+	if j.finished != nil {
+		j.finished(downloadedBytes)
+	}
+}
+
+// jobQueue runs the jobs of the users with a fixed number of workers. The jobs wait in the
+// queue while there is no free worker or while the user has too many running jobs.
+type jobQueue struct {
+	bot     *gotgbot.Bot
+	tracker *jobTracker
+	options QueueOptions
+	// Checks if a user is an admin. The jobs of admins are run before the jobs of other users.
+	isAdmin func(userID int64) bool
+	// Reports the panics of the jobs. Called in the deferred function which has recovered the panic.
+	reportPanic func(postURL string, recovered any)
+	// The jobs which are waiting. Admin jobs are always before other jobs.
+	pending []*queuedJob
+	// Number of running jobs of each user
+	running map[int64]int
+	closed  bool
+	lock    sync.Mutex
+	// Workers wait on this for new jobs
+	wake *sync.Cond
+	// Notifies the status updater that the positions have changed
+	positionsChanged chan struct{}
+	// Held while a status message is sent, edited, or deleted, so a position is never
+	// edited while the message is deleted because its job has started
+	statusLock sync.Mutex
+	// Closed by close to stop the status updater
+	done chan struct{}
+	// Closed when the status updater has stopped
+	statusUpdaterDone chan struct{}
+	// Done when all the workers have returned after close
+	workers sync.WaitGroup
+}
+
+// newJobQueue creates a new queue and starts its workers
+func newJobQueue(bot *gotgbot.Bot, tracker *jobTracker, options QueueOptions, isAdmin func(userID int64) bool, reportPanic func(postURL string, recovered any)) *jobQueue {
+	if options.Workers <= 0 {
+		options.Workers = 1
+	}
+	q := &jobQueue{
+		bot:               bot,
+		tracker:           tracker,
+		options:           options,
+		isAdmin:           isAdmin,
+		reportPanic:       reportPanic,
+		running:           make(map[int64]int),
+		positionsChanged:  make(chan struct{}, 1),
+		done:              make(chan struct{}),
+		statusUpdaterDone: make(chan struct{}),
+	}
+	q.wake = sync.NewCond(&q.lock)
+	q.workers.Add(options.Workers)
+	for range options.Workers {
+		go q.worker()
+	}
+	go q.statusUpdater()
+	return q
+}
+
+// enqueue adds a job to the queue. Returns false if the queue is closed.
+func (q *jobQueue) enqueue(userID, chatID int64, postURL string, logger *slog.Logger, run func(ctx context.Context) error, finished func(downloadedBytes int64)) bool {
+	job := &queuedJob{
+		userID:   userID,
+		chatID:   chatID,
+		admin:    q.isAdmin(userID),
+		postURL:  postURL,
+		logger:   logger,
+		run:      run,
+		finished: finished,
+	}
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return false
+	}
+	// Admin jobs go after other admin jobs but before the jobs of normal users
+	index := len(q.pending)
+	if job.admin {
+		for i, pendingJob := range q.pending {
+			if !pendingJob.admin {
+				index = i
+				break
+			}
+		}
+	}
+	q.pending = append(q.pending, nil)
+	copy(q.pending[index+1:], q.pending[index:])
+	q.pending[index] = job
+	metrics.QueueDepth.Set(float64(len(q.pending)))
+	q.wake.Signal()
+	q.lock.Unlock()
+	q.notifyPositionsChanged()
+	return true
+}
+
+// stats returns the number of the jobs which are waiting and running
+func (q *jobQueue) stats() (waiting, running int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, count := range q.running {
+		running += count
+	}
+	return len(q.pending), running
+}
+
+// close stops the workers from picking new jobs and the status updater, and returns the jobs
+// which were still waiting. Their status messages are not changed after close returns.
+func (q *jobQueue) close() []queuedJob {
+	q.lock.Lock()
+	q.closed = true
+	pendingJobs := q.pending
+	for _, job := range pendingJobs {
+		// Prevent the status updater from sending new status messages
+		job.started = true
+	}
+	q.pending = nil
+	metrics.QueueDepth.Set(0)
+	q.wake.Broadcast()
+	q.lock.Unlock()
+	// Wait for the status message which might be being sent
+	close(q.done)
+	<-q.statusUpdaterDone
+	pending := make([]queuedJob, len(pendingJobs))
+	for i, job := range pendingJobs {
+		pending[i] = *job
+	}
+	return pending
+}
+
+// wait waits for the workers to return after close. The running jobs are not canceled, so
+// this should be called after they are stopped. Returns false on timeout.
+func (q *jobQueue) wait(timeout time.Duration) bool {
+	workersDone := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(workersDone)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-workersDone:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// enqueueJob adds a download or upload to the queue. If the user has reached their limits
+// or the bot is shutting down, the user is informed instead. postURL is the link of the post
+// which the job downloads.
+func (c *Client) enqueueJob(bot *gotgbot.Bot, ctx *ext.Context, postURL string, run func(jobCtx context.Context) error) error {
+	userID := ctx.EffectiveUser.Id
+	if ok, retryAfter := c.limiter.allowDownload(userID); !ok {
+		_, err := ctx.EffectiveChat.SendMessage(bot, "You’ve reached your daily download limit. Please try again in "+formatRetryAfter(retryAfter)+".", nil)
+		return err
+	}
+	releaseJob, ok := c.limiter.acquireJob(userID)
+	if !ok {
+		_, err := ctx.EffectiveChat.SendMessage(bot, "You already have too many downloads in progress. Please wait for them to finish.", nil)
+		return err
+	}
+	finished := func(downloadedBytes int64) {
+		c.limiter.addDownloadedBytes(userID, downloadedBytes)
+		releaseJob()
+	}
+	if c.queue.enqueue(userID, ctx.EffectiveChat.Id, postURL, updateLogger(ctx), run, finished) {
+		return nil
+	}
+	finished(0)
+	_, err := ctx.EffectiveChat.SendMessage(bot, restartingMessage, nil)
+	return err
+}
+
+// worker runs the jobs in the queue until the queue is closed
+func (q *jobQueue) worker() {
+	defer q.workers.Done()
+	for {
+		q.lock.Lock()
+		job := q.next()
+		for job == nil && !q.closed {
+			q.wake.Wait()
+			job = q.next()
+		}
+		if job == nil { // closed
+			q.lock.Unlock()
+			return
+		}
+		job.started = true
+		q.running[job.userID]++
+		q.lock.Unlock()
+		q.notifyPositionsChanged()
+		q.deleteStatusMessage(job)
+		q.runJob(job)
+		// Let other jobs of this user run
+		q.lock.Lock()
+		q.running[job.userID]--
+		if q.running[job.userID] == 0 {
+			delete(q.running, job.userID)
+		}
+		q.wake.Broadcast()
+		q.lock.Unlock()
+	}
+}
+
+// runJob runs a job as a tracked job so that the shutdown waits for it
+func (q *jobQueue) runJob(job *queuedJob) {
+	ctx, jobID, done, ok := q.tracker.start(job.chatID, job.userID)
+	if !ok {
+		job.finish(0)
+		_, _ = q.bot.SendMessage(job.chatID, restartingMessage, nil)
+		return
+	}
+	defer done()
+	ctx = logging.WithLogger(ctx, job.logger.With("job_id", jobID))
+	metrics.RunningJobs.Inc()
+	defer metrics.RunningJobs.Dec()
+	ctx, stopProgress := startJobProgress(ctx, q.bot, job.chatID, jobID)
+	defer func() {
+		job.finish(stopProgress())
+	}()
+	// Don't crash!
+	defer func() {
+		if r := recover(); r != nil {
+			_, _ = q.bot.SendMessage(job.chatID, "Cannot get data. (panic)", nil)
+			logging.FromContext(ctx).Error("Recovering from panic in job", "panic", r)
+			q.reportPanic(job.postURL, r)
+		}
+	}()
+	if err := job.run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		logging.FromContext(ctx).Error("An error occurred while running a job", "error", err)
+	}
+}
+
+// next removes the first job which can be run from the pending jobs and returns it.
+// Returns nil if there is no such job. The lock must be held.
+func (q *jobQueue) next() *queuedJob {
+	for i, job := range q.pending {
+		if q.options.MaxJobsPerUser > 0 && q.running[job.userID] >= q.options.MaxJobsPerUser {
+			continue
+		}
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+		metrics.QueueDepth.Set(float64(len(q.pending)))
+		return job
+	}
+	return nil
+}
+
+// notifyPositionsChanged asks the status updater to update the status messages
+func (q *jobQueue) notifyPositionsChanged() {
+	select {
+	case q.positionsChanged <- struct{}{}:
+	default: // an update is already pending
+	}
+}
+
+// statusUpdater sends or edits the status messages of pending jobs when their position changes.
+// Multiple changes are coalesced into one update. It stops when the queue is closed.
+func (q *jobQueue) statusUpdater() {
+	defer close(q.statusUpdaterDone)
+	for {
+		select {
+		case <-q.positionsChanged:
+			q.updateStatusMessages()
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// deleteStatusMessage deletes the status message of a job which has started
+func (q *jobQueue) deleteStatusMessage(job *queuedJob) {
+	q.statusLock.Lock()
+	defer q.statusLock.Unlock()
+	q.lock.Lock()
+	statusMessageID := job.statusMessageID
+	q.lock.Unlock()
+	if statusMessageID != 0 {
+		_, _ = q.bot.DeleteMessage(job.chatID, statusMessageID, nil)
+	}
+}
+
+// statusUpdate is a status message which must be sent or edited
+type statusUpdate struct {
+	job      *queuedJob
+	position int
+}
+
+// updateStatusMessages sends or edits the status messages of the jobs whose position has changed
+func (q *jobQueue) updateStatusMessages() {
+	// Find the changed positions
+	q.lock.Lock()
+	var updates []statusUpdate
+	for i, job := range q.pending {
+		position := i + 1
+		if job.shownPosition != position {
+			job.shownPosition = position
+			updates = append(updates, statusUpdate{job: job, position: position})
+		}
+	}
+	q.lock.Unlock()
+	// Send them
+	for _, update := range updates {
+		select {
+		case <-q.done:
+			return
+		default:
+		}
+		q.sendStatusMessage(update)
+	}
+}
+
+// sendStatusMessage sends or edits the status message of a job unless the job has started.
+// The status lock keeps the worker which starts the job from deleting the message meanwhile.
+func (q *jobQueue) sendStatusMessage(update statusUpdate) {
+	q.statusLock.Lock()
+	defer q.statusLock.Unlock()
+	q.lock.Lock()
+	statusMessageID, started := update.job.statusMessageID, update.job.started
+	q.lock.Unlock()
+	if started {
+		return
+	}
+	text := "Your request is #" + strconv.Itoa(update.position) + " in the queue. I’ll start it as soon as possible."
+	if statusMessageID != 0 {
+		_, _, _ = q.bot.EditMessageText(text, &gotgbot.EditMessageTextOpts{
+			ChatId:    update.job.chatID,
+			MessageId: statusMessageID,
+		})
+		return
+	}
+	message, err := q.bot.SendMessage(update.job.chatID, text, nil)
+	if err != nil {
+		update.job.logger.Warn("Cannot send the queue position", "error", err)
+		return
+	}
+	q.lock.Lock()
+	update.job.statusMessageID = message.MessageId
+	q.lock.Unlock()
+}
@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"RedditDownloaderBot/internal/cache"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Keys and expiry times of the rate limit counters
+const (
+	requestsCounterPrefix = "requests:"
+	requestsCounterTTL    = 2 * time.Minute
+	// The jobs counter is decreased when each job is done. The TTL is refreshed whenever a job
+	// is acquired or released and is only a safety net for the replicas which crash while
+	// running a job.
+	jobsCounterPrefix  = "jobs:"
+	jobsCounterTTL     = time.Hour
+	bytesCounterPrefix = "bytes:"
+	bytesCounterTTL    = 25 * time.Hour
+)
+
+// UserLimits are the limits of a single user. Zero means no limit.
+type UserLimits struct {
+	// The number of links which the user can send in each minute
+	RequestsPerMinute int
+	// The number of downloads which the user can have in the queue or running at the same time
+	ConcurrentJobs int
+	// The number of bytes which the user can download each day (UTC)
+	BytesPerDay int64
+}
+
+// RateLimitOptions configures the per-user limits of the bot
+type RateLimitOptions struct {
+	// The limits of the users which are not in Overrides
+	Default UserLimits
+	// The limits of specific users
+	Overrides map[int64]UserLimits
+}
+
+// rateLimiter applies the RateLimitOptions. The counters are stored in the cache so that
+// all replicas share them. If the cache fails, the user is allowed.
+type rateLimiter struct {
+	cache cache.Interface
+	// Returns the current options. They can change while the bot is running.
+	options func() RateLimitOptions
+}
+
+// limits returns the limits of a user
+func (l rateLimiter) limits(userID int64) UserLimits {
+	options := l.options()
+	if limits, exists := options.Overrides[userID]; exists {
+		return limits
+	}
+	return options.Default
+}
+
+// allowRequest counts a request of the user. If the user has sent too many requests in this
+// minute, it returns false and the time until the user can send a request again.
+func (l rateLimiter) allowRequest(userID int64) (bool, time.Duration) {
+	limit := l.limits(userID).RequestsPerMinute
+	if limit <= 0 {
+		return true, 0
+	}
+	now := time.Now()
+	minute := now.Unix() / 60
+	count, err := l.cache.IncrementCounter(requestsCounterPrefix+strconv.FormatInt(userID, 10)+":"+strconv.FormatInt(minute, 10), 1, requestsCounterTTL)
+	if err != nil {
+		slog.Warn("Cannot count the requests", "user_id", userID, "error", err)
+		return true, 0
+	}
+	if count > int64(limit) {
+		return false, time.Unix((minute+1)*60, 0).Sub(now)
+	}
+	return true, 0
+}
+
+// acquireJob reserves a job for the user. ok is false if the user has too many jobs.
+// Otherwise, release must be called when the job is done. It only decrements the counter if
+// this call has incremented it, so the limits can be reloaded while the job is running.
+func (l rateLimiter) acquireJob(userID int64) (release func(), ok bool) {
+	limit := l.limits(userID).ConcurrentJobs
+	if limit <= 0 {
+		return func() {}, true
+	}
+	count, err := l.cache.IncrementCounter(jobsCounterPrefix+strconv.FormatInt(userID, 10), 1, jobsCounterTTL)
+	if err != nil {
+		slog.Warn("Cannot count the jobs", "user_id", userID, "error", err)
+		return func() {}, true
+	}
+	release = func() { l.releaseJob(userID) }
+	if count > int64(limit) {
+		release()
+		return nil, false
+	}
+	return release, true
+}
+
+// releaseJob decrements the jobs counter which was incremented by acquireJob
+func (l rateLimiter) releaseJob(userID int64) {
+	_, err := l.cache.IncrementCounter(jobsCounterPrefix+strconv.FormatInt(userID, 10), -1, jobsCounterTTL)
+	if err != nil {
+		slog.Warn("Cannot release the job", "user_id", userID, "error", err)
+	}
+}
+
+// allowDownload checks if the user has any daily quota left. If not, it returns false and the
+// time until the quota is reset.
+func (l rateLimiter) allowDownload(userID int64) (bool, time.Duration) {
+	limit := l.limits(userID).BytesPerDay
+	if limit <= 0 {
+		return true, 0
+	}
+	now := time.Now().UTC()
+	downloaded, err := l.cache.GetCounter(dailyBytesCounterKey(userID, now))
+	if err != nil {
+		slog.Warn("Cannot get the downloaded bytes", "user_id", userID, "error", err)
+		return true, 0
+	}
+	if downloaded >= limit {
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return false, tomorrow.Sub(now)
+	}
+	return true, 0
+}
+
+// addDownloadedBytes adds the bytes which the user has downloaded to the daily quota
+func (l rateLimiter) addDownloadedBytes(userID, downloaded int64) {
+	if downloaded <= 0 || l.limits(userID).BytesPerDay <= 0 {
+		return
+	}
+	_, err := l.cache.IncrementCounter(dailyBytesCounterKey(userID, time.Now().UTC()), downloaded, bytesCounterTTL)
+	if err != nil {
+		slog.Warn("Cannot add the downloaded bytes", "user_id", userID, "error", err)
+	}
+}
+
+// usage returns the current usage of the limits of a user. The usage of the limits which
+// are disabled is not counted and is zero.
+func (l rateLimiter) usage(userID int64) UserLimits {
+	now := time.Now()
+	var result UserLimits
+	requests, err := l.cache.GetCounter(requestsCounterPrefix + strconv.FormatInt(userID, 10) + ":" + strconv.FormatInt(now.Unix()/60, 10))
+	if err != nil {
+		slog.Warn("Cannot get the requests", "user_id", userID, "error", err)
+	}
+	result.RequestsPerMinute = int(requests)
+	jobs, err := l.cache.GetCounter(jobsCounterPrefix + strconv.FormatInt(userID, 10))
+	if err != nil {
+		slog.Warn("Cannot get the jobs", "user_id", userID, "error", err)
+	}
+	result.ConcurrentJobs = int(jobs)
+	result.BytesPerDay, err = l.cache.GetCounter(dailyBytesCounterKey(userID, now.UTC()))
+	if err != nil {
+		slog.Warn("Cannot get the downloaded bytes", "user_id", userID, "error", err)
+	}
+	return result
+}
+
+// dailyBytesCounterKey returns the key of the counter of downloaded bytes of a user in a day
+func dailyBytesCounterKey(userID int64, day time.Time) string {
+	return bytesCounterPrefix + strconv.FormatInt(userID, 10) + ":" + day.Format(time.DateOnly)
+}
+
+// formatRetryAfter formats the time which the user must wait as a human-readable text
+func formatRetryAfter(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		seconds := max(int(d.Round(time.Second)/time.Second), 1)
+		return strconv.Itoa(seconds) + " " + pluralize(seconds, "second")
+	case d < time.Hour:
+		minutes := int(d.Round(time.Minute) / time.Minute)
+		return strconv.Itoa(minutes) + " " + pluralize(minutes, "minute")
+	default:
+		hours := int(d.Round(time.Hour) / time.Hour)
+		return strconv.Itoa(hours) + " " + pluralize(hours, "hour")
+	}
+}
+
+// pluralize adds an "s" to the word if count is not one
+func pluralize(count int, word string) string {
+	if count == 1 {
+		return word
+	}
+	return word + "s"
+}
@@ -0,0 +1,50 @@
+package bot
+
+import (
+	"RedditDownloaderBot/internal/cache"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAcquireJobWithReloadedLimits(t *testing.T) {
+	const userID = 1
+	tests := []struct {
+		Name          string
+		LimitOnStart  int
+		LimitOnFinish int
+	}{
+		{Name: "enabled_while_running", LimitOnStart: 0, LimitOnFinish: 1},
+		{Name: "disabled_while_running", LimitOnStart: 1, LimitOnFinish: 0},
+		{Name: "changed_while_running", LimitOnStart: 1, LimitOnFinish: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			memoryCache := cache.NewMemoryCache(time.Minute, time.Minute)
+			defer memoryCache.Close()
+			limit := test.LimitOnStart
+			limiter := rateLimiter{
+				cache: memoryCache,
+				options: func() RateLimitOptions {
+					return RateLimitOptions{Default: UserLimits{ConcurrentJobs: limit}}
+				},
+			}
+			release, ok := limiter.acquireJob(userID)
+			assert.True(t, ok)
+			limit = test.LimitOnFinish
+			release()
+			// The slot of the job is free again
+			jobs, err := memoryCache.GetCounter(jobsCounterPrefix + "1")
+			assert.NoError(t, err)
+			assert.Zero(t, jobs)
+			limit = 1
+			release, ok = limiter.acquireJob(userID)
+			assert.True(t, ok)
+			_, ok = limiter.acquireJob(userID)
+			assert.False(t, ok)
+			release()
+			jobs, _ = memoryCache.GetCounter(jobsCounterPrefix + "1")
+			assert.Zero(t, jobs)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package bot
+
+import "time"
+
+// Settings are the settings of the bot which can be changed while it is running
+type Settings struct {
+	// The users which can use the bot
+	AllowedUsers AllowedUsers
+	// The users whose jobs are run before the jobs of other users. They can also mute the error reports.
+	Admins []int64
+	// The chats which the error reports are sent to
+	AdminChats []int64
+	// Messages older than this are not processed and the user is asked to send them again.
+	// Zero means no limit.
+	MaxUpdateAge time.Duration
+	// Limits the requests and downloads of each user
+	RateLimit RateLimitOptions
+	// If true, the link of the post is not added to the captions
+	DisableLinkInCaption bool
+	// If true, the videos which are too large for Telegram are re-encoded with a lower bitrate
+	// instead of being split into parts
+	ReencodeOversizedVideos bool
+	// If true, the best quality of the media which fits in the upload limit is downloaded without
+	// asking the users who have not chosen a quality mode with /quality
+	AutoQuality bool
+}
+
+// UpdateSettings replaces the settings of the bot. It is safe to call it while the bot is running.
+func (c *Client) UpdateSettings(settings Settings) {
+	c.currentSettings.Store(&settings)
+}
+
+// settings returns the current settings of the bot
+func (c *Client) settings() *Settings {
+	if settings := c.currentSettings.Load(); settings != nil {
+		return settings
+	}
+	return new(Settings)
+}
+
+// isAdmin checks if a user is an admin of the bot
+func (c *Client) isAdmin(userID int64) bool {
+	for _, id := range c.settings().Admins {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
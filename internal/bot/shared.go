@@ -3,6 +3,9 @@ package bot
 import "RedditDownloaderBot/pkg/reddit"
 
 const regularMaxUploadSize = 50 * 1000 * 1000 // these must be 1000 not 1024
+
+// photoMaxUploadSize is the maximum size of photos. Unlike other files, it is the same
+// on local Bot API servers.
 const photoMaxUploadSize = 10 * 1000 * 1000
 
 // noThumbnailNeededSize is the size which files bigger than it need a thumbnail
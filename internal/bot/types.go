@@ -2,13 +2,50 @@ package bot
 
 import (
 	"RedditDownloaderBot/internal/cache"
+	"RedditDownloaderBot/internal/fileserver"
+	"RedditDownloaderBot/internal/usage"
 	"RedditDownloaderBot/pkg/reddit"
+	"sync/atomic"
+	"time"
 )
 
 // Client is the contains the data needed to operate the bot
 type Client struct {
 	CallbackCache cache.Interface
 	RedditOauth   *reddit.Oauth
+	// Stores the users and their requests for the admin commands
+	Usage usage.Store
+	// If not nil, the bot receives the updates with webhook instead of long polling
+	Webhook *WebhookOptions
+	// If not nil, the bot talks to a self-hosted Bot API server
+	BotAPI *BotAPIOptions
+	// If not nil, the files which are too large for Telegram are sent as links to this server
+	Files *fileserver.Server
+	// The address which Files is served on. Empty means the webhook server.
+	FilesAddress string
+	// If true, the updates which were sent while the bot was offline are dropped
+	DropPendingUpdates bool
+	// On shutdown, the bot waits this long for the running downloads and uploads
+	// before canceling them
+	ShutdownTimeout time.Duration
+	// Limits the downloads and uploads which run at the same time
+	Queue QueueOptions
+	// The address which the metrics and health checks are served on. Empty means no monitoring server.
+	MonitoringAddress string
+	// The time which the bot was started at
+	startTime time.Time
+	// The jobs which are running. Initialized in RunBot.
+	jobs *jobTracker
+	// The queue of downloads and uploads. Initialized in RunBot.
+	queue *jobQueue
+	// Applies RateLimit. Initialized in RunBot.
+	limiter rateLimiter
+	// Tracks the updates which we receive from Telegram. Initialized in RunBot.
+	telegram *telegramStatus
+	// Sends the error reports to the admin chats. Initialized in RunBot.
+	notifier *adminNotifier
+	// The settings which can change while the bot is running. Set with UpdateSettings.
+	currentSettings atomic.Pointer[Settings]
 }
 
 // AllowedUsers is a list of users which can use the bot
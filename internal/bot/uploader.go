@@ -1,11 +1,15 @@
 package bot
 
 import (
+	"RedditDownloaderBot/internal/cache"
+	"RedditDownloaderBot/pkg/logging"
+	"RedditDownloaderBot/pkg/metrics"
 	"RedditDownloaderBot/pkg/reddit"
 	"RedditDownloaderBot/pkg/util"
+	"context"
 	"github.com/PaulSonOfLars/gotgbot/v2"
-	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,14 +17,25 @@ import (
 )
 
 // handleGifUpload downloads a gif and then uploads it to Telegram
-func (c *Client) handleGifUpload(bot *gotgbot.Bot, gifUrl, title, thumbnailUrl, postUrl, description string, dimension reddit.Dimension, chatID int64) error {
+func (c *Client) handleGifUpload(ctx context.Context, bot *gotgbot.Bot, gifUrl, title, thumbnailUrl, postUrl, postFullname, description string, dimension reddit.Dimension, chatID int64) error {
+	ctx = c.withErrorReporting(ctx, postUrl)
 	// Inform the user we are doing some shit
 	stopReportChannel := statusReporter(bot, chatID, gotgbot.ChatActionUploadVideo)
 	defer close(stopReportChannel)
+	// Check if we have uploaded this before
+	if sentMessage := c.sendUploadedFile(ctx, bot, chatID, postFullname, gifUrl, "", cache.TelegramFileTypeAnimation, c.addLinkIfNeeded(escapeMarkdown(title), postUrl)); sentMessage != nil {
+		return sendPostDescription(bot, description, sentMessage, false)
+	}
 	// Download the gif
-	tmpFile, err := c.RedditOauth.DownloadGif(gifUrl)
+	tmpFile, err := c.RedditOauth.DownloadGif(ctx, gifUrl)
 	if err != nil {
-		log.Println("Unable to download GIF", gifUrl, "for post", postUrl, ":", err)
+		if ctx.Err() != nil { // the job was canceled; the progress message tells the user
+			return ctx.Err()
+		}
+		if errors.Is(err, reddit.FileTooBigError) {
+			return sendTooLargeLinks(bot, chatID, "Here is the link: "+gifUrl)
+		}
+		logging.FromContext(ctx).Error("Unable to download GIF", "link", gifUrl, "post", postUrl, "error", err)
 		_, err = bot.SendMessage(chatID, "I couldn’t download this GIF.\nHere is the link: "+gifUrl, nil)
 		return err
 	}
@@ -30,16 +45,15 @@ func (c *Client) handleGifUpload(bot *gotgbot.Bot, gifUrl, title, thumbnailUrl,
 	}()
 	// Upload the gif
 	// Check file size
-	if !util.CheckFileSize(tmpFile.Name(), regularMaxUploadSize) {
-		_, err = bot.SendMessage(chatID, "The file is too large to upload on Telegram.\nHere is the link: "+gifUrl, nil)
-		return err
+	if !util.CheckFileSize(tmpFile.Name(), c.MaxUploadSize()) {
+		return c.sendTooLargeFile(ctx, bot, chatID, tmpFile, postFullname, "Here is the link: "+gifUrl)
 	}
 	// Check thumbnail
 	var tmpThumbnailFile *os.File = nil
 	if !util.CheckFileSize(tmpFile.Name(), noThumbnailNeededSize) && thumbnailUrl != "" {
-		tmpThumbnailFile, err = c.RedditOauth.DownloadThumbnail(thumbnailUrl)
+		tmpThumbnailFile, err = c.RedditOauth.DownloadThumbnail(ctx, thumbnailUrl)
 		if err != nil {
-			log.Println("Cannot download GIF thumbnail", thumbnailUrl, ":", err)
+			logging.FromContext(ctx).Warn("Cannot download GIF thumbnail", "link", thumbnailUrl, "error", err)
 		} else {
 			defer func() {
 				_ = tmpThumbnailFile.Close()
@@ -51,12 +65,12 @@ func (c *Client) handleGifUpload(bot *gotgbot.Bot, gifUrl, title, thumbnailUrl,
 	if dimension.Empty() {
 		dimension, err = reddit.GetVideoDimensions(tmpFile.Name())
 		if err != nil {
-			log.Println("Cannot get dimensions of GIF:", err)
+			logging.FromContext(ctx).Warn("Cannot get dimensions of GIF", "error", err)
 		}
 	}
 	// Upload it
 	animationOpt := &gotgbot.SendAnimationOpts{
-		Caption:   addLinkIfNeeded(escapeMarkdown(title), postUrl),
+		Caption:   c.addLinkIfNeeded(escapeMarkdown(title), postUrl),
 		ParseMode: gotgbot.ParseModeMarkdownV2,
 		Width:     dimension.Width,
 		Height:    dimension.Height,
@@ -64,28 +78,41 @@ func (c *Client) handleGifUpload(bot *gotgbot.Bot, gifUrl, title, thumbnailUrl,
 	if tmpThumbnailFile != nil {
 		animationOpt.Thumbnail = fileReaderFromOsFile(tmpThumbnailFile)
 	}
-	sentMessage, err := bot.SendAnimation(chatID, fileReaderFromOsFile(tmpFile), animationOpt)
+	jobProgressFromContext(ctx).uploading()
+	uploadStartTime := time.Now()
+	sentMessage, err := bot.SendAnimationWithContext(ctx, chatID, c.inputFile(tmpFile), animationOpt)
+	observeUpload("animation", uploadStartTime, err)
 	if err != nil {
-		log.Println("Unable to upload GIF for post", postUrl, ":", err)
+		logging.FromContext(ctx).Error("Unable to upload GIF", "post", postUrl, "error", err)
+		c.reportError("upload", postUrl, err)
 		_, err = bot.SendMessage(chatID, "I couldn’t upload this GIF.\nHere is the link: "+gifUrl, nil)
 		return err
 	}
+	c.storeUploadedFile(ctx, postFullname, gifUrl, "", cache.TelegramFileTypeAnimation, sentMessage)
 	// Send description as another message (if available)
 	return sendPostDescription(bot, description, sentMessage, false)
 }
 
 // handleVideoUpload downloads a video and then uploads it to Telegram
-func (c *Client) handleVideoUpload(bot *gotgbot.Bot, vidUrl, audioUrl, title, thumbnailUrl, postUrl, description string, dimension reddit.Dimension, duration, chatID int64) error {
+func (c *Client) handleVideoUpload(ctx context.Context, bot *gotgbot.Bot, vidUrl, audioUrl, title, thumbnailUrl, postUrl, postFullname, description string, dimension reddit.Dimension, duration, chatID int64) error {
+	ctx = c.withErrorReporting(ctx, postUrl)
 	// Inform the user we are doing some shit
 	stopReportChannel := statusReporter(bot, chatID, gotgbot.ChatActionUploadVideo)
 	defer close(stopReportChannel)
+	// Check if we have uploaded this before
+	if sentMessage := c.sendUploadedFile(ctx, bot, chatID, postFullname, vidUrl, audioUrl, cache.TelegramFileTypeVideo, c.addLinkIfNeeded(escapeMarkdown(title), postUrl)); sentMessage != nil {
+		return sendPostDescription(bot, description, sentMessage, false)
+	}
 	// Download the gif
-	tmpFile, err := c.RedditOauth.DownloadVideo(vidUrl, audioUrl)
+	tmpFile, err := c.RedditOauth.DownloadVideo(ctx, vidUrl, audioUrl)
 	if err != nil {
+		if ctx.Err() != nil { // the job was canceled; the progress message tells the user
+			return ctx.Err()
+		}
 		if errors.Is(err, reddit.FileTooBigError) {
-			_, err = bot.SendMessage(chatID, "I couldn’t download this file because it’s too large.\n"+generateVideoUrlsMessage(vidUrl, audioUrl), nil)
+			err = sendTooLargeLinks(bot, chatID, generateVideoUrlsMessage(vidUrl, audioUrl))
 		} else {
-			log.Println("Unable to download video", vidUrl, "for post", postUrl, ":", err)
+			logging.FromContext(ctx).Error("Unable to download video", "link", vidUrl, "post", postUrl, "error", err)
 			_, err = bot.SendMessage(chatID, "I couldn’t download this video.\n"+generateVideoUrlsMessage(vidUrl, audioUrl), nil)
 		}
 		return err
@@ -95,16 +122,41 @@ func (c *Client) handleVideoUpload(bot *gotgbot.Bot, vidUrl, audioUrl, title, th
 		_ = os.Remove(tmpFile.Name())
 	}()
 	// Check file size
-	if !util.CheckFileSize(tmpFile.Name(), regularMaxUploadSize) {
-		_, err = bot.SendMessage(chatID, "This file is too large to upload on Telegram.\n"+generateVideoUrlsMessage(vidUrl, audioUrl), nil)
-		return err
+	caption := title
+	if !util.CheckFileSize(tmpFile.Name(), c.MaxUploadSize()) {
+		// Only the videos which were downloaded for the file server are this large
+		if c.Files != nil && !util.CheckFileSize(tmpFile.Name(), reddit.MaxSplittableVideoSize) {
+			return c.sendTooLargeFile(ctx, bot, chatID, tmpFile, postFullname, generateVideoUrlsMessage(vidUrl, audioUrl))
+		}
+		if !c.settings().ReencodeOversizedVideos {
+			return c.handleSplitVideoUpload(ctx, bot, tmpFile, vidUrl, audioUrl, title, postUrl, postFullname, description, dimension, duration, chatID)
+		}
+		reencoded, err := c.RedditOauth.ReencodeVideo(ctx, tmpFile.Name(), float64(duration), c.MaxUploadSize())
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logging.FromContext(ctx).Warn("Unable to re-encode video; splitting it instead", "post", postUrl, "error", err)
+			return c.handleSplitVideoUpload(ctx, bot, tmpFile, vidUrl, audioUrl, title, postUrl, postFullname, description, dimension, duration, chatID)
+		}
+		// Replace the original file with the re-encoded one
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		tmpFile = reencoded.File
+		if !reencoded.Dimension.Empty() {
+			dimension = reencoded.Dimension
+		}
+		if caption != "" {
+			caption += "\n\n"
+		}
+		caption += formatReencodeNote(reencoded)
 	}
 	// Check thumbnail
 	var tmpThumbnailFile *os.File = nil
 	if !util.CheckFileSize(tmpFile.Name(), noThumbnailNeededSize) && thumbnailUrl != "" {
-		tmpThumbnailFile, err = c.RedditOauth.DownloadThumbnail(thumbnailUrl)
+		tmpThumbnailFile, err = c.RedditOauth.DownloadThumbnail(ctx, thumbnailUrl)
 		if err != nil {
-			log.Println("Cannot download video thumbnail", thumbnailUrl, ":", err)
+			logging.FromContext(ctx).Warn("Cannot download video thumbnail", "link", thumbnailUrl, "error", err)
 		} else {
 			defer func() {
 				_ = tmpThumbnailFile.Close()
@@ -116,13 +168,13 @@ func (c *Client) handleVideoUpload(bot *gotgbot.Bot, vidUrl, audioUrl, title, th
 	if dimension.Empty() {
 		dimension, err = reddit.GetVideoDimensions(tmpFile.Name())
 		if err != nil {
-			log.Println("Cannot get dimensions of video:", err)
+			logging.FromContext(ctx).Warn("Cannot get dimensions of video", "error", err)
 		}
 	}
 	// Upload it
 	videoOpt := &gotgbot.SendVideoOpts{
 		Duration:          duration,
-		Caption:           addLinkIfNeeded(escapeMarkdown(title), postUrl),
+		Caption:           c.addLinkIfNeeded(escapeMarkdown(caption), postUrl),
 		ParseMode:         gotgbot.ParseModeMarkdownV2,
 		SupportsStreaming: true,
 		Width:             dimension.Width,
@@ -131,18 +183,104 @@ func (c *Client) handleVideoUpload(bot *gotgbot.Bot, vidUrl, audioUrl, title, th
 	if tmpThumbnailFile != nil {
 		videoOpt.Thumbnail = fileReaderFromOsFile(tmpThumbnailFile)
 	}
-	sentMessage, err := bot.SendVideo(chatID, fileReaderFromOsFile(tmpFile), videoOpt)
+	jobProgressFromContext(ctx).uploading()
+	uploadStartTime := time.Now()
+	sentMessage, err := bot.SendVideoWithContext(ctx, chatID, c.inputFile(tmpFile), videoOpt)
+	observeUpload("video", uploadStartTime, err)
 	if err != nil {
-		log.Println("Unable to upload video for", postUrl, ":", err)
+		logging.FromContext(ctx).Error("Unable to upload video", "post", postUrl, "error", err)
+		c.reportError("upload", postUrl, err)
 		_, err = bot.SendMessage(chatID, "I couldn’t upload this video.\n"+generateVideoUrlsMessage(vidUrl, audioUrl), nil)
 		return err
 	}
+	c.storeUploadedFile(ctx, postFullname, vidUrl, audioUrl, cache.TelegramFileTypeVideo, sentMessage)
 	// Send description as another message (if available)
 	return sendPostDescription(bot, description, sentMessage, false)
 }
 
+// formatReencodeNote creates the line which is added to the caption of re-encoded videos
+func formatReencodeNote(video reddit.ReencodedVideo) string {
+	quality := strconv.FormatFloat(float64(video.VideoBitrate)/(1000*1000), 'f', 1, 64) + " Mbps"
+	if video.Dimension.Height != 0 {
+		quality = strconv.FormatInt(video.Dimension.Height, 10) + "p, " + quality
+	}
+	return "Re-encoded to fit the upload limit (" + quality + ", " + formatSize(video.Size) + ")"
+}
+
+// handleSplitVideoUpload splits a video which is too large for Telegram into parts and uploads
+// them as a reply chain. The parts are not cached because they are not the requested video.
+// If the video cannot be split, it is sent with sendTooLargeFile. duration is the length of the
+// video in seconds, or zero if it is unknown.
+func (c *Client) handleSplitVideoUpload(ctx context.Context, bot *gotgbot.Bot, videoFile *os.File, vidUrl, audioUrl, title, postUrl, postFullname, description string, dimension reddit.Dimension, duration, chatID int64) error {
+	if !util.DoesFfmpegExists() {
+		return c.sendTooLargeFile(ctx, bot, chatID, videoFile, postFullname, generateVideoUrlsMessage(vidUrl, audioUrl))
+	}
+	parts, err := c.RedditOauth.SplitVideo(ctx, videoFile.Name(), float64(duration), c.MaxUploadSize())
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		logging.FromContext(ctx).Warn("Unable to split video", "post", postUrl, "error", err)
+		return c.sendTooLargeFile(ctx, bot, chatID, videoFile, postFullname, generateVideoUrlsMessage(vidUrl, audioUrl))
+	}
+	defer func() { // Cleanup
+		for _, part := range parts {
+			_ = part.Close()
+			_ = os.Remove(part.Name())
+		}
+	}()
+	if dimension.Empty() {
+		dimension, err = reddit.GetVideoDimensions(parts[0].Name())
+		if err != nil {
+			logging.FromContext(ctx).Warn("Cannot get dimensions of video", "error", err)
+		}
+	}
+	jobProgressFromContext(ctx).uploading()
+	var firstMessage, previousMessage *gotgbot.Message
+	for i, part := range parts {
+		partName := "Part " + strconv.Itoa(i+1) + "/" + strconv.Itoa(len(parts))
+		caption := partName
+		if title != "" {
+			caption += "\n" + title
+		}
+		videoOpt := &gotgbot.SendVideoOpts{
+			Caption:           c.addLinkIfNeeded(escapeMarkdown(caption), postUrl),
+			ParseMode:         gotgbot.ParseModeMarkdownV2,
+			SupportsStreaming: true,
+			Width:             dimension.Width,
+			Height:            dimension.Height,
+		}
+		if partDuration, err := reddit.GetVideoDuration(part.Name()); err == nil {
+			videoOpt.Duration = int64(partDuration)
+		} else {
+			logging.FromContext(ctx).Warn("Cannot get duration of video part", "part", i+1, "error", err)
+		}
+		if previousMessage != nil {
+			videoOpt.ReplyParameters = &gotgbot.ReplyParameters{
+				MessageId:                previousMessage.MessageId,
+				AllowSendingWithoutReply: true,
+			}
+		}
+		uploadStartTime := time.Now()
+		previousMessage, err = bot.SendVideoWithContext(ctx, chatID, c.inputFile(part), videoOpt)
+		observeUpload("video", uploadStartTime, err)
+		if err != nil {
+			logging.FromContext(ctx).Error("Unable to upload video part", "post", postUrl, "part", i+1, "error", err)
+			c.reportError("upload", postUrl, err)
+			_, err = bot.SendMessage(chatID, "I couldn’t upload "+strings.ToLower(partName)+" of this video.\n"+generateVideoUrlsMessage(vidUrl, audioUrl), nil)
+			return err
+		}
+		if firstMessage == nil {
+			firstMessage = previousMessage
+		}
+	}
+	// Send description as another message (if available)
+	return sendPostDescription(bot, description, firstMessage, false)
+}
+
 // handleVideoUpload downloads a photo and then uploads it to Telegram
-func (c *Client) handlePhotoUpload(bot *gotgbot.Bot, photoUrl, title, thumbnailUrl, postUrl, description string, chatID int64, asPhoto bool) error {
+func (c *Client) handlePhotoUpload(ctx context.Context, bot *gotgbot.Bot, photoUrl, title, thumbnailUrl, postUrl, postFullname, description string, chatID int64, asPhoto bool) error {
+	ctx = c.withErrorReporting(ctx, postUrl)
 	// Inform the user we are doing some shit
 	var stopReportChannel chan struct{}
 	if asPhoto {
@@ -151,10 +289,24 @@ func (c *Client) handlePhotoUpload(bot *gotgbot.Bot, photoUrl, title, thumbnailU
 		stopReportChannel = statusReporter(bot, chatID, gotgbot.ChatActionUploadDocument)
 	}
 	defer close(stopReportChannel)
+	// Check if we have uploaded this before
+	requestedFileType := cache.TelegramFileTypeDocument
+	if asPhoto {
+		requestedFileType = cache.TelegramFileTypePhoto
+	}
+	if sentMessage := c.sendUploadedFile(ctx, bot, chatID, postFullname, photoUrl, "", requestedFileType, c.addLinkIfNeeded(escapeMarkdown(title), postUrl)); sentMessage != nil {
+		return sendPostDescription(bot, description, sentMessage, false)
+	}
 	// Download the gif
-	tmpFile, err := c.RedditOauth.DownloadPhoto(photoUrl)
+	tmpFile, err := c.RedditOauth.DownloadPhoto(ctx, photoUrl)
 	if err != nil {
-		log.Println("Unable to download photo", photoUrl, "for post", postUrl, ":", err)
+		if ctx.Err() != nil { // the job was canceled; the progress message tells the user
+			return ctx.Err()
+		}
+		if errors.Is(err, reddit.FileTooBigError) {
+			return sendTooLargeLinks(bot, chatID, "Here is the link: "+photoUrl)
+		}
+		logging.FromContext(ctx).Error("Unable to download photo", "link", photoUrl, "post", postUrl, "error", err)
 		_, err = bot.SendMessage(chatID, "I couldn’t download this image.\nHere is the link: "+photoUrl, nil)
 		return err
 	}
@@ -166,17 +318,16 @@ func (c *Client) handlePhotoUpload(bot *gotgbot.Bot, photoUrl, title, thumbnailU
 	if asPhoto {
 		asPhoto = util.CheckFileSize(tmpFile.Name(), photoMaxUploadSize) // send photo as file if it is larger than 10MB
 	}
-	if !util.CheckFileSize(tmpFile.Name(), regularMaxUploadSize) {
-		_, err = bot.SendMessage(chatID, "The file is too large to upload on Telegram.\nHere is the link: "+photoUrl, nil)
-		return err
+	if !util.CheckFileSize(tmpFile.Name(), c.MaxUploadSize()) {
+		return c.sendTooLargeFile(ctx, bot, chatID, tmpFile, postFullname, "Here is the link: "+photoUrl)
 	}
 	// Download thumbnail
 	var tmpThumbnailFile *os.File = nil
 	if !asPhoto && !util.CheckFileSize(tmpFile.Name(), noThumbnailNeededSize) && thumbnailUrl != "" {
 		// photos does not support thumbnail...
-		tmpThumbnailFile, err = c.RedditOauth.DownloadThumbnail(thumbnailUrl)
+		tmpThumbnailFile, err = c.RedditOauth.DownloadThumbnail(ctx, thumbnailUrl)
 		if err != nil {
-			log.Println("Cannot download photo thumbnail", thumbnailUrl, ":", err)
+			logging.FromContext(ctx).Warn("Cannot download photo thumbnail", "link", thumbnailUrl, "error", err)
 		} else {
 			defer func() {
 				_ = tmpThumbnailFile.Close()
@@ -185,150 +336,265 @@ func (c *Client) handlePhotoUpload(bot *gotgbot.Bot, photoUrl, title, thumbnailU
 		}
 	}
 	// Upload
+	jobProgressFromContext(ctx).uploading()
 	var sentMessage *gotgbot.Message
+	var sentFileType cache.TelegramFileType
+	uploadStartTime := time.Now()
 	if asPhoto {
-		sentMessage, err = bot.SendPhoto(chatID, fileReaderFromOsFile(tmpFile), &gotgbot.SendPhotoOpts{
-			Caption:   addLinkIfNeeded(escapeMarkdown(title), postUrl),
+		sentFileType = cache.TelegramFileTypePhoto
+		sentMessage, err = bot.SendPhotoWithContext(ctx, chatID, c.inputFile(tmpFile), &gotgbot.SendPhotoOpts{
+			Caption:   c.addLinkIfNeeded(escapeMarkdown(title), postUrl),
 			ParseMode: gotgbot.ParseModeMarkdownV2,
 		})
 	} else {
+		sentFileType = cache.TelegramFileTypeDocument
 		documentOpt := &gotgbot.SendDocumentOpts{
-			Caption:   addLinkIfNeeded(escapeMarkdown(title), postUrl),
+			Caption:   c.addLinkIfNeeded(escapeMarkdown(title), postUrl),
 			ParseMode: gotgbot.ParseModeMarkdownV2,
 		}
 		if tmpThumbnailFile != nil {
 			documentOpt.Thumbnail = fileReaderFromOsFile(tmpThumbnailFile)
 		}
-		sentMessage, err = bot.SendDocument(chatID, fileReaderFromOsFile(tmpFile), documentOpt)
+		sentMessage, err = bot.SendDocumentWithContext(ctx, chatID, c.inputFile(tmpFile), documentOpt)
+	}
+	if asPhoto {
+		observeUpload("photo", uploadStartTime, err)
+	} else {
+		observeUpload("document", uploadStartTime, err)
 	}
 	if err != nil {
-		log.Println("Unable to upload photo for post", postUrl, ":", err)
+		logging.FromContext(ctx).Error("Unable to upload photo", "post", postUrl, "error", err)
+		c.reportError("upload", postUrl, err)
 		_, err = bot.SendMessage(chatID, "I couldn’t upload this image.\nHere is the link: "+photoUrl, nil)
 		return err
 	}
+	c.storeUploadedFile(ctx, postFullname, photoUrl, "", sentFileType, sentMessage)
 	// Send description as another message (if available)
 	return sendPostDescription(bot, description, sentMessage, false)
 }
 
 // handleAlbumUpload uploads an album to Telegram
-func (c *Client) handleAlbumUpload(bot *gotgbot.Bot, album reddit.FetchResultAlbum, postUrl string, chatID int64, asFile bool) error {
+func (c *Client) handleAlbumUpload(ctx context.Context, bot *gotgbot.Bot, album reddit.FetchResultAlbum, postUrl, postFullname string, chatID int64, asFile bool) error {
+	ctx = c.withErrorReporting(ctx, postUrl)
 	// Report status
 	stopReportChannel := statusReporter(bot, chatID, gotgbot.ChatActionUploadPhoto)
 	defer close(stopReportChannel)
 	// Download each file of album
-	var err error
-	filePaths := make([]*os.File, 0, len(album.Album))
+	entries := make([]albumUploadEntry, 0, len(album.Album))
 	defer func() { // cleanup
-		for _, f := range filePaths {
-			_ = f.Close()
-			_ = os.Remove(f.Name())
+		for _, entry := range entries {
+			entry.cleanUp()
 		}
 	}()
-	fileConfigs := make([]gotgbot.InputMedia, 0, len(album.Album))
-	fileLinks := make([]string, 0, len(album.Album))
 	for _, media := range album.Album {
-		var tmpFile *os.File
-		var f gotgbot.InputMedia
-		switch media.Type {
-		case reddit.FetchResultMediaTypePhoto:
-			tmpFile, err = c.RedditOauth.DownloadPhoto(media.Link)
-			if err == nil {
-				if asFile {
-					f = gotgbot.InputMediaDocument{Media: fileReaderFromOsFile(tmpFile), Caption: media.Caption}
-				} else {
-					f = gotgbot.InputMediaPhoto{Media: fileReaderFromOsFile(tmpFile), Caption: media.Caption}
-				}
-			}
-		case reddit.FetchResultMediaTypeGif:
-			tmpFile, err = c.RedditOauth.DownloadGif(media.Link)
-			if err == nil {
-				if asFile {
-					f = gotgbot.InputMediaDocument{Media: fileReaderFromOsFile(tmpFile), Caption: media.Caption}
-				} else {
-					f = gotgbot.InputMediaVideo{Media: fileReaderFromOsFile(tmpFile), Caption: media.Caption}
-				}
-			}
-		case reddit.FetchResultMediaTypeVideo:
-			tmpFile, err = c.RedditOauth.DownloadVideo(media.Link, "") // TODO: can i do something about audio URL?
-			if err == nil {
-				if asFile {
-					f = gotgbot.InputMediaDocument{Media: fileReaderFromOsFile(tmpFile), Caption: media.Caption}
-				} else {
-					f = gotgbot.InputMediaVideo{
-						Media:             fileReaderFromOsFile(tmpFile),
-						Caption:           media.Caption,
-						SupportsStreaming: true,
-					}
-				}
-			}
+		entry := albumUploadEntry{
+			media:      media,
+			fileType:   albumEntryFileType(media.Type, asFile),
+			localFiles: c.sendsLocalFiles(),
 		}
-		if err != nil {
-			log.Println("Unable to download album media:", err)
+		// Check if we have uploaded this file before
+		if uploadedFile, found := c.getUploadedFile(ctx, postFullname, media.Link, "", entry.fileType); found {
+			entry.fileID = uploadedFile.FileID
+		} else if err := entry.download(ctx, c.RedditOauth); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logging.FromContext(ctx).Error("Unable to download album media", "post", postUrl, "error", err)
 			_, _ = bot.SendMessage(chatID, "I couldn’t download the gallery.\nHere is the link: "+media.Link, nil)
 			continue
 		}
-		fileConfigs = append(fileConfigs, f)
-		fileLinks = append(fileLinks, media.Link)
-		filePaths = append(filePaths, tmpFile)
+		entries = append(entries, entry)
 	}
 	// Now upload 10 of them at once
+	jobProgressFromContext(ctx).uploading()
 	var lastMessage *gotgbot.Message
-	i := 0
-	for ; i < len(fileConfigs)/10; i++ {
-		sentMessages, err := bot.SendMediaGroup(chatID, fileConfigs[i*10:(i+1)*10], nil)
+	for i := 0; i < len(entries); i += 10 {
+		chunk := entries[i:min(i+10, len(entries))]
+		uploadStartTime := time.Now()
+		sentMessages, err := sendAlbumChunk(ctx, bot, chatID, chunk, c.RedditOauth)
+		observeUpload("album", uploadStartTime, err)
 		if err != nil {
-			log.Println("Unable to upload gallery:", err)
-			_, _ = bot.SendMessage(chatID, generateGalleryFailedMessage(fileLinks[i*10:(i+1)*10]), nil)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logging.FromContext(ctx).Error("Unable to upload gallery", "post", postUrl, "error", err)
+			c.reportError("upload", postUrl, err)
+			_, err = bot.SendMessage(chatID, generateGalleryFailedMessage(albumEntriesLinks(chunk)), nil)
+			if err != nil {
+				return err
+			}
+			continue
 		}
-		if len(sentMessages) != 0 {
-			lastMessage = &sentMessages[len(sentMessages)-1]
+		for j := range sentMessages {
+			c.storeUploadedFile(ctx, postFullname, chunk[j].media.Link, "", chunk[j].fileType, &sentMessages[j])
 		}
-	}
-	err = nil // needed for last error check
-	fileConfigs = fileConfigs[i*10:]
-	if len(fileConfigs) == 1 {
-		switch f := fileConfigs[0].(type) {
-		case gotgbot.InputMediaPhoto:
-			lastMessage, err = bot.SendPhoto(chatID, f.Media, nil)
-		case gotgbot.InputMediaVideo:
-			lastMessage, err = bot.SendVideo(chatID, f.Media, nil)
-		case gotgbot.InputMediaDocument:
-			lastMessage, err = bot.SendDocument(chatID, f.Media, nil)
-		default:
-			panic("IMPOSSIBLE")
-		}
-	} else if len(fileConfigs) > 1 {
-		var sentMessages []gotgbot.Message
-		sentMessages, err = bot.SendMediaGroup(chatID, fileConfigs, nil)
 		if len(sentMessages) != 0 {
 			lastMessage = &sentMessages[len(sentMessages)-1]
 		}
 	}
-	if err != nil {
-		log.Println("Unable to upload gallery:", err)
-		_, err = bot.SendMessage(chatID, generateGalleryFailedMessage(fileLinks[i*10:]), nil)
-		if err != nil {
-			return err
-		}
-	}
 	// Send the title and description
 	titleDescriptionMessageText := "*" + escapeMarkdown(album.Title) + "*"
 	if album.Description != "" {
 		titleDescriptionMessageText += "\n\n" + escapeMarkdown(album.Description)
 	}
-	titleDescriptionMessageText = addLinkIfNeeded(titleDescriptionMessageText, postUrl)
+	titleDescriptionMessageText = c.addLinkIfNeeded(titleDescriptionMessageText, postUrl)
 	return sendPostDescription(bot, titleDescriptionMessageText, lastMessage, true)
 }
 
+// albumUploadEntry is a media of an album which is ready to be uploaded to Telegram.
+// Either file or fileID is set.
+type albumUploadEntry struct {
+	media reddit.FetchResultAlbumEntry
+	// How we are going to send this media
+	fileType cache.TelegramFileType
+	// The downloaded file of this media
+	file *os.File
+	// The Telegram file ID of this media if it has been uploaded before
+	fileID string
+	// If true, file is sent to the local Bot API server by its path
+	localFiles bool
+}
+
+// download downloads the media of the entry and clears its file ID
+func (e *albumUploadEntry) download(ctx context.Context, redditOauth *reddit.Oauth) error {
+	var err error
+	switch e.media.Type {
+	case reddit.FetchResultMediaTypePhoto:
+		e.file, err = redditOauth.DownloadPhoto(ctx, e.media.Link)
+	case reddit.FetchResultMediaTypeGif:
+		e.file, err = redditOauth.DownloadGif(ctx, e.media.Link)
+	case reddit.FetchResultMediaTypeVideo:
+		e.file, err = redditOauth.DownloadVideo(ctx, e.media.Link, "") // TODO: can i do something about audio URL?
+	default:
+		err = errors.New("unknown media type: " + strconv.Itoa(int(e.media.Type)))
+	}
+	if err == nil {
+		e.fileID = ""
+	}
+	return err
+}
+
+// inputMedia creates the gotgbot.InputMedia of this entry. Must be called again
+// for each upload because the file is read in each upload.
+func (e *albumUploadEntry) inputMedia() gotgbot.InputMedia {
+	var media gotgbot.InputFileOrString
+	if e.file != nil {
+		media = inputFileFromOsFile(e.file, e.localFiles)
+	} else {
+		media = gotgbot.InputFileByID(e.fileID)
+	}
+	switch e.fileType {
+	case cache.TelegramFileTypePhoto:
+		return gotgbot.InputMediaPhoto{Media: media, Caption: e.media.Caption}
+	case cache.TelegramFileTypeVideo:
+		return gotgbot.InputMediaVideo{
+			Media:             media,
+			Caption:           e.media.Caption,
+			SupportsStreaming: e.media.Type == reddit.FetchResultMediaTypeVideo,
+		}
+	default:
+		return gotgbot.InputMediaDocument{Media: media, Caption: e.media.Caption}
+	}
+}
+
+// cleanUp removes the downloaded file of the entry
+func (e *albumUploadEntry) cleanUp() {
+	if e.file != nil {
+		_ = e.file.Close()
+		_ = os.Remove(e.file.Name())
+	}
+}
+
+// albumEntryFileType returns how a media in album is sent to Telegram.
+// GIFs are sent as videos in albums.
+func albumEntryFileType(mediaType reddit.FetchResultMediaType, asFile bool) cache.TelegramFileType {
+	if asFile {
+		return cache.TelegramFileTypeDocument
+	}
+	if mediaType == reddit.FetchResultMediaTypePhoto {
+		return cache.TelegramFileTypePhoto
+	}
+	return cache.TelegramFileTypeVideo
+}
+
+// albumEntriesLinks returns the links of the given album entries
+func albumEntriesLinks(entries []albumUploadEntry) []string {
+	links := make([]string, len(entries))
+	for i, entry := range entries {
+		links[i] = entry.media.Link
+	}
+	return links
+}
+
+// sendAlbumChunk uploads at most 10 entries of an album to Telegram. If Telegram rejects
+// the file IDs of the previously uploaded medias, they are downloaded and uploaded again.
+func sendAlbumChunk(ctx context.Context, bot *gotgbot.Bot, chatID int64, chunk []albumUploadEntry, redditOauth *reddit.Oauth) ([]gotgbot.Message, error) {
+	sentMessages, err := sendAlbumEntries(ctx, bot, chatID, chunk)
+	if err == nil {
+		return sentMessages, nil
+	}
+	// Download the files which were sent by their ID
+	hasFileID := false
+	for i := range chunk {
+		if chunk[i].file != nil {
+			continue
+		}
+		hasFileID = true
+		if downloadErr := chunk[i].download(ctx, redditOauth); downloadErr != nil {
+			return nil, errors.Wrap(downloadErr, "cannot download the media after the file ID was rejected")
+		}
+	}
+	if !hasFileID {
+		return nil, err
+	}
+	logging.FromContext(ctx).Warn("Cannot send the album with file IDs, uploading it again", "error", err)
+	return sendAlbumEntries(ctx, bot, chatID, chunk)
+}
+
+// sendAlbumEntries sends the entries as a media group. If there is only one entry, it
+// is sent as a single media because media groups must contain at least two medias.
+func sendAlbumEntries(ctx context.Context, bot *gotgbot.Bot, chatID int64, entries []albumUploadEntry) ([]gotgbot.Message, error) {
+	if len(entries) != 1 {
+		inputMedias := make([]gotgbot.InputMedia, len(entries))
+		for i := range entries {
+			inputMedias[i] = entries[i].inputMedia()
+		}
+		return bot.SendMediaGroupWithContext(ctx, chatID, inputMedias, nil)
+	}
+	var sentMessage *gotgbot.Message
+	var err error
+	switch f := entries[0].inputMedia().(type) {
+	case gotgbot.InputMediaPhoto:
+		sentMessage, err = bot.SendPhotoWithContext(ctx, chatID, f.Media, nil)
+	case gotgbot.InputMediaVideo:
+		sentMessage, err = bot.SendVideoWithContext(ctx, chatID, f.Media, nil)
+	case gotgbot.InputMediaDocument:
+		sentMessage, err = bot.SendDocumentWithContext(ctx, chatID, f.Media, nil)
+	default:
+		panic("IMPOSSIBLE")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []gotgbot.Message{*sentMessage}, nil
+}
+
 // handleAudioUpload simply downloads then uploads an audio to Telegram
-func (c *Client) handleAudioUpload(bot *gotgbot.Bot, audioURL, title, postUrl, description string, duration, chatID int64) error {
+func (c *Client) handleAudioUpload(ctx context.Context, bot *gotgbot.Bot, audioURL, title, postUrl, postFullname, description string, duration, chatID int64) error {
 	// Send status
 	stopReportChannel := statusReporter(bot, chatID, gotgbot.ChatActionUploadVoice)
 	defer close(stopReportChannel)
+	// Check if we have uploaded this before
+	if sentMessage := c.sendUploadedFile(ctx, bot, chatID, postFullname, audioURL, "", cache.TelegramFileTypeAudio, c.addLinkIfNeeded(escapeMarkdown(title), postUrl)); sentMessage != nil {
+		return sendPostDescription(bot, description, sentMessage, false)
+	}
 	// Create a temp file
-	audioFile, err := c.RedditOauth.DownloadAudio(audioURL)
+	audioFile, err := c.RedditOauth.DownloadAudio(ctx, audioURL)
 	if err != nil {
-		log.Println("Unable to download audio from", audioURL, "for post", postUrl, ":", err)
+		if ctx.Err() != nil { // the job was canceled; the progress message tells the user
+			return ctx.Err()
+		}
+		logging.FromContext(ctx).Error("Unable to download audio", "link", audioURL, "post", postUrl, "error", err)
 		_, err = bot.SendMessage(chatID, "I couldn’t download the audio.\n"+generateAudioURLMessage(audioURL), nil)
 		return err
 	}
@@ -337,20 +603,116 @@ func (c *Client) handleAudioUpload(bot *gotgbot.Bot, audioURL, title, postUrl, d
 		_ = os.Remove(audioFile.Name())
 	}()
 	// Simply upload it to telegram
-	sentMessage, err := bot.SendAudio(chatID, fileReaderFromOsFile(audioFile), &gotgbot.SendAudioOpts{
-		Caption:   addLinkIfNeeded(escapeMarkdown(title), postUrl),
+	jobProgressFromContext(ctx).uploading()
+	uploadStartTime := time.Now()
+	sentMessage, err := bot.SendAudioWithContext(ctx, chatID, c.inputFile(audioFile), &gotgbot.SendAudioOpts{
+		Caption:   c.addLinkIfNeeded(escapeMarkdown(title), postUrl),
 		ParseMode: gotgbot.ParseModeMarkdownV2,
 		Duration:  duration,
 	})
+	observeUpload("audio", uploadStartTime, err)
 	if err != nil {
-		log.Println("Unable to upload audio for post", postUrl, ":", err)
+		logging.FromContext(ctx).Error("Unable to upload audio", "post", postUrl, "error", err)
+		c.reportError("upload", postUrl, err)
 		_, err = bot.SendMessage(chatID, "I couldn’t upload the audio.\n"+generateAudioURLMessage(audioURL), nil)
 		return err
 	}
+	c.storeUploadedFile(ctx, postFullname, audioURL, "", cache.TelegramFileTypeAudio, sentMessage)
 	// Send description as another message (if available)
 	return sendPostDescription(bot, description, sentMessage, false)
 }
 
+// observeUpload records the duration of an upload to Telegram in metrics
+func observeUpload(kind string, startTime time.Time, err error) {
+	outcome := metrics.OutcomeSuccess
+	if err != nil {
+		outcome = metrics.OutcomeFailure
+	}
+	metrics.UploadDuration.WithLabelValues(kind, outcome).Observe(time.Since(startTime).Seconds())
+}
+
+// getUploadedFile searches the cache for a media which has been uploaded before.
+// See uploadedFileKey for audioLink.
+func (c *Client) getUploadedFile(ctx context.Context, postFullname, link, audioLink string, fileType cache.TelegramFileType) (cache.UploadedFile, bool) {
+	if postFullname == "" {
+		return cache.UploadedFile{}, false
+	}
+	uploadedFile, err := c.CallbackCache.GetUploadedFile(uploadedFileKey(postFullname, link, audioLink, fileType))
+	if err != nil {
+		if !errors.Is(err, cache.NotFoundErr) {
+			logging.FromContext(ctx).Error("Cannot get the uploaded file from database", "error", err)
+		}
+		return cache.UploadedFile{}, false
+	}
+	return uploadedFile, true
+}
+
+// sendUploadedFile sends a media which has been uploaded before by its file ID.
+// It returns nil if the media is not in cache or Telegram rejects the file ID. In this
+// case, the media must be downloaded and uploaded again.
+func (c *Client) sendUploadedFile(ctx context.Context, bot *gotgbot.Bot, chatID int64, postFullname, link, audioLink string, fileType cache.TelegramFileType, caption string) *gotgbot.Message {
+	uploadedFile, found := c.getUploadedFile(ctx, postFullname, link, audioLink, fileType)
+	if !found {
+		return nil
+	}
+	fileID := gotgbot.InputFileByID(uploadedFile.FileID)
+	var sentMessage *gotgbot.Message
+	var err error
+	switch fileType {
+	case cache.TelegramFileTypePhoto:
+		sentMessage, err = bot.SendPhotoWithContext(ctx, chatID, fileID, &gotgbot.SendPhotoOpts{
+			Caption:   caption,
+			ParseMode: gotgbot.ParseModeMarkdownV2,
+		})
+	case cache.TelegramFileTypeVideo:
+		sentMessage, err = bot.SendVideoWithContext(ctx, chatID, fileID, &gotgbot.SendVideoOpts{
+			Caption:           caption,
+			ParseMode:         gotgbot.ParseModeMarkdownV2,
+			SupportsStreaming: true,
+		})
+	case cache.TelegramFileTypeAnimation:
+		sentMessage, err = bot.SendAnimationWithContext(ctx, chatID, fileID, &gotgbot.SendAnimationOpts{
+			Caption:   caption,
+			ParseMode: gotgbot.ParseModeMarkdownV2,
+		})
+	case cache.TelegramFileTypeDocument:
+		sentMessage, err = bot.SendDocumentWithContext(ctx, chatID, fileID, &gotgbot.SendDocumentOpts{
+			Caption:   caption,
+			ParseMode: gotgbot.ParseModeMarkdownV2,
+		})
+	case cache.TelegramFileTypeAudio:
+		sentMessage, err = bot.SendAudioWithContext(ctx, chatID, fileID, &gotgbot.SendAudioOpts{
+			Caption:   caption,
+			ParseMode: gotgbot.ParseModeMarkdownV2,
+		})
+	}
+	if err != nil {
+		logging.FromContext(ctx).Warn("Cannot send the uploaded file, uploading it again", "file_id", uploadedFile.FileID, "link", link, "error", err)
+		return nil
+	}
+	return sentMessage
+}
+
+// storeUploadedFile saves the file ID of a media which we have just uploaded in the cache
+// in order to send it again without downloading it. sentMessage can be nil.
+// See uploadedFileKey for audioLink.
+func (c *Client) storeUploadedFile(ctx context.Context, postFullname, link, audioLink string, fileType cache.TelegramFileType, sentMessage *gotgbot.Message) {
+	if postFullname == "" || sentMessage == nil {
+		return
+	}
+	fileID := getMessageFileID(sentMessage, fileType)
+	if fileID == "" {
+		return
+	}
+	err := c.CallbackCache.SetUploadedFile(uploadedFileKey(postFullname, link, audioLink, fileType), cache.UploadedFile{
+		FileID: fileID,
+		Type:   fileType,
+	})
+	if err != nil {
+		logging.FromContext(ctx).Error("Cannot set the uploaded file cache in database", "error", err)
+	}
+}
+
 // statusReporter starts reporting for uploading a thing in telegram
 // This function returns a channel which a message must be sent to it when reporting must be stopped
 // You can also close the channel to stop the reporter.
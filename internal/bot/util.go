@@ -3,26 +3,71 @@ package bot
 import (
 	"RedditDownloaderBot/internal/cache"
 	"RedditDownloaderBot/pkg/reddit"
-	"RedditDownloaderBot/pkg/util"
 	"github.com/PaulSonOfLars/gotgbot/v2"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 )
 
-// If this value is false, we will not add the link of the post to each message caption.
-var disableIncludeLinkInCaption = util.ParseEnvironmentVariableBool("DISABLE_LINK_IN_CAPTION")
-
 // The characters which needs to be escaped based on
 // https://core.telegram.org/bots/api#formatting-options
 var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)", "~", "\\~", "`", "\\`", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-", "=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!")
 
+// qualityLabel is the text of the button of a quality in the quality keyboards
+type qualityLabel struct {
+	text string
+	// If true, the button is not shown because the quality cannot be sent
+	hidden bool
+}
+
+// qualityLabels creates the labels of the qualities of medias which show their estimated sizes.
+// The qualities larger than maxSize are marked and the ones larger than sendLimit are hidden.
+// If all the qualities are larger than sendLimit, none of them are hidden and hasUnsendable is true.
+func qualityLabels(medias reddit.FetchResultMedia, maxSize, sendLimit int64) (labels []qualityLabel, hasTooLarge, hasUnsendable bool) {
+	labels = make([]qualityLabel, len(medias.Medias))
+	audioIndex, hasAudio := medias.HasAudio()
+	allUnsendable := true
+	for i, media := range medias.Medias {
+		size := media.Size
+		if hasAudio && i != audioIndex && size > 0 {
+			// The video is sent with its audio
+			size += medias.Medias[audioIndex].Size
+		}
+		labels[i].text = media.Quality
+		if size <= 0 {
+			allUnsendable = false
+			continue
+		}
+		labels[i].text += " (" + formatSize(size) + ")"
+		if size > maxSize {
+			labels[i].text = "⚠️ " + labels[i].text
+			hasTooLarge = true
+		}
+		if size > sendLimit {
+			labels[i].hidden = true
+		} else {
+			allUnsendable = false
+		}
+	}
+	if allUnsendable && hasTooLarge {
+		for i := range labels {
+			labels[i].hidden = false
+		}
+		hasUnsendable = true
+	}
+	return labels, hasTooLarge, hasUnsendable
+}
+
 // createPhotoInlineKeyboard creates inline keyboards to get the quality info of a photo
 // Each row represents a quality and each row has two columns: Send as photo or send as file
 // The id must match the ID in the mediaCache
-func createPhotoInlineKeyboard(id string, medias reddit.FetchResultMedia) gotgbot.InlineKeyboardMarkup {
-	rows := make([][]gotgbot.InlineKeyboardButton, len(medias.Medias))
-	for i, media := range medias.Medias {
+func createPhotoInlineKeyboard(id string, labels []qualityLabel) gotgbot.InlineKeyboardMarkup {
+	rows := make([][]gotgbot.InlineKeyboardButton, 0, len(labels))
+	for i, label := range labels {
+		if label.hidden {
+			continue
+		}
 		column := make([]gotgbot.InlineKeyboardButton, 2)
 		// One button to download as photo
 		info := CallbackButtonData{
@@ -31,25 +76,28 @@ func createPhotoInlineKeyboard(id string, medias reddit.FetchResultMedia) gotgbo
 			Mode:    CallbackButtonDataModePhoto,
 		}
 		column[0] = gotgbot.InlineKeyboardButton{
-			Text:         "Photo " + media.Quality,
+			Text:         "Photo " + label.text,
 			CallbackData: info.String(),
 		}
 		// One button to download as file
 		info.Mode = CallbackButtonDataModeFile
 		column[1] = gotgbot.InlineKeyboardButton{
-			Text:         "File " + media.Quality,
+			Text:         "File " + label.text,
 			CallbackData: info.String(),
 		}
 		// Add to rows
-		rows[i] = column
+		rows = append(rows, column)
 	}
 	return gotgbot.InlineKeyboardMarkup{InlineKeyboard: rows}
 }
 
-// createGifInlineKeyboard creates an inline keyboard for downloading gifs based on given reddit.FetchResultMedia
-func createGifInlineKeyboard(id string, medias reddit.FetchResultMedia) gotgbot.InlineKeyboardMarkup {
-	rows := make([][]gotgbot.InlineKeyboardButton, len(medias.Medias))
-	for i, media := range medias.Medias {
+// createGifInlineKeyboard creates an inline keyboard for downloading gifs based on the labels of their qualities
+func createGifInlineKeyboard(id string, labels []qualityLabel) gotgbot.InlineKeyboardMarkup {
+	rows := make([][]gotgbot.InlineKeyboardButton, 0, len(labels))
+	for i, label := range labels {
+		if label.hidden {
+			continue
+		}
 		// One button to download as gif only
 		// They don't support the file format
 		info := CallbackButtonData{
@@ -57,36 +105,37 @@ func createGifInlineKeyboard(id string, medias reddit.FetchResultMedia) gotgbot.
 			LinkKey: i,
 		}
 		// Add to rows
-		rows[i] = []gotgbot.InlineKeyboardButton{{
-			Text:         "GIF " + media.Quality,
+		rows = append(rows, []gotgbot.InlineKeyboardButton{{
+			Text:         "GIF " + label.text,
 			CallbackData: info.String(),
-		}}
+		}})
 	}
 	return gotgbot.InlineKeyboardMarkup{InlineKeyboard: rows}
 }
 
-// createVideoInlineKeyboard creates an inline keyboard for downloading gifs based on given reddit.FetchResultMedia
-func createVideoInlineKeyboard(id string, medias reddit.FetchResultMedia) gotgbot.InlineKeyboardMarkup {
-	rows := make([][]gotgbot.InlineKeyboardButton, len(medias.Medias))
-	for i, media := range medias.Medias {
-		// One button to download as gif only
-		// They don't support the file format
+// createVideoInlineKeyboard creates an inline keyboard for downloading videos based on the labels of their qualities
+func createVideoInlineKeyboard(id string, labels []qualityLabel) gotgbot.InlineKeyboardMarkup {
+	rows := make([][]gotgbot.InlineKeyboardButton, 0, len(labels))
+	for i, label := range labels {
+		if label.hidden {
+			continue
+		}
 		info := CallbackButtonData{
 			ID:      id,
 			LinkKey: i,
 		}
 		// Add to rows
-		rows[i] = []gotgbot.InlineKeyboardButton{{
-			Text:         media.Quality,
+		rows = append(rows, []gotgbot.InlineKeyboardButton{{
+			Text:         label.text,
 			CallbackData: info.String(),
-		}}
+		}})
 	}
 	return gotgbot.InlineKeyboardMarkup{InlineKeyboard: rows}
 }
 
-// Adds the link of the post to a text if needed (the INCLUDE_LINK is set)
-func addLinkIfNeeded(text, link string) string {
-	if disableIncludeLinkInCaption {
+// Adds the link of the post to a text if needed (DisableLinkInCaption is not set)
+func (c *Client) addLinkIfNeeded(text, link string) string {
+	if c.settings().DisableLinkInCaption {
 		return text
 	}
 	return text + "\n\n" + "[🔗 Link](" + link + ")"
@@ -148,3 +197,58 @@ func sendPostDescription(bot *gotgbot.Bot, description string, sentMessage *gotg
 	}
 	return err
 }
+
+// uploadedFileKey creates the key which the Telegram file ID of a media is stored with in the cache.
+// The link of the media also specifies its quality. audioLink is the link of the audio which is
+// merged into a video, so the videos with and without audio are stored separately. It is empty
+// for other medias.
+func uploadedFileKey(postFullname, link, audioLink string, fileType cache.TelegramFileType) string {
+	key := postFullname + ":" + strconv.Itoa(int(fileType)) + ":" + link
+	if audioLink != "" {
+		key += ":" + audioLink
+	}
+	return key
+}
+
+// getMessageFileID gets the file ID of the media in a message which was sent as fileType.
+// Returns an empty string if the message does not contain such media.
+func getMessageFileID(message *gotgbot.Message, fileType cache.TelegramFileType) string {
+	switch fileType {
+	case cache.TelegramFileTypePhoto:
+		if len(message.Photo) != 0 {
+			// The last photo is the biggest one
+			return message.Photo[len(message.Photo)-1].FileId
+		}
+	case cache.TelegramFileTypeVideo:
+		if message.Video != nil {
+			return message.Video.FileId
+		}
+	case cache.TelegramFileTypeAnimation:
+		if message.Animation != nil {
+			return message.Animation.FileId
+		}
+	case cache.TelegramFileTypeDocument:
+		if message.Document != nil {
+			return message.Document.FileId
+		}
+	case cache.TelegramFileTypeAudio:
+		if message.Audio != nil {
+			return message.Audio.FileId
+		}
+	}
+	return ""
+}
+
+// cutCommand checks if text is the command, optionally followed by @botUsername and an argument.
+// Returns the argument of the command.
+func cutCommand(text, command, botUsername string) (argument string, ok bool) {
+	rest, ok := strings.CutPrefix(text, command)
+	if !ok {
+		return "", false
+	}
+	rest = strings.TrimPrefix(rest, "@"+botUsername)
+	if rest != "" && rest[0] != ' ' {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"RedditDownloaderBot/pkg/logging"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/PaulSonOfLars/gotgbot/v2"
+	"github.com/PaulSonOfLars/gotgbot/v2/ext"
+	"github.com/go-faster/errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultWebhookPath is the path which the webhook is served on if the public URL
+// does not have any path
+const defaultWebhookPath = "telegram-webhook"
+
+// webhookShutdownTimeout is the time which we wait for the webhook requests to be answered on shutdown
+const webhookShutdownTimeout = 10 * time.Second
+
+// WebhookOptions configures the bot to receive the updates with a webhook instead of long polling
+type WebhookOptions struct {
+	// ListenAddress is the address which the HTTP server listens on. For example, :8080
+	ListenAddress string
+	// PublicURL is the URL which Telegram sends the updates to. The path of this URL is
+	// the path which the webhook is served on.
+	PublicURL string
+	// SecretToken is sent by Telegram in each request. Requests without this token are
+	// dropped. If empty, a token will be derived from the bot token so all the replicas
+	// of the bot use the same secret.
+	SecretToken string
+	// CertFile and KeyFile are the TLS certificate and key of the HTTP server. If empty, the
+	// server will use plain HTTP and must be behind a reverse proxy which terminates TLS.
+	CertFile string
+	KeyFile  string
+}
+
+// startWebhook starts the HTTP server which receives the updates from Telegram and
+// sets the webhook of the bot. It does not block. The returned server must be shut down
+// when the bot is stopped.
+func (c *Client) startWebhook(bot *gotgbot.Bot, updater *ext.Updater) (*http.Server, error) {
+	// Get the webhook path
+	publicURL, err := url.Parse(c.Webhook.PublicURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse the webhook URL")
+	}
+	urlPath := strings.Trim(publicURL.Path, "/")
+	if urlPath == "" {
+		urlPath = defaultWebhookPath
+		publicURL = publicURL.JoinPath(urlPath)
+	}
+	if (c.Webhook.CertFile == "") != (c.Webhook.KeyFile == "") {
+		return nil, errors.New("both of the certificate and key files must be set to use TLS")
+	}
+	secretToken := c.Webhook.SecretToken
+	if secretToken == "" {
+		secretToken = webhookSecretFromToken(bot.Token)
+	}
+	// Register the bot in the updater
+	err = updater.AddWebhook(bot, urlPath, &ext.AddWebhookOpts{SecretToken: secretToken})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot add the webhook")
+	}
+	// Start the server
+	mux := http.NewServeMux()
+	mux.Handle("/"+urlPath, updater.GetHandlerFunc("/"))
+	if c.Files != nil && c.FilesAddress == "" {
+		mux.Handle(c.Files.Pattern(), c.Files)
+	}
+	server := &http.Server{
+		Addr:              c.Webhook.ListenAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		var err error
+		if c.Webhook.CertFile != "" {
+			err = server.ListenAndServeTLS(c.Webhook.CertFile, c.Webhook.KeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Fatal("Webhook server failed", "error", err)
+		}
+	}()
+	// Tell Telegram to send the updates to us
+	_, err = bot.SetWebhook(publicURL.String(), &gotgbot.SetWebhookOpts{
+		DropPendingUpdates: c.DropPendingUpdates,
+		SecretToken:        secretToken,
+	})
+	if err != nil {
+		_ = server.Close()
+		return nil, errors.Wrap(err, "cannot set the webhook")
+	}
+	return server, nil
+}
+
+// webhookSecretFromToken derives a webhook secret token from the bot token.
+// The result only contains the characters which Telegram allows in secret tokens.
+func webhookSecretFromToken(token string) string {
+	hash := sha256.Sum256([]byte("webhook:" + token))
+	return hex.EncodeToString(hash[:])
+}
@@ -1,7 +1,9 @@
 package cache
 
 import (
+	"context"
 	"github.com/go-faster/errors"
+	"time"
 )
 
 // NotFoundErr will be returned if the key does not exist in database
@@ -21,6 +23,23 @@ type Interface interface {
 	// GetAndDeleteAlbumCache will atomically get an album cache and delete it from cache.
 	// If it does not exist, returns NotFoundErr as error
 	GetAndDeleteAlbumCache(key string) (CallbackAlbumCached, error)
+	// SetUploadedFile stores the Telegram file ID of a media which we have uploaded before.
+	// Unlike other caches, these entries live for a long time (see uploadedFileTTL).
+	SetUploadedFile(key string, value UploadedFile) error
+	// GetUploadedFile gets the Telegram file ID of a media without deleting it.
+	// If it does not exist, returns NotFoundErr as error
+	GetUploadedFile(key string) (UploadedFile, error)
+	// MarkUpdateProcessed atomically marks a Telegram update as processed and returns true
+	// if it had already been marked before. Marks live for processedUpdateTTL.
+	MarkUpdateProcessed(updateID int64) (bool, error)
+	// IncrementCounter atomically adds delta to a counter and returns its new value. If the
+	// counter does not exist, it is created with the value of delta. The counter never goes
+	// below zero and it expires after ttl, which is reset on each increment.
+	IncrementCounter(key string, delta int64, ttl time.Duration) (int64, error)
+	// GetCounter returns the value of a counter. Counters which do not exist are zero.
+	GetCounter(key string) (int64, error)
+	// Ping checks if the cache is reachable
+	Ping(ctx context.Context) error
 	// Close must close the underlying database connection
 	Close() error
 }
@@ -1,12 +1,17 @@
 package cache
 
 import (
+	"RedditDownloaderBot/pkg/metrics"
+	"context"
 	"sync"
 	"time"
 )
 
 var _ Interface = &MemoryCache{}
 
+// memoryBackendName is the name of MemoryCache in metrics
+const memoryBackendName = "memory"
+
 // singleMemoryCache is a KV cache which it's elements are deleted when cleanUp is called.
 // Data is stored in ram
 type singleMemoryCache[K comparable, V any] struct {
@@ -54,10 +59,87 @@ func (c *singleMemoryCache[K, V]) getAndDelete(key K) (V, bool) {
 	return data.data, ok
 }
 
+// setIfNotExists will set a key only if it does not exist in cache.
+// Returns true if the key already existed.
+func (c *singleMemoryCache[K, V]) setIfNotExists(key K, value V) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, exists := c.cache[key]; exists {
+		return true
+	}
+	c.cache[key] = memoryCacheElement[V]{
+		data:      value,
+		addedTime: time.Now(),
+	}
+	return false
+}
+
+// get will get an element from cache without deleting it
+func (c *singleMemoryCache[K, V]) get(key K) (V, bool) {
+	c.lock.Lock()
+	data, ok := c.cache[key]
+	c.lock.Unlock()
+	return data.data, ok
+}
+
+// memoryCounters are the counters of MemoryCache. Unlike singleMemoryCache, each
+// counter has its own expiry time.
+type memoryCounters struct {
+	counters map[string]memoryCounter
+	lock     sync.Mutex
+}
+
+// memoryCounter is each counter in memoryCounters
+type memoryCounter struct {
+	value   int64
+	expires time.Time
+}
+
+// cleanUp will delete the expired counters
+func (c *memoryCounters) cleanUp() {
+	now := time.Now()
+	c.lock.Lock()
+	for k, v := range c.counters {
+		if now.After(v.expires) {
+			delete(c.counters, k)
+		}
+	}
+	c.lock.Unlock()
+}
+
+// increment adds delta to a counter and returns the new value
+func (c *memoryCounters) increment(key string, delta int64, ttl time.Duration) int64 {
+	now := time.Now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	counter, exists := c.counters[key]
+	if !exists || now.After(counter.expires) {
+		counter = memoryCounter{expires: now.Add(ttl)}
+	}
+	counter.value = max(counter.value+delta, 0)
+	counter.expires = now.Add(ttl)
+	c.counters[key] = counter
+	return counter.value
+}
+
+// get returns the value of a counter
+func (c *memoryCounters) get(key string) int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	counter, exists := c.counters[key]
+	if !exists || time.Now().After(counter.expires) {
+		return 0
+	}
+	return counter.value
+}
+
 // MemoryCache is an in memory cache to handle the callback data
 type MemoryCache struct {
-	mediaCache singleMemoryCache[string, CallbackDataCached]
-	albumCache singleMemoryCache[string, CallbackAlbumCached]
+	mediaCache        singleMemoryCache[string, CallbackDataCached]
+	albumCache        singleMemoryCache[string, CallbackAlbumCached]
+	uploadedFileCache singleMemoryCache[string, UploadedFile]
+	processedUpdates  singleMemoryCache[int64, struct{}]
+	counters          memoryCounters
 	// Close this channel to stop the cleanup
 	cleanUpDoneChannel chan struct{}
 }
@@ -74,6 +156,15 @@ func NewMemoryCache(ttl, cleanUpInterval time.Duration) *MemoryCache {
 		albumCache: singleMemoryCache[string, CallbackAlbumCached]{
 			cache: make(map[string]memoryCacheElement[CallbackAlbumCached]),
 		},
+		uploadedFileCache: singleMemoryCache[string, UploadedFile]{
+			cache: make(map[string]memoryCacheElement[UploadedFile]),
+		},
+		processedUpdates: singleMemoryCache[int64, struct{}]{
+			cache: make(map[int64]memoryCacheElement[struct{}]),
+		},
+		counters: memoryCounters{
+			counters: make(map[string]memoryCounter),
+		},
 		cleanUpDoneChannel: make(chan struct{}),
 	}
 	go c.cleanUp(ttl, cleanUpInterval)
@@ -89,6 +180,9 @@ func (c *MemoryCache) cleanUp(ttl, cleanUpInterval time.Duration) {
 		case <-cleanUpWait.C:
 			c.albumCache.cleanUp(ttl)
 			c.mediaCache.cleanUp(ttl)
+			c.uploadedFileCache.cleanUp(uploadedFileTTL)
+			c.processedUpdates.cleanUp(processedUpdateTTL)
+			c.counters.cleanUp()
 		case <-c.cleanUpDoneChannel:
 			cleanUpWait.Stop()
 			return
@@ -103,6 +197,7 @@ func (c *MemoryCache) SetMediaCache(key string, value CallbackDataCached) error
 
 func (c *MemoryCache) GetAndDeleteMediaCache(key string) (CallbackDataCached, error) {
 	value, exists := c.mediaCache.getAndDelete(key)
+	metrics.CacheLookup(memoryBackendName, mediaCacheName, exists)
 	var err error
 	if !exists {
 		err = NotFoundErr
@@ -117,6 +212,7 @@ func (c *MemoryCache) SetAlbumCache(key string, value CallbackAlbumCached) error
 
 func (c *MemoryCache) GetAndDeleteAlbumCache(key string) (CallbackAlbumCached, error) {
 	value, exists := c.albumCache.getAndDelete(key)
+	metrics.CacheLookup(memoryBackendName, albumCacheName, exists)
 	var err error
 	if !exists {
 		err = NotFoundErr
@@ -124,6 +220,39 @@ func (c *MemoryCache) GetAndDeleteAlbumCache(key string) (CallbackAlbumCached, e
 	return value, err
 }
 
+func (c *MemoryCache) SetUploadedFile(key string, value UploadedFile) error {
+	c.uploadedFileCache.set(key, value)
+	return nil
+}
+
+func (c *MemoryCache) GetUploadedFile(key string) (UploadedFile, error) {
+	value, exists := c.uploadedFileCache.get(key)
+	metrics.CacheLookup(memoryBackendName, uploadedFileCacheName, exists)
+	var err error
+	if !exists {
+		err = NotFoundErr
+	}
+	return value, err
+}
+
+func (c *MemoryCache) MarkUpdateProcessed(updateID int64) (bool, error) {
+	// The processed updates are lost on restart. Only RedisCache de-duplicates the updates across restarts.
+	return c.processedUpdates.setIfNotExists(updateID, struct{}{}), nil
+}
+
+func (c *MemoryCache) IncrementCounter(key string, delta int64, ttl time.Duration) (int64, error) {
+	return c.counters.increment(key, delta, ttl), nil
+}
+
+func (c *MemoryCache) GetCounter(key string) (int64, error) {
+	return c.counters.get(key), nil
+}
+
+// Ping always succeeds because the memory cache is always reachable
+func (c *MemoryCache) Ping(context.Context) error {
+	return nil
+}
+
 // Close will cancel the clean-up goroutine
 func (c *MemoryCache) Close() error {
 	close(c.cleanUpDoneChannel)
@@ -1,20 +1,28 @@
 package cache
 
 import (
+	"RedditDownloaderBot/pkg/metrics"
 	"RedditDownloaderBot/pkg/util"
 	"context"
 	"encoding/json"
 	"github.com/go-faster/errors"
 	"github.com/redis/go-redis/v9"
+	"strconv"
 	"strings"
 	"time"
 )
 
 var _ Interface = RedisCache{}
 
+// redisBackendName is the name of RedisCache in metrics
+const redisBackendName = "redis"
+
 // Define the prefixes of keys
 const redisMediaCachePrefix = "media:"
 const redisAlbumCachePrefix = "album:"
+const redisUploadedFilePrefix = "file:"
+const redisProcessedUpdatePrefix = "update:"
+const redisCounterPrefix = "counter:"
 
 // RedisCache satisfies Interface backed by a Redis server
 type RedisCache struct {
@@ -41,11 +49,13 @@ func (r RedisCache) SetMediaCache(key string, value CallbackDataCached) error {
 }
 
 func (r RedisCache) GetAndDeleteMediaCache(key string) (CallbackDataCached, error) {
-	return parseRedisJson[CallbackDataCached](
+	result, err := parseRedisJson[CallbackDataCached](
 		r.client.
 			GetDel(context.Background(), redisMediaCachePrefix+key).
 			Result(),
 	)
+	recordRedisLookup(mediaCacheName, err)
+	return result, err
 }
 
 func (r RedisCache) SetAlbumCache(key string, value CallbackAlbumCached) error {
@@ -55,17 +65,91 @@ func (r RedisCache) SetAlbumCache(key string, value CallbackAlbumCached) error {
 }
 
 func (r RedisCache) GetAndDeleteAlbumCache(key string) (CallbackAlbumCached, error) {
-	return parseRedisJson[CallbackAlbumCached](
+	result, err := parseRedisJson[CallbackAlbumCached](
 		r.client.
 			GetDel(context.Background(), redisAlbumCachePrefix+key).
 			Result(),
 	)
+	recordRedisLookup(albumCacheName, err)
+	return result, err
+}
+
+func (r RedisCache) SetUploadedFile(key string, value UploadedFile) error {
+	return r.client.
+		Set(context.Background(), redisUploadedFilePrefix+key, util.ToJsonString(value), uploadedFileTTL).
+		Err()
+}
+
+func (r RedisCache) GetUploadedFile(key string) (UploadedFile, error) {
+	result, err := parseRedisJson[UploadedFile](
+		r.client.
+			Get(context.Background(), redisUploadedFilePrefix+key).
+			Result(),
+	)
+	recordRedisLookup(uploadedFileCacheName, err)
+	return result, err
+}
+
+func (r RedisCache) MarkUpdateProcessed(updateID int64) (bool, error) {
+	set, err := r.client.
+		SetNX(context.Background(), redisProcessedUpdatePrefix+strconv.FormatInt(updateID, 10), 1, processedUpdateTTL).
+		Result()
+	if err != nil {
+		return false, errors.Wrap(err, "Unable to set the update in Redis")
+	}
+	return !set, nil
+}
+
+// incrementCounterScript adds ARGV[1] to the counter at KEYS[1], clamps it at zero and
+// sets its expiry to ARGV[2] milliseconds in a single step, so a counter can never be left
+// without an expiry.
+var incrementCounterScript = redis.NewScript(`
+local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+if value < 0 then
+	value = 0
+	redis.call("SET", KEYS[1], 0)
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+return value
+`)
+
+func (r RedisCache) IncrementCounter(key string, delta int64, ttl time.Duration) (int64, error) {
+	value, err := incrementCounterScript.
+		Run(context.Background(), r.client, []string{redisCounterPrefix + key}, delta, ttl.Milliseconds()).
+		Int64()
+	if err != nil {
+		return 0, errors.Wrap(err, "Unable to increment the counter in Redis")
+	}
+	return value, nil
+}
+
+func (r RedisCache) GetCounter(key string) (int64, error) {
+	value, err := r.client.Get(context.Background(), redisCounterPrefix+key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "Unable to get the counter from Redis")
+	}
+	return value, nil
+}
+
+func (r RedisCache) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
 }
 
 func (r RedisCache) Close() error {
 	return r.client.Close()
 }
 
+// recordRedisLookup records the result of looking up a key in metrics
+func recordRedisLookup(cacheName string, err error) {
+	if err == nil {
+		metrics.CacheLookup(redisBackendName, cacheName, true)
+	} else if errors.Is(err, NotFoundErr) {
+		metrics.CacheLookup(redisBackendName, cacheName, false)
+	}
+}
+
 // parseRedisJson will get the value of a key which is in redis + the error message of redis.
 // Then, it tries to parse the data as the generic struct passed to it.
 func parseRedisJson[T any](val string, err error) (T, error) {
@@ -1,12 +1,32 @@
 package cache
 
-import "RedditDownloaderBot/pkg/reddit"
+import (
+	"RedditDownloaderBot/pkg/reddit"
+	"time"
+)
+
+// uploadedFileTTL is the time which the UploadedFile entries are kept in cache.
+// Telegram file IDs do not expire, but we don't want to keep them forever.
+const uploadedFileTTL = 30 * 24 * time.Hour
+
+// processedUpdateTTL is the time which we remember the processed Telegram updates.
+// Telegram does not keep the pending updates for more than 24 hours.
+const processedUpdateTTL = 24 * time.Hour
+
+// The names of the caches in metrics
+const (
+	mediaCacheName        = "media"
+	albumCacheName        = "album"
+	uploadedFileCacheName = "uploaded_file"
+)
 
 // CallbackDataCached is the data we store associated with an ID which is CallbackButtonData.ID
 // We store this type in mediaCache
 type CallbackDataCached struct {
 	// The link of the post itself
 	PostLink string
+	// The fullname of the post. Used to store the uploaded files
+	PostFullname string
 	// The list of links which the one in CallbackButtonData.LinkKey is used
 	Links map[int]Media
 	// Title of the post
@@ -39,6 +59,28 @@ type Media struct {
 type CallbackAlbumCached struct {
 	// The link of the post itself
 	PostLink string
+	// The fullname of the post. Used to store the uploaded files
+	PostFullname string
 	// The album data
 	Album reddit.FetchResultAlbum
 }
+
+// TelegramFileType is the method which a file has been sent to Telegram with
+type TelegramFileType uint8
+
+const (
+	TelegramFileTypePhoto TelegramFileType = iota
+	TelegramFileTypeVideo
+	TelegramFileTypeAnimation
+	TelegramFileTypeDocument
+	TelegramFileTypeAudio
+)
+
+// UploadedFile is a media which has been uploaded to Telegram before and can be
+// sent again only by its file ID
+type UploadedFile struct {
+	// The file ID in Telegram
+	FileID string
+	// How this file was sent to Telegram
+	Type TelegramFileType
+}
@@ -0,0 +1,327 @@
+// Package config loads the configuration of the bot from a YAML file and environment variables
+package config
+
+import (
+	"RedditDownloaderBot/pkg/logging"
+	"bytes"
+	"github.com/go-faster/errors"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the configuration of the bot.
+//
+// The settings which are marked as reloadable can be changed by editing the file and sending
+// SIGHUP to the bot. Changing other settings needs a restart.
+type Config struct {
+	Telegram   Telegram   `yaml:"telegram"`
+	Reddit     Reddit     `yaml:"reddit"`
+	Redis      Redis      `yaml:"redis"`
+	Webhook    Webhook    `yaml:"webhook"`
+	Queue      Queue      `yaml:"queue"`
+	RateLimit  RateLimit  `yaml:"rate_limit"`
+	Monitoring Monitoring `yaml:"monitoring"`
+	Usage      Usage      `yaml:"usage"`
+	Video      Video      `yaml:"video"`
+	Files      Files      `yaml:"files"`
+	Log        Log        `yaml:"log"`
+	// On shutdown, the bot waits this long for the running jobs before canceling them
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+// Telegram configures the Telegram bot
+type Telegram struct {
+	Token string `yaml:"token"`
+	// The users which can use the bot. Empty means everyone. Reloadable.
+	AllowedUsers []int64 `yaml:"allowed_users"`
+	// The users whose requests are run before others and who can mute the error reports. Reloadable.
+	AdminUsers []int64 `yaml:"admin_users"`
+	// The chats which the error reports are sent to. Reloadable.
+	AdminChats []int64 `yaml:"admin_chats"`
+	// Drop the updates which were sent while the bot was offline
+	DropPendingUpdates bool `yaml:"drop_pending_updates"`
+	// Messages older than this are not processed. Zero means no limit. Reloadable.
+	MaxUpdateAge time.Duration `yaml:"max_update_age"`
+	// Do not add the link of the post to the captions. Reloadable.
+	DisableLinkInCaption bool `yaml:"disable_link_in_caption"`
+	// Download the best quality which fits in the upload limit instead of asking the users.
+	// The users can change it for themselves with /quality. Reloadable.
+	AutoQuality bool `yaml:"auto_quality"`
+	// The base URL of a self-hosted Bot API server. Empty means api.telegram.org.
+	APIURL string `yaml:"api_url"`
+	// The Bot API server can read the files of the bot, so they are sent by their paths
+	LocalFiles bool `yaml:"local_files"`
+}
+
+// Reddit configures the Reddit API client
+type Reddit struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	// The proxy which the Imgur media are downloaded through
+	ImgurProxy string `yaml:"imgur_proxy"`
+	// Reject NSFW posts. Reloadable.
+	DenyNSFW bool `yaml:"deny_nsfw"`
+}
+
+// Redis configures the Redis cache. If Address is empty, an in-memory cache is used.
+type Redis struct {
+	Address  string        `yaml:"address"`
+	Port     int           `yaml:"port"`
+	Password string        `yaml:"password"`
+	TTL      time.Duration `yaml:"ttl"`
+}
+
+// Webhook configures receiving the updates with a webhook. If URL is empty, long polling is used.
+type Webhook struct {
+	URL     string `yaml:"url"`
+	Listen  string `yaml:"listen"`
+	Secret  string `yaml:"secret"`
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+}
+
+// Queue configures the job queue
+type Queue struct {
+	Workers        int `yaml:"workers"`
+	MaxJobsPerUser int `yaml:"max_jobs_per_user"`
+}
+
+// RateLimit configures the per-user limits. Zero means no limit. Reloadable.
+type RateLimit struct {
+	RequestsPerMinute int                 `yaml:"requests_per_minute"`
+	ConcurrentJobs    int                 `yaml:"concurrent_jobs"`
+	MBPerDay          int64               `yaml:"mb_per_day"`
+	Overrides         []RateLimitOverride `yaml:"overrides"`
+}
+
+// RateLimitOverride are the limits of a specific user
+type RateLimitOverride struct {
+	User              int64 `yaml:"user"`
+	RequestsPerMinute int   `yaml:"requests_per_minute"`
+	ConcurrentJobs    int   `yaml:"concurrent_jobs"`
+	MBPerDay          int64 `yaml:"mb_per_day"`
+}
+
+// Monitoring configures the metrics and health check server
+type Monitoring struct {
+	// The address of the server. Empty means no server.
+	Listen string `yaml:"listen"`
+}
+
+// Usage configures the store of the users and their requests. If Redis is configured, the
+// store is kept in Redis and File is ignored.
+type Usage struct {
+	// The JSON file which the store is saved in. Empty means the store is kept in memory only.
+	File string `yaml:"file"`
+}
+
+// Video configures the handling of the videos which are too large to upload on Telegram.
+// By default, they are split into parts.
+type Video struct {
+	// Re-encode the large videos with a lower bitrate instead of splitting them. Reloadable.
+	ReencodeOversized bool `yaml:"reencode_oversized"`
+	// The number of videos which can be re-encoded at once
+	ReencodeWorkers int `yaml:"reencode_workers"`
+	// If re-encoding a video takes longer than this, the video is split instead
+	ReencodeTimeout time.Duration `yaml:"reencode_timeout"`
+}
+
+// Files configures serving the files which are too large for Telegram with expiring links.
+// If Directory is empty, the raw links of the media are sent instead.
+type Files struct {
+	// The directory which the files are kept in
+	Directory string `yaml:"dir"`
+	// The URL which the files are served on. For example, https://bot.example.com/files
+	PublicURL string `yaml:"public_url"`
+	// The address which the files are served on. Empty means the webhook server.
+	Listen string `yaml:"listen"`
+	// The key which the links are signed with. Empty means a key derived from the bot token.
+	Secret string `yaml:"secret"`
+	// The time which the files are kept and their links are valid
+	TTL time.Duration `yaml:"ttl"`
+	// The maximum total size of the files
+	QuotaMB int64 `yaml:"quota_mb"`
+	// The maximum size of a single file. The larger media are not downloaded at all.
+	MaxFileMB int64 `yaml:"max_file_mb"`
+}
+
+// Log configures the logs
+type Log struct {
+	// The minimum level of the logs. Reloadable.
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Default returns the configuration which is used for the settings which are not set
+func Default() *Config {
+	return &Config{
+		Telegram: Telegram{
+			MaxUpdateAge: 10 * time.Minute,
+		},
+		Redis: Redis{
+			Port: 6379,
+			TTL:  5 * time.Minute,
+		},
+		Webhook: Webhook{
+			Listen: ":8080",
+		},
+		Queue: Queue{
+			Workers: 4,
+		},
+		Video: Video{
+			ReencodeWorkers: 1,
+			ReencodeTimeout: 5 * time.Minute,
+		},
+		Files: Files{
+			TTL:       24 * time.Hour,
+			QuotaMB:   10000,
+			MaxFileMB: 2000,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: string(logging.FormatText),
+		},
+		ShutdownTimeout: 25 * time.Second,
+	}
+}
+
+// Load loads the configuration. The defaults are overridden by the file in path (if path is
+// not empty) and then by the environment variables. The result is validated.
+func Load(path string) (*Config, error) {
+	config := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read the config file")
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(config); err != nil && err != io.EOF {
+			return nil, errors.Wrap(err, "cannot parse the config file")
+		}
+	}
+	if err := config.applyEnvironment(); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// ValidationError contains all the problems of an invalid configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate checks the configuration and returns a *ValidationError if it is invalid
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+	check(c.Telegram.Token != "", "telegram.token (BOT_TOKEN) is required")
+	check(c.Reddit.ClientID != "", "reddit.client_id (CLIENT_ID) is required")
+	check(c.Reddit.ClientSecret != "", "reddit.client_secret (CLIENT_SECRET) is required")
+	check(c.Telegram.MaxUpdateAge >= 0, "telegram.max_update_age must not be negative")
+	if c.Telegram.APIURL != "" {
+		apiURL, err := url.Parse(c.Telegram.APIURL)
+		check(err == nil && (apiURL.Scheme == "http" || apiURL.Scheme == "https") && apiURL.Host != "",
+			"telegram.api_url must be a URL like http://localhost:8081")
+	}
+	check(!c.Telegram.LocalFiles || c.Telegram.APIURL != "", "telegram.local_files needs telegram.api_url")
+	if c.Reddit.ImgurProxy != "" {
+		proxyURL, err := url.Parse(c.Reddit.ImgurProxy)
+		check(err == nil && proxyURL.Scheme != "" && proxyURL.Host != "", "reddit.imgur_proxy must be a URL like socks5://127.0.0.1:1080")
+	}
+	if c.Redis.Address != "" {
+		check(c.Redis.Port > 0 && c.Redis.Port < 65536, "redis.port must be between 1 and 65535")
+		check(c.Redis.TTL > 0, "redis.ttl must be positive")
+	}
+	if c.Webhook.URL != "" {
+		webhookURL, err := url.Parse(c.Webhook.URL)
+		check(err == nil && webhookURL.Scheme == "https" && webhookURL.Host != "", "webhook.url must be an https URL")
+		check(c.Webhook.Listen != "", "webhook.listen is required when webhook.url is set")
+		check((c.Webhook.TLSCert == "") == (c.Webhook.TLSKey == ""), "webhook.tls_cert and webhook.tls_key must be set together")
+	}
+	check(c.Queue.Workers > 0, "queue.workers must be positive")
+	check(c.Queue.MaxJobsPerUser >= 0, "queue.max_jobs_per_user must not be negative")
+	check(c.RateLimit.RequestsPerMinute >= 0 && c.RateLimit.ConcurrentJobs >= 0 && c.RateLimit.MBPerDay >= 0,
+		"rate_limit values must not be negative")
+	seenOverrides := make(map[int64]bool, len(c.RateLimit.Overrides))
+	for _, override := range c.RateLimit.Overrides {
+		check(override.User != 0, "rate_limit.overrides entries must have a user")
+		check(!seenOverrides[override.User], "rate_limit.overrides has user "+strconv.FormatInt(override.User, 10)+" more than once")
+		check(override.RequestsPerMinute >= 0 && override.ConcurrentJobs >= 0 && override.MBPerDay >= 0,
+			"rate_limit.overrides values must not be negative")
+		seenOverrides[override.User] = true
+	}
+	check(c.Video.ReencodeWorkers > 0, "video.reencode_workers must be positive")
+	check(c.Video.ReencodeTimeout > 0, "video.reencode_timeout must be positive")
+	if c.Files.Directory != "" {
+		publicURL, err := url.Parse(c.Files.PublicURL)
+		check(err == nil && (publicURL.Scheme == "http" || publicURL.Scheme == "https") && publicURL.Host != "",
+			"files.public_url must be a URL like https://bot.example.com/files")
+		check(c.Files.Listen != "" || c.Webhook.URL != "", "files.listen is required when webhook.url is not set")
+		check(c.Files.TTL > 0, "files.ttl must be positive")
+		check(c.Files.QuotaMB > 0, "files.quota_mb must be positive")
+		check(c.Files.MaxFileMB > 0, "files.max_file_mb must be positive")
+	}
+	_, err := logging.ParseLevel(c.Log.Level)
+	check(err == nil, "log.level must be one of debug, info, warn, or error")
+	_, err = logging.ParseFormat(c.Log.Format)
+	check(err == nil, "log.format must be text or json")
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	if len(problems) != 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// ChangedStructuralSettings returns the names of the settings which differ between c and other
+// and cannot be reloaded without a restart
+func (c *Config) ChangedStructuralSettings(other *Config) []string {
+	var changed []string
+	a, b := c.structural(), other.structural()
+	sections := reflect.TypeOf(a)
+	for i := 0; i < sections.NumField(); i++ {
+		if !reflect.DeepEqual(reflect.ValueOf(a).Field(i).Interface(), reflect.ValueOf(b).Field(i).Interface()) {
+			changed = append(changed, sections.Field(i).Tag.Get("yaml"))
+		}
+	}
+	return changed
+}
+
+// structural returns a copy of the config without the reloadable settings
+func (c *Config) structural() Config {
+	return *c.WithReloadable(&Config{})
+}
+
+// WithReloadable returns a copy of the config with the reloadable settings of other. The
+// structural settings of c are kept, because they cannot be changed while the bot is running.
+func (c *Config) WithReloadable(other *Config) *Config {
+	result := *c
+	result.Telegram.AllowedUsers = other.Telegram.AllowedUsers
+	result.Telegram.AdminUsers = other.Telegram.AdminUsers
+	result.Telegram.AdminChats = other.Telegram.AdminChats
+	result.Telegram.MaxUpdateAge = other.Telegram.MaxUpdateAge
+	result.Telegram.DisableLinkInCaption = other.Telegram.DisableLinkInCaption
+	result.Telegram.AutoQuality = other.Telegram.AutoQuality
+	result.Reddit.DenyNSFW = other.Reddit.DenyNSFW
+	result.RateLimit = other.RateLimit
+	result.Video.ReencodeOversized = other.Video.ReencodeOversized
+	result.Log.Level = other.Log.Level
+	return &result
+}
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// validConfig returns the default config with the required settings
+func validConfig() *Config {
+	config := Default()
+	config.Telegram.Token = "1234:token"
+	config.Reddit.ClientID = "id"
+	config.Reddit.ClientSecret = "secret"
+	return config
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Change   func(c *Config)
+		Problems []string
+	}{
+		{Name: "valid", Change: func(c *Config) {}},
+		{
+			Name:   "default",
+			Change: func(c *Config) { *c = *Default() },
+			Problems: []string{
+				"telegram.token (BOT_TOKEN) is required",
+				"reddit.client_id (CLIENT_ID) is required",
+				"reddit.client_secret (CLIENT_SECRET) is required",
+			},
+		},
+		{
+			Name:     "invalid_api_url",
+			Change:   func(c *Config) { c.Telegram.APIURL = "localhost:8081" },
+			Problems: []string{"telegram.api_url must be a URL like http://localhost:8081"},
+		},
+		{
+			Name:     "local_files_without_api_url",
+			Change:   func(c *Config) { c.Telegram.LocalFiles = true },
+			Problems: []string{"telegram.local_files needs telegram.api_url"},
+		},
+		{
+			Name:     "invalid_imgur_proxy",
+			Change:   func(c *Config) { c.Reddit.ImgurProxy = "127.0.0.1:1080" },
+			Problems: []string{"reddit.imgur_proxy must be a URL like socks5://127.0.0.1:1080"},
+		},
+		{
+			Name: "invalid_redis",
+			Change: func(c *Config) {
+				c.Redis.Address = "localhost"
+				c.Redis.Port = 70000
+				c.Redis.TTL = 0
+			},
+			Problems: []string{"redis.port must be between 1 and 65535", "redis.ttl must be positive"},
+		},
+		{
+			Name: "invalid_webhook",
+			Change: func(c *Config) {
+				c.Webhook.URL = "http://bot.example.com"
+				c.Webhook.Listen = ""
+				c.Webhook.TLSCert = "cert.pem"
+			},
+			Problems: []string{
+				"webhook.url must be an https URL",
+				"webhook.listen is required when webhook.url is set",
+				"webhook.tls_cert and webhook.tls_key must be set together",
+			},
+		},
+		{
+			Name: "invalid_queue",
+			Change: func(c *Config) {
+				c.Queue.Workers = 0
+				c.Queue.MaxJobsPerUser = -1
+			},
+			Problems: []string{"queue.workers must be positive", "queue.max_jobs_per_user must not be negative"},
+		},
+		{
+			Name:     "negative_rate_limit",
+			Change:   func(c *Config) { c.RateLimit.MBPerDay = -1 },
+			Problems: []string{"rate_limit values must not be negative"},
+		},
+		{
+			Name: "invalid_overrides",
+			Change: func(c *Config) {
+				c.RateLimit.Overrides = []RateLimitOverride{{User: 1}, {User: 1, ConcurrentJobs: -1}, {}}
+			},
+			Problems: []string{
+				"rate_limit.overrides has user 1 more than once",
+				"rate_limit.overrides values must not be negative",
+				"rate_limit.overrides entries must have a user",
+			},
+		},
+		{
+			Name: "invalid_video",
+			Change: func(c *Config) {
+				c.Video.ReencodeWorkers = 0
+				c.Video.ReencodeTimeout = 0
+			},
+			Problems: []string{"video.reencode_workers must be positive", "video.reencode_timeout must be positive"},
+		},
+		{
+			Name: "invalid_files",
+			Change: func(c *Config) {
+				c.Files.Directory = "/tmp/files"
+				c.Files.TTL = 0
+				c.Files.QuotaMB = 0
+				c.Files.MaxFileMB = 0
+			},
+			Problems: []string{
+				"files.public_url must be a URL like https://bot.example.com/files",
+				"files.listen is required when webhook.url is not set",
+				"files.ttl must be positive",
+				"files.quota_mb must be positive",
+				"files.max_file_mb must be positive",
+			},
+		},
+		{
+			Name: "valid_files",
+			Change: func(c *Config) {
+				c.Files.Directory = "/tmp/files"
+				c.Files.PublicURL = "https://bot.example.com/files"
+				c.Files.Listen = ":8081"
+			},
+		},
+		{
+			Name: "invalid_log",
+			Change: func(c *Config) {
+				c.Log.Level = "verbose"
+				c.Log.Format = "xml"
+			},
+			Problems: []string{"log.level must be one of debug, info, warn, or error", "log.format must be text or json"},
+		},
+		{
+			Name:     "invalid_shutdown_timeout",
+			Change:   func(c *Config) { c.ShutdownTimeout = 0 },
+			Problems: []string{"shutdown_timeout must be positive"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			config := validConfig()
+			test.Change(config)
+			err := config.Validate()
+			if len(test.Problems) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var validationError *ValidationError
+			if assert.ErrorAs(t, err, &validationError) {
+				assert.Equal(t, test.Problems, validationError.Problems)
+			}
+		})
+	}
+}
+
+func TestChangedStructuralSettings(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Change   func(c *Config)
+		Expected []string
+	}{
+		{Name: "nothing", Change: func(c *Config) {}},
+		{Name: "token", Change: func(c *Config) { c.Telegram.Token = "5678:token" }, Expected: []string{"telegram"}},
+		{Name: "workers", Change: func(c *Config) { c.Queue.Workers = 8 }, Expected: []string{"queue"}},
+		{
+			Name: "several_sections",
+			Change: func(c *Config) {
+				c.Redis.Address = "localhost"
+				c.Files.QuotaMB = 1
+				c.ShutdownTimeout = time.Minute
+			},
+			Expected: []string{"redis", "files", "shutdown_timeout"},
+		},
+		{
+			Name: "structural_and_reloadable",
+			Change: func(c *Config) {
+				c.Video.ReencodeWorkers = 2
+				c.Video.ReencodeOversized = true
+			},
+			Expected: []string{"video"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			old, changed := validConfig(), validConfig()
+			test.Change(changed)
+			assert.Equal(t, test.Expected, old.ChangedStructuralSettings(changed))
+		})
+	}
+}
+
+// TestReloadableSettingsAreNotStructural makes sure that changing any reloadable setting
+// does not ask for a restart. Add the new reloadable settings here.
+func TestReloadableSettingsAreNotStructural(t *testing.T) {
+	reloadable := map[string]func(c *Config){
+		"telegram.allowed_users":           func(c *Config) { c.Telegram.AllowedUsers = []int64{1} },
+		"telegram.admin_users":             func(c *Config) { c.Telegram.AdminUsers = []int64{1} },
+		"telegram.admin_chats":             func(c *Config) { c.Telegram.AdminChats = []int64{1} },
+		"telegram.max_update_age":          func(c *Config) { c.Telegram.MaxUpdateAge = time.Hour },
+		"telegram.disable_link_in_caption": func(c *Config) { c.Telegram.DisableLinkInCaption = true },
+		"telegram.auto_quality":            func(c *Config) { c.Telegram.AutoQuality = true },
+		"reddit.deny_nsfw":                 func(c *Config) { c.Reddit.DenyNSFW = true },
+		"rate_limit": func(c *Config) {
+			c.RateLimit = RateLimit{
+				RequestsPerMinute: 1,
+				ConcurrentJobs:    1,
+				MBPerDay:          1,
+				Overrides:         []RateLimitOverride{{User: 1}},
+			}
+		},
+		"video.reencode_oversized": func(c *Config) { c.Video.ReencodeOversized = true },
+		"log.level":                func(c *Config) { c.Log.Level = "debug" },
+	}
+	for name, change := range reloadable {
+		t.Run(name, func(t *testing.T) {
+			old, changed := validConfig(), validConfig()
+			change(changed)
+			assert.NotEqual(t, old, changed)
+			assert.Empty(t, old.ChangedStructuralSettings(changed))
+			assert.Equal(t, changed, old.WithReloadable(changed))
+		})
+	}
+}
+
+func TestWithReloadable(t *testing.T) {
+	current, changed := validConfig(), validConfig()
+	changed.Telegram.Token = "5678:token"
+	changed.Telegram.AdminUsers = []int64{1}
+	changed.Queue.Workers = 8
+	changed.Video.ReencodeWorkers = 2
+	changed.Video.ReencodeOversized = true
+	changed.Log.Level = "debug"
+	changed.Log.Format = "json"
+	expected := validConfig()
+	expected.Telegram.AdminUsers = []int64{1}
+	expected.Video.ReencodeOversized = true
+	expected.Log.Level = "debug"
+	result := current.WithReloadable(changed)
+	assert.Equal(t, expected, result)
+	// The configs are not changed
+	assert.Equal(t, validConfig(), current)
+	assert.Equal(t, "5678:token", changed.Telegram.Token)
+	assert.Empty(t, current.ChangedStructuralSettings(result))
+}
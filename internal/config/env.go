@@ -0,0 +1,151 @@
+package config
+
+import (
+	"github.com/go-faster/errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// environmentVariable is an environment variable which overrides a setting
+type environmentVariable struct {
+	name  string
+	value any
+}
+
+// environmentVariables returns the environment variables which override the settings of c
+func (c *Config) environmentVariables() []environmentVariable {
+	return []environmentVariable{
+		{"BOT_TOKEN", &c.Telegram.Token},
+		{"ALLOWED_USERS", &c.Telegram.AllowedUsers},
+		{"ADMIN_USERS", &c.Telegram.AdminUsers},
+		{"ADMIN_CHATS", &c.Telegram.AdminChats},
+		{"DROP_PENDING_UPDATES", &c.Telegram.DropPendingUpdates},
+		{"MAX_UPDATE_AGE", &c.Telegram.MaxUpdateAge},
+		{"DISABLE_LINK_IN_CAPTION", &c.Telegram.DisableLinkInCaption},
+		{"AUTO_QUALITY", &c.Telegram.AutoQuality},
+		{"TELEGRAM_API_URL", &c.Telegram.APIURL},
+		{"TELEGRAM_LOCAL_FILES", &c.Telegram.LocalFiles},
+		{"CLIENT_ID", &c.Reddit.ClientID},
+		{"CLIENT_SECRET", &c.Reddit.ClientSecret},
+		{"IMGUR_PROXY", &c.Reddit.ImgurProxy},
+		{"DENY_NSFW", &c.Reddit.DenyNSFW},
+		{"REDIS_ADDRESS", &c.Redis.Address},
+		{"REDIS_PORT", &c.Redis.Port},
+		{"REDIS_PASSWORD", &c.Redis.Password},
+		{"REDIS_TTL", &c.Redis.TTL},
+		{"WEBHOOK_URL", &c.Webhook.URL},
+		{"WEBHOOK_LISTEN", &c.Webhook.Listen},
+		{"WEBHOOK_SECRET", &c.Webhook.Secret},
+		{"WEBHOOK_TLS_CERT", &c.Webhook.TLSCert},
+		{"WEBHOOK_TLS_KEY", &c.Webhook.TLSKey},
+		{"WORKERS", &c.Queue.Workers},
+		{"MAX_JOBS_PER_USER", &c.Queue.MaxJobsPerUser},
+		{"RATE_LIMIT_REQUESTS_PER_MINUTE", &c.RateLimit.RequestsPerMinute},
+		{"RATE_LIMIT_CONCURRENT_JOBS", &c.RateLimit.ConcurrentJobs},
+		{"RATE_LIMIT_MB_PER_DAY", &c.RateLimit.MBPerDay},
+		{"RATE_LIMIT_OVERRIDES", &c.RateLimit.Overrides},
+		{"METRICS_LISTEN", &c.Monitoring.Listen},
+		{"USAGE_FILE", &c.Usage.File},
+		{"REENCODE_OVERSIZED_VIDEOS", &c.Video.ReencodeOversized},
+		{"REENCODE_WORKERS", &c.Video.ReencodeWorkers},
+		{"REENCODE_TIMEOUT", &c.Video.ReencodeTimeout},
+		{"FILES_DIR", &c.Files.Directory},
+		{"FILES_PUBLIC_URL", &c.Files.PublicURL},
+		{"FILES_LISTEN", &c.Files.Listen},
+		{"FILES_SECRET", &c.Files.Secret},
+		{"FILES_TTL", &c.Files.TTL},
+		{"FILES_QUOTA_MB", &c.Files.QuotaMB},
+		{"FILES_MAX_FILE_MB", &c.Files.MaxFileMB},
+		{"LOG_LEVEL", &c.Log.Level},
+		{"LOG_FORMAT", &c.Log.Format},
+		{"SHUTDOWN_TIMEOUT", &c.ShutdownTimeout},
+	}
+}
+
+// applyEnvironment overrides the settings with the environment variables which are set.
+// Empty variables are treated as not set.
+func (c *Config) applyEnvironment() error {
+	for _, variable := range c.environmentVariables() {
+		value := os.Getenv(variable.name)
+		if value == "" {
+			continue
+		}
+		if err := parseEnvironmentVariable(value, variable.value); err != nil {
+			return errors.Wrapf(err, "invalid %s", variable.name)
+		}
+	}
+	return nil
+}
+
+// parseEnvironmentVariable parses value into the setting which target points to
+func parseEnvironmentVariable(value string, target any) error {
+	var err error
+	switch target := target.(type) {
+	case *string:
+		*target = value
+	case *bool:
+		*target, err = strconv.ParseBool(value)
+	case *int:
+		*target, err = strconv.Atoi(value)
+	case *int64:
+		*target, err = strconv.ParseInt(value, 10, 64)
+	case *time.Duration:
+		*target, err = time.ParseDuration(value)
+	case *[]int64:
+		*target, err = parseUserIDs(value)
+	case *[]RateLimitOverride:
+		*target, err = parseRateLimitOverrides(value)
+	default:
+		panic("unsupported setting type")
+	}
+	return err
+}
+
+// parseUserIDs parses a comma separated list of user IDs
+func parseUserIDs(users string) ([]int64, error) {
+	var ids []int64
+	for _, idString := range strings.Split(users, ",") {
+		idString = strings.TrimSpace(idString)
+		if idString == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(idString, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid user ID %q", idString)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseRateLimitOverrides parses a comma separated list of
+// user:requests_per_minute:concurrent_jobs:mb_per_day
+func parseRateLimitOverrides(overrides string) ([]RateLimitOverride, error) {
+	var result []RateLimitOverride
+	for _, override := range strings.Split(overrides, ",") {
+		if override == "" {
+			continue
+		}
+		fields := strings.Split(override, ":")
+		if len(fields) != 4 {
+			return nil, errors.Errorf("invalid override %q: expected user:requests_per_minute:concurrent_jobs:mb_per_day", override)
+		}
+		var values [4]int64
+		for i, field := range fields {
+			var err error
+			values[i], err = strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				return nil, errors.Errorf("invalid override %q: %q is not a number", override, field)
+			}
+		}
+		result = append(result, RateLimitOverride{
+			User:              values[0],
+			RequestsPerMinute: int(values[1]),
+			ConcurrentJobs:    int(values[2]),
+			MBPerDay:          values[3],
+		})
+	}
+	return result, nil
+}
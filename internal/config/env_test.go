@@ -0,0 +1,121 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyEnvironment(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Env      map[string]string
+		Expected func(c *Config)
+		HasError bool
+	}{
+		{
+			Name: "values",
+			Env: map[string]string{
+				"BOT_TOKEN":            "1234:token",
+				"ALLOWED_USERS":        "1, 2,,3",
+				"DROP_PENDING_UPDATES": "true",
+				"MAX_UPDATE_AGE":       "30m",
+				"WORKERS":              "8",
+				"FILES_QUOTA_MB":       "500",
+				"RATE_LIMIT_OVERRIDES": "1:2:3:4",
+			},
+			Expected: func(c *Config) {
+				c.Telegram.Token = "1234:token"
+				c.Telegram.AllowedUsers = []int64{1, 2, 3}
+				c.Telegram.DropPendingUpdates = true
+				c.Telegram.MaxUpdateAge = 30 * time.Minute
+				c.Queue.Workers = 8
+				c.Files.QuotaMB = 500
+				c.RateLimit.Overrides = []RateLimitOverride{{User: 1, RequestsPerMinute: 2, ConcurrentJobs: 3, MBPerDay: 4}}
+			},
+		},
+		{
+			Name:     "empty_is_not_set",
+			Env:      map[string]string{"WORKERS": ""},
+			Expected: func(c *Config) {},
+		},
+		{Name: "invalid_bool", Env: map[string]string{"AUTO_QUALITY": "maybe"}, HasError: true},
+		{Name: "invalid_int", Env: map[string]string{"WORKERS": "four"}, HasError: true},
+		{Name: "invalid_duration", Env: map[string]string{"REDIS_TTL": "5"}, HasError: true},
+		{Name: "invalid_user_ids", Env: map[string]string{"ADMIN_USERS": "1,abc"}, HasError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			for name, value := range test.Env {
+				t.Setenv(name, value)
+			}
+			config := Default()
+			err := config.applyEnvironment()
+			if test.HasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			expected := Default()
+			test.Expected(expected)
+			assert.Equal(t, expected, config)
+		})
+	}
+}
+
+// TestEnvironmentVariables makes sure that each environment variable is unique and points to its own setting
+func TestEnvironmentVariables(t *testing.T) {
+	config := Default()
+	names := make(map[string]bool)
+	targets := make(map[any]string)
+	for _, variable := range config.environmentVariables() {
+		assert.False(t, names[variable.name], "%s is repeated", variable.name)
+		names[variable.name] = true
+		assert.Equal(t, reflect.Pointer, reflect.TypeOf(variable.value).Kind(), variable.name)
+		if other, exists := targets[variable.value]; exists {
+			t.Errorf("%s and %s set the same setting", variable.name, other)
+		}
+		targets[variable.value] = variable.name
+		// Panics if the type of the setting is not supported
+		assert.NotPanics(t, func() { _ = parseEnvironmentVariable("", variable.value) }, variable.name)
+	}
+}
+
+func TestParseRateLimitOverrides(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Data     string
+		Expected []RateLimitOverride
+		HasError bool
+	}{
+		{Name: "empty", Data: ""},
+		{
+			Name:     "single",
+			Data:     "1234:0:0:0",
+			Expected: []RateLimitOverride{{User: 1234}},
+		},
+		{
+			Name: "multiple",
+			Data: "1234:0:0:0,5678:30:10:10000,",
+			Expected: []RateLimitOverride{
+				{User: 1234},
+				{User: 5678, RequestsPerMinute: 30, ConcurrentJobs: 10, MBPerDay: 10000},
+			},
+		},
+		{Name: "missing_field", Data: "1234:1:2", HasError: true},
+		{Name: "extra_field", Data: "1234:1:2:3:4", HasError: true},
+		{Name: "not_a_number", Data: "1234:x:2:3", HasError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			overrides, err := parseRateLimitOverrides(test.Data)
+			if test.HasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.Expected, overrides)
+		})
+	}
+}
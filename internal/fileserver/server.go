@@ -0,0 +1,291 @@
+// Package fileserver keeps the files which are too large for Telegram in a directory and
+// serves them over HTTP with signed links which expire after a while.
+package fileserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/go-faster/errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// janitorInterval is the interval which the expired files are deleted in
+const janitorInterval = time.Minute
+
+// defaultPath is the path which the files are served on if the public URL does not have any path
+const defaultPath = "files"
+
+// QuotaExceededErr is returned when there is not enough space in the quota for a new file
+var QuotaExceededErr = errors.New("the quota of the file server is full")
+
+// Options configures a Server
+type Options struct {
+	// The directory which the files are kept in. It is created if it does not exist.
+	Directory string
+	// The URL which the directory is served on. For example, https://bot.example.com/files
+	PublicURL string
+	// The key which the links are signed with
+	Secret []byte
+	// The time which the files are kept and their links are valid
+	TTL time.Duration
+	// The maximum total size of the files in bytes
+	Quota int64
+	// The maximum size of a single file in bytes. Zero means the quota.
+	MaxFileSize int64
+}
+
+// storedFile is a file in the directory of the server
+type storedFile struct {
+	size    int64
+	expires time.Time
+}
+
+// Server keeps the files and serves them. Use it as the http.Handler of Pattern.
+type Server struct {
+	options   Options
+	publicURL *url.URL
+	// The files which are in the directory, keyed by their ID
+	files map[string]storedFile
+	// The total size of files
+	usedSpace int64
+	lock      sync.Mutex
+	// Closed when the server is closed
+	done    chan struct{}
+	janitor sync.WaitGroup
+}
+
+// New creates a new Server. The files which were left from the previous runs are kept until
+// their TTL is over.
+func New(options Options) (*Server, error) {
+	publicURL, err := url.Parse(strings.TrimSuffix(options.PublicURL, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse the public URL")
+	}
+	if strings.Trim(publicURL.Path, "/") == "" {
+		publicURL = publicURL.JoinPath(defaultPath)
+	}
+	if err = os.MkdirAll(options.Directory, 0o755); err != nil {
+		return nil, errors.Wrap(err, "cannot create the directory")
+	}
+	server := &Server{
+		options:   options,
+		publicURL: publicURL,
+		files:     make(map[string]storedFile),
+		done:      make(chan struct{}),
+	}
+	// Load the existing files. Their modification time is the time which they were added.
+	entries, err := os.ReadDir(options.Directory)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read the directory")
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		server.files[entry.Name()] = storedFile{
+			size:    info.Size(),
+			expires: info.ModTime().Add(options.TTL),
+		}
+		server.usedSpace += info.Size()
+	}
+	server.deleteExpired(time.Now())
+	server.janitor.Add(1)
+	go server.janitorLoop()
+	return server, nil
+}
+
+// Pattern returns the pattern which the server must be registered on in a http.ServeMux
+func (s *Server) Pattern() string {
+	return "GET " + path.Clean("/"+s.publicURL.Path) + "/{id}/{name}"
+}
+
+// MaxFileSize returns the size of the largest file which the server accepts
+func (s *Server) MaxFileSize() int64 {
+	if s.options.MaxFileSize <= 0 {
+		return s.options.Quota
+	}
+	return min(s.options.MaxFileSize, s.options.Quota)
+}
+
+// Add moves a file into the server and returns its link. name is the file name which is
+// shown to the user when they download it. If the quota is full, QuotaExceededErr is returned
+// and the file is not moved.
+func (s *Server) Add(filename, name string) (link string, expires time.Time, err error) {
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "cannot get the file size")
+	}
+	id, err := newFileID(filepath.Ext(name))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if stat.Size() > s.MaxFileSize() {
+		return "", time.Time{}, QuotaExceededErr
+	}
+	// Reserve the space
+	s.lock.Lock()
+	if s.usedSpace+stat.Size() > s.options.Quota {
+		s.lock.Unlock()
+		return "", time.Time{}, QuotaExceededErr
+	}
+	s.usedSpace += stat.Size()
+	s.lock.Unlock()
+	now := time.Now()
+	expires = now.Add(s.options.TTL)
+	destination := filepath.Join(s.options.Directory, id)
+	err = moveFile(filename, destination)
+	if err == nil {
+		// The janitor of the next runs uses the modification time
+		err = os.Chtimes(destination, now, now)
+	}
+	s.lock.Lock()
+	if err != nil {
+		s.usedSpace -= stat.Size()
+		s.lock.Unlock()
+		_ = os.Remove(destination)
+		return "", time.Time{}, errors.Wrap(err, "cannot move the file to the file server")
+	}
+	s.files[id] = storedFile{size: stat.Size(), expires: expires}
+	s.lock.Unlock()
+	return s.link(id, name, expires), expires, nil
+}
+
+// link creates the signed link of a file
+func (s *Server) link(id, name string, expires time.Time) string {
+	expiresText := strconv.FormatInt(expires.Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expiresText)
+	query.Set("signature", s.sign(id, name, expiresText))
+	return s.publicURL.JoinPath(id, name).String() + "?" + query.Encode()
+}
+
+// sign returns the signature of the link of a file
+func (s *Server) sign(id, name, expires string) string {
+	mac := hmac.New(sha256.New, s.options.Secret)
+	mac.Write([]byte(id + "/" + name + "/" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, name := r.PathValue("id"), r.PathValue("name")
+	expiresText := r.URL.Query().Get("expires")
+	signature := r.URL.Query().Get("signature")
+	if !hmac.Equal([]byte(signature), []byte(s.sign(id, name, expiresText))) {
+		http.Error(w, "invalid link", http.StatusForbidden)
+		return
+	}
+	expires, err := strconv.ParseInt(expiresText, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		http.Error(w, "this link has expired", http.StatusGone)
+		return
+	}
+	s.lock.Lock()
+	_, exists := s.files[id]
+	s.lock.Unlock()
+	if !exists {
+		http.Error(w, "this link has expired", http.StatusGone)
+		return
+	}
+	file, err := os.Open(filepath.Join(s.options.Directory, id))
+	if err != nil {
+		// Deleted by the janitor
+		http.Error(w, "this link has expired", http.StatusGone)
+		return
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		http.Error(w, "cannot read the file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	http.ServeContent(w, r, name, stat.ModTime(), file)
+}
+
+// Close stops the janitor. The files are kept for the next runs.
+func (s *Server) Close() {
+	close(s.done)
+	s.janitor.Wait()
+}
+
+// janitorLoop deletes the expired files periodically until the server is closed
+func (s *Server) janitorLoop() {
+	defer s.janitor.Done()
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.deleteExpired(now)
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// deleteExpired deletes the files whose TTL is over
+func (s *Server) deleteExpired(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id, file := range s.files {
+		if now.Before(file.expires) {
+			continue
+		}
+		err := os.Remove(filepath.Join(s.options.Directory, id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("Cannot delete an expired file", "file", id, "error", err)
+			continue
+		}
+		delete(s.files, id)
+		s.usedSpace -= file.size
+	}
+}
+
+// newFileID creates a random ID for a new file
+func newFileID(extension string) (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", errors.Wrap(err, "cannot create the file ID")
+	}
+	return hex.EncodeToString(id[:]) + extension, nil
+}
+
+// moveFile moves a file. If it cannot be renamed, for example because the destination is on
+// another file system, it is copied and the source is deleted.
+func moveFile(source, destination string) error {
+	if os.Rename(source, destination) == nil {
+		return nil
+	}
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+	destinationFile, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(destinationFile, sourceFile); err != nil {
+		_ = destinationFile.Close()
+		return err
+	}
+	if err = destinationFile.Close(); err != nil {
+		return err
+	}
+	return os.Remove(source)
+}
@@ -0,0 +1,198 @@
+package fileserver
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer creates a server in a temporary directory and a file which can be added to it
+func newTestServer(t *testing.T, options Options) (*Server, string) {
+	options.Directory = filepath.Join(t.TempDir(), "files")
+	options.PublicURL = "https://bot.example.com/files"
+	options.Secret = []byte("secret")
+	if options.TTL == 0 {
+		options.TTL = time.Hour
+	}
+	if options.Quota == 0 {
+		options.Quota = 1000
+	}
+	server, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	source := filepath.Join(t.TempDir(), "video.mp4")
+	if err = os.WriteFile(source, []byte("video data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return server, source
+}
+
+// get requests a link from the server
+func get(server *Server, link string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.Handle(server.Pattern(), server)
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, link, nil))
+	return recorder
+}
+
+func TestServeHTTP(t *testing.T) {
+	server, source := newTestServer(t, Options{})
+	link, expires, err := server.Add(source, "abc.mp4")
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expires, time.Minute)
+	u, err := url.Parse(link)
+	assert.NoError(t, err)
+	id := strings.Split(strings.TrimPrefix(u.Path, "/files/"), "/")[0]
+	tamper := func(f func(u *url.URL)) string {
+		tampered := *u
+		f(&tampered)
+		return tampered.String()
+	}
+	tests := []struct {
+		Name           string
+		Link           string
+		ExpectedStatus int
+	}{
+		{Name: "valid", Link: link, ExpectedStatus: http.StatusOK},
+		{
+			Name: "tampered_id",
+			Link: tamper(func(u *url.URL) {
+				u.Path = "/files/" + strings.Repeat("0", 32) + ".mp4/abc.mp4"
+			}),
+			ExpectedStatus: http.StatusForbidden,
+		},
+		{
+			Name:           "tampered_name",
+			Link:           tamper(func(u *url.URL) { u.Path = strings.Replace(u.Path, "abc.mp4", "abc.exe", 1) }),
+			ExpectedStatus: http.StatusForbidden,
+		},
+		{
+			Name: "tampered_expires",
+			Link: tamper(func(u *url.URL) {
+				query := u.Query()
+				query.Set("expires", "9999999999")
+				u.RawQuery = query.Encode()
+			}),
+			ExpectedStatus: http.StatusForbidden,
+		},
+		{
+			Name:           "no_signature",
+			Link:           tamper(func(u *url.URL) { u.RawQuery = "" }),
+			ExpectedStatus: http.StatusForbidden,
+		},
+		{Name: "expired", Link: server.link(id, "abc.mp4", time.Now().Add(-time.Minute)), ExpectedStatus: http.StatusGone},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			response := get(server, test.Link)
+			assert.Equal(t, test.ExpectedStatus, response.Code)
+			if test.ExpectedStatus == http.StatusOK {
+				body, _ := io.ReadAll(response.Body)
+				assert.Equal(t, "video data", string(body))
+				assert.Equal(t, `attachment; filename=abc.mp4`, response.Header().Get("Content-Disposition"))
+			}
+		})
+	}
+	// The source is moved into the server
+	_, err = os.Stat(source)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestServeHTTPDeletedByJanitor(t *testing.T) {
+	server, source := newTestServer(t, Options{})
+	link, _, err := server.Add(source, "abc.mp4")
+	assert.NoError(t, err)
+	// The link is still valid, but the janitor runs after the TTL of the file
+	server.deleteExpired(time.Now().Add(2 * time.Hour))
+	assert.Equal(t, http.StatusGone, get(server, link).Code)
+	entries, _ := os.ReadDir(server.options.Directory)
+	assert.Empty(t, entries)
+	assert.Zero(t, server.usedSpace)
+}
+
+func TestAdd(t *testing.T) {
+	t.Run("quota_exceeded", func(t *testing.T) {
+		server, source := newTestServer(t, Options{Quota: 5})
+		_, _, err := server.Add(source, "abc.mp4")
+		assert.ErrorIs(t, err, QuotaExceededErr)
+		assert.Zero(t, server.usedSpace)
+		// The file is not moved
+		_, err = os.Stat(source)
+		assert.NoError(t, err)
+	})
+	t.Run("max_file_size", func(t *testing.T) {
+		server, source := newTestServer(t, Options{MaxFileSize: 5})
+		assert.Equal(t, int64(5), server.MaxFileSize())
+		_, _, err := server.Add(source, "abc.mp4")
+		assert.ErrorIs(t, err, QuotaExceededErr)
+		assert.Zero(t, server.usedSpace)
+	})
+	t.Run("full_after_other_files", func(t *testing.T) {
+		server, source := newTestServer(t, Options{Quota: 15})
+		_, _, err := server.Add(source, "abc.mp4")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(10), server.usedSpace)
+		assert.NoError(t, os.WriteFile(source, []byte("video data"), 0o644))
+		_, _, err = server.Add(source, "abc.mp4")
+		assert.ErrorIs(t, err, QuotaExceededErr)
+		assert.Equal(t, int64(10), server.usedSpace)
+	})
+	t.Run("failed_move", func(t *testing.T) {
+		server, source := newTestServer(t, Options{})
+		// Nothing can be moved into a missing directory
+		assert.NoError(t, os.RemoveAll(server.options.Directory))
+		_, _, err := server.Add(source, "abc.mp4")
+		assert.Error(t, err)
+		assert.Zero(t, server.usedSpace)
+		assert.Empty(t, server.files)
+		_, err = os.Stat(source)
+		assert.NoError(t, err)
+	})
+}
+
+func TestNewLoadsExistingFiles(t *testing.T) {
+	directory := t.TempDir()
+	now := time.Now()
+	files := []struct {
+		Name    string
+		Data    string
+		ModTime time.Time
+	}{
+		{Name: "recent.mp4", Data: "recent", ModTime: now.Add(-10 * time.Minute)},
+		{Name: "expired.mp4", Data: "expired file", ModTime: now.Add(-2 * time.Hour)},
+	}
+	for _, file := range files {
+		name := filepath.Join(directory, file.Name)
+		assert.NoError(t, os.WriteFile(name, []byte(file.Data), 0o644))
+		assert.NoError(t, os.Chtimes(name, file.ModTime, file.ModTime))
+	}
+	server, err := New(Options{
+		Directory: directory,
+		PublicURL: "https://bot.example.com",
+		Secret:    []byte("secret"),
+		TTL:       time.Hour,
+		Quota:     1000,
+	})
+	assert.NoError(t, err)
+	defer server.Close()
+	assert.Equal(t, "GET /files/{id}/{name}", server.Pattern())
+	assert.Equal(t, int64(len("recent")), server.usedSpace)
+	assert.Contains(t, server.files, "recent.mp4")
+	assert.NotContains(t, server.files, "expired.mp4")
+	_, err = os.Stat(filepath.Join(directory, "expired.mp4"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	// The loaded files expire by their modification time
+	assert.WithinDuration(t, now.Add(50*time.Minute), server.files["recent.mp4"].expires, time.Second)
+	link := server.link("recent.mp4", "recent.mp4", server.files["recent.mp4"].expires)
+	assert.Equal(t, http.StatusOK, get(server, link).Code)
+}
@@ -0,0 +1,265 @@
+package usage
+
+import (
+	"encoding/json"
+	"github.com/go-faster/errors"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+var _ Store = &FileStore{}
+
+// fileStoreSaveInterval is the interval which the changes of a FileStore are written to its file
+const fileStoreSaveInterval = 30 * time.Second
+
+// fileStoreData is the content of the file of a FileStore
+type fileStoreData struct {
+	Users map[int64]*User
+	// The recent requests of each user, oldest first
+	Requests map[int64][]Request
+	// The statistics of each day, keyed by dayKey
+	Days map[string]*DailyStats
+}
+
+// FileStore satisfies Store by keeping everything in memory and saving it to a JSON file
+// periodically. It is meant for bots which run as a single instance.
+type FileStore struct {
+	// The path of the file. If empty, nothing is saved.
+	path string
+	data fileStoreData
+	// True if data has changed since the last save
+	dirty bool
+	lock  sync.Mutex
+	// Closed when the store is closed
+	done  chan struct{}
+	saver sync.WaitGroup
+}
+
+// NewFileStore loads a FileStore from path. If the file does not exist, an empty store is created.
+// If path is empty, the store is kept in memory and is lost on restart.
+func NewFileStore(path string) (*FileStore, error) {
+	store := &FileStore{
+		path: path,
+		data: fileStoreData{
+			Users:    make(map[int64]*User),
+			Requests: make(map[int64][]Request),
+			Days:     make(map[string]*DailyStats),
+		},
+		done: make(chan struct{}),
+	}
+	if path == "" {
+		return store, nil
+	}
+	content, err := os.ReadFile(path)
+	if err == nil {
+		if err = json.Unmarshal(content, &store.data); err != nil {
+			return nil, errors.Wrap(err, "cannot parse the usage file")
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Wrap(err, "cannot read the usage file")
+	}
+	store.saver.Add(1)
+	go store.saveLoop()
+	return store, nil
+}
+
+func (s *FileStore) TouchUser(user User) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if existing, exists := s.data.Users[user.ID]; exists {
+		user.FirstSeen = existing.FirstSeen
+		user.OptedOut = existing.OptedOut
+		user.QualityMode = existing.QualityMode
+	} else if user.FirstSeen.IsZero() {
+		user.FirstSeen = user.LastSeen
+	}
+	user.Blocked = false
+	s.data.Users[user.ID] = &user
+	s.dirty = true
+	return nil
+}
+
+func (s *FileStore) GetUser(id int64) (User, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if user, exists := s.data.Users[id]; exists {
+		return *user, nil
+	}
+	return User{}, NotFoundErr
+}
+
+func (s *FileStore) Users() ([]User, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	users := make([]User, 0, len(s.data.Users))
+	for _, user := range s.data.Users {
+		users = append(users, *user)
+	}
+	return users, nil
+}
+
+func (s *FileStore) SetOptedOut(id int64, optedOut bool) error {
+	return s.updateUser(id, func(user *User) { user.OptedOut = optedOut })
+}
+
+func (s *FileStore) SetQualityMode(id int64, mode QualityMode) error {
+	return s.updateUser(id, func(user *User) { user.QualityMode = mode })
+}
+
+func (s *FileStore) SetBlocked(id int64, blocked bool) error {
+	return s.updateUser(id, func(user *User) { user.Blocked = blocked })
+}
+
+// updateUser changes a user with update. Returns NotFoundErr if the user does not exist.
+func (s *FileStore) updateUser(id int64, update func(user *User)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	user, exists := s.data.Users[id]
+	if !exists {
+		return NotFoundErr
+	}
+	update(user)
+	s.dirty = true
+	return nil
+}
+
+func (s *FileStore) AddRequest(request Request) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	requests := append(s.data.Requests[request.UserID], request)
+	if len(requests) > recentRequestsPerUser {
+		requests = slices.Clone(requests[len(requests)-recentRequestsPerUser:])
+	}
+	s.data.Requests[request.UserID] = requests
+	key := dayKey(request.Time)
+	day, exists := s.data.Days[key]
+	if !exists {
+		day = &DailyStats{
+			FailuresByCategory: make(map[string]int64),
+			Subreddits:         make(map[string]int64),
+		}
+		s.data.Days[key] = day
+		s.cleanUp(request.Time)
+	}
+	day.Requests++
+	if request.Error != "" {
+		day.Failures++
+		day.FailuresByCategory[request.Error]++
+	}
+	if request.Subreddit != "" {
+		day.Subreddits[request.Subreddit]++
+	}
+	s.dirty = true
+	return nil
+}
+
+// cleanUp deletes the old statistics and requests. The lock must be held.
+func (s *FileStore) cleanUp(now time.Time) {
+	for key := range s.data.Days {
+		if day, err := time.Parse(time.DateOnly, key); err == nil && now.Sub(day) > dailyStatsTTL {
+			delete(s.data.Days, key)
+		}
+	}
+	for userID, requests := range s.data.Requests {
+		if len(requests) == 0 || now.Sub(requests[len(requests)-1].Time) > recentRequestsTTL {
+			delete(s.data.Requests, userID)
+		}
+	}
+}
+
+func (s *FileStore) RecentRequests(userID int64) ([]Request, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	requests := slices.Clone(s.data.Requests[userID])
+	slices.Reverse(requests)
+	return requests, nil
+}
+
+func (s *FileStore) DailyStats(day time.Time) (DailyStats, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats, exists := s.data.Days[dayKey(day)]
+	if !exists {
+		return DailyStats{}, nil
+	}
+	return DailyStats{
+		Requests:           stats.Requests,
+		Failures:           stats.Failures,
+		FailuresByCategory: maps.Clone(stats.FailuresByCategory),
+		Subreddits:         maps.Clone(stats.Subreddits),
+	}, nil
+}
+
+func (s *FileStore) Close() error {
+	if s.path == "" {
+		return nil
+	}
+	close(s.done)
+	s.saver.Wait()
+	return s.save()
+}
+
+// saveLoop saves the store periodically until it is closed
+func (s *FileStore) saveLoop() {
+	defer s.saver.Done()
+	ticker := time.NewTicker(fileStoreSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.save(); err != nil {
+				slog.Error("Cannot save the usage file", "error", err)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// save writes the store to its file if it has changed. The file is replaced atomically so
+// that a crash does not leave a broken file behind.
+func (s *FileStore) save() error {
+	s.lock.Lock()
+	if !s.dirty {
+		s.lock.Unlock()
+		return nil
+	}
+	content, err := json.Marshal(s.data)
+	s.dirty = false
+	s.lock.Unlock()
+	if err == nil {
+		err = s.writeFile(content)
+	}
+	if err != nil {
+		// Try again on the next save
+		s.lock.Lock()
+		s.dirty = true
+		s.lock.Unlock()
+	}
+	return err
+}
+
+// writeFile atomically replaces the file of the store with content
+func (s *FileStore) writeFile(content []byte) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "cannot create the temporary usage file")
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err = tempFile.Write(content); err != nil {
+		_ = tempFile.Close()
+		return errors.Wrap(err, "cannot write the usage file")
+	}
+	if err = tempFile.Close(); err != nil {
+		return errors.Wrap(err, "cannot write the usage file")
+	}
+	if err = os.Rename(tempFile.Name(), s.path); err != nil {
+		return errors.Wrap(err, "cannot replace the usage file")
+	}
+	return nil
+}
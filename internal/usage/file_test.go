@@ -0,0 +1,101 @@
+package usage
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store {
+		store, err := NewFileStore(filepath.Join(t.TempDir(), "usage.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { assert.NoError(t, store.Close()) })
+		return store
+	})
+}
+
+func TestFileStoreSaveAndLoad(t *testing.T) {
+	user := User{ID: testUserID, Username: "user", FirstSeen: testDay, LastSeen: testDay, OptedOut: true}
+	request := Request{UserID: testUserID, Time: testDay, PostURL: "https://reddit.com/1", Subreddit: "pics"}
+	tests := []struct {
+		Name string
+		// The content of the file before the store is created. The file does not exist if nil.
+		File []byte
+		// Changes the store before it is closed
+		Change func(t *testing.T, store *FileStore)
+		// Checks the store which is loaded after closing the first one
+		Check    func(t *testing.T, store *FileStore)
+		HasError bool
+	}{
+		{
+			Name:   "missing_file",
+			Change: func(t *testing.T, store *FileStore) {},
+			Check: func(t *testing.T, store *FileStore) {
+				users, err := store.Users()
+				assert.NoError(t, err)
+				assert.Empty(t, users)
+			},
+		},
+		{
+			Name: "saved_changes",
+			Change: func(t *testing.T, store *FileStore) {
+				assert.NoError(t, store.TouchUser(user))
+				assert.NoError(t, store.SetOptedOut(user.ID, true))
+				assert.NoError(t, store.AddRequest(request))
+			},
+			Check: func(t *testing.T, store *FileStore) {
+				loadedUser, err := store.GetUser(user.ID)
+				assert.NoError(t, err)
+				assert.Equal(t, user, loadedUser)
+				requests, err := store.RecentRequests(user.ID)
+				assert.NoError(t, err)
+				assert.Equal(t, []Request{request}, requests)
+				stats, err := store.DailyStats(testDay)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(1), stats.Requests)
+				assert.Equal(t, map[string]int64{"pics": 1}, stats.Subreddits)
+			},
+		},
+		{Name: "invalid_file", File: []byte("{"), HasError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "usage.json")
+			if test.File != nil {
+				if err := os.WriteFile(path, test.File, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store, err := NewFileStore(path)
+			if test.HasError {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			test.Change(t, store)
+			assert.NoError(t, store.Close())
+			store, err = NewFileStore(path)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer store.Close()
+			test.Check(t, store)
+		})
+	}
+}
+
+func TestFileStoreWithoutPath(t *testing.T) {
+	store, err := NewFileStore("")
+	assert.NoError(t, err)
+	assert.NoError(t, store.TouchUser(User{ID: testUserID, LastSeen: testDay}))
+	assert.NoError(t, store.Close())
+	// Nothing is written to the disk, but the store works in memory
+	_, err = store.GetUser(testUserID)
+	assert.NoError(t, err)
+}
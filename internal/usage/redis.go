@@ -0,0 +1,257 @@
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-faster/errors"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ Store = RedisStore{}
+
+// Keys of the RedisStore
+const (
+	// The JSON encoded user with the ID after the prefix. Each user has its own key, so the
+	// transactions which change a user only conflict with the changes of the same user.
+	redisUserPrefix = "usage:user:"
+	// A set of the IDs of all the users
+	redisUserIDsKey = "usage:user_ids"
+	// A list of the JSON encoded recent requests of a user, newest first
+	redisRequestsPrefix = "usage:requests:"
+	// A hash of the statistics of a day. See the field prefixes below.
+	redisDayPrefix = "usage:day:"
+)
+
+// redisUserUpdateAttempts is the number of times which a change of a user is tried if the
+// user is changed by another replica at the same time
+const redisUserUpdateAttempts = 10
+
+// Fields of the hash of the statistics of a day
+const (
+	redisDayRequestsField        = "requests"
+	redisDayFailuresField        = "failures"
+	redisDayFailureCategoryField = "failure:"
+	redisDaySubredditField       = "subreddit:"
+)
+
+// RedisStore satisfies Store backed by a Redis server. Unlike FileStore, it can be shared
+// between the replicas of the bot.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore will create a new Redis store
+func NewRedisStore(address, password string) (RedisStore, error) {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     address,
+		Password: password,
+	})
+	return RedisStore{client: rdb}, rdb.Ping(context.Background()).Err()
+}
+
+func (r RedisStore) TouchUser(user User) error {
+	return r.updateUser(user.ID, func(existing User, exists bool) (User, error) {
+		if exists {
+			user.FirstSeen = existing.FirstSeen
+			user.OptedOut = existing.OptedOut
+			user.QualityMode = existing.QualityMode
+		} else if user.FirstSeen.IsZero() {
+			user.FirstSeen = user.LastSeen
+		}
+		user.Blocked = false
+		return user, nil
+	})
+}
+
+func (r RedisStore) GetUser(id int64) (User, error) {
+	return parseRedisUser(r.client.Get(context.Background(), redisUserKey(id)).Result())
+}
+
+// redisUserKey returns the key of a user
+func redisUserKey(id int64) string {
+	return redisUserPrefix + strconv.FormatInt(id, 10)
+}
+
+// parseRedisUser parses the result of getting a user from its key
+func parseRedisUser(value string, err error) (User, error) {
+	if errors.Is(err, redis.Nil) {
+		return User{}, NotFoundErr
+	} else if err != nil {
+		return User{}, errors.Wrap(err, "Unable to get the user from Redis")
+	}
+	var user User
+	if err = json.Unmarshal([]byte(value), &user); err != nil {
+		return User{}, errors.Wrap(err, "Unable to parse JSON")
+	}
+	return user, nil
+}
+
+func (r RedisStore) Users() ([]User, error) {
+	ctx := context.Background()
+	ids, err := r.client.SMembers(ctx, redisUserIDsKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get the users from Redis")
+	}
+	if len(ids) == 0 {
+		return []User{}, nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = redisUserPrefix + id
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get the users from Redis")
+	}
+	users := make([]User, 0, len(values))
+	for _, value := range values {
+		// The user might be removed after getting the IDs
+		encoded, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var user User
+		if err = json.Unmarshal([]byte(encoded), &user); err != nil {
+			return nil, errors.Wrap(err, "Unable to parse JSON")
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (r RedisStore) SetOptedOut(id int64, optedOut bool) error {
+	return r.updateExistingUser(id, func(user *User) { user.OptedOut = optedOut })
+}
+
+func (r RedisStore) SetQualityMode(id int64, mode QualityMode) error {
+	return r.updateExistingUser(id, func(user *User) { user.QualityMode = mode })
+}
+
+func (r RedisStore) SetBlocked(id int64, blocked bool) error {
+	return r.updateExistingUser(id, func(user *User) { user.Blocked = blocked })
+}
+
+// updateExistingUser changes a user with update. Returns NotFoundErr if the user does not exist.
+func (r RedisStore) updateExistingUser(id int64, update func(user *User)) error {
+	return r.updateUser(id, func(user User, exists bool) (User, error) {
+		if !exists {
+			return User{}, NotFoundErr
+		}
+		update(&user)
+		return user, nil
+	})
+}
+
+// updateUser replaces a user with the result of update in a transaction which watches the key of
+// the user, so the changes of other replicas which happen at the same time are not overwritten.
+// update is called again if the user changes before the transaction is done. exists is false if the user
+// is not stored yet.
+func (r RedisStore) updateUser(id int64, update func(user User, exists bool) (User, error)) error {
+	ctx := context.Background()
+	key := redisUserKey(id)
+	for range redisUserUpdateAttempts {
+		err := r.client.Watch(ctx, func(tx *redis.Tx) error {
+			user, err := parseRedisUser(tx.Get(ctx, key).Result())
+			exists := err == nil
+			if err != nil && !errors.Is(err, NotFoundErr) {
+				return err
+			}
+			user, err = update(user, exists)
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(user)
+			if err != nil {
+				return errors.Wrap(err, "Unable to encode JSON")
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				pipe.Set(ctx, key, value, 0)
+				pipe.SAdd(ctx, redisUserIDsKey, id)
+				return nil
+			})
+			return err
+		}, key)
+		if !errors.Is(err, redis.TxFailedErr) {
+			if err != nil && !errors.Is(err, NotFoundErr) {
+				return errors.Wrap(err, "Unable to set the user in Redis")
+			}
+			return err
+		}
+	}
+	return errors.New("Unable to set the user in Redis: it is changed too often")
+}
+
+func (r RedisStore) AddRequest(request Request) error {
+	value, err := json.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode JSON")
+	}
+	ctx := context.Background()
+	requestsKey := redisRequestsPrefix + strconv.FormatInt(request.UserID, 10)
+	statsKey := redisDayPrefix + dayKey(request.Time)
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, requestsKey, value)
+		pipe.LTrim(ctx, requestsKey, 0, recentRequestsPerUser-1)
+		pipe.Expire(ctx, requestsKey, recentRequestsTTL)
+		pipe.HIncrBy(ctx, statsKey, redisDayRequestsField, 1)
+		if request.Error != "" {
+			pipe.HIncrBy(ctx, statsKey, redisDayFailuresField, 1)
+			pipe.HIncrBy(ctx, statsKey, redisDayFailureCategoryField+request.Error, 1)
+		}
+		if request.Subreddit != "" {
+			pipe.HIncrBy(ctx, statsKey, redisDaySubredditField+request.Subreddit, 1)
+		}
+		pipe.Expire(ctx, statsKey, dailyStatsTTL)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "Unable to add the request in Redis")
+	}
+	return nil
+}
+
+func (r RedisStore) RecentRequests(userID int64) ([]Request, error) {
+	values, err := r.client.LRange(context.Background(), redisRequestsPrefix+strconv.FormatInt(userID, 10), 0, -1).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get the requests from Redis")
+	}
+	requests := make([]Request, len(values))
+	for i, value := range values {
+		if err = json.Unmarshal([]byte(value), &requests[i]); err != nil {
+			return nil, errors.Wrap(err, "Unable to parse JSON")
+		}
+	}
+	return requests, nil
+}
+
+func (r RedisStore) DailyStats(day time.Time) (DailyStats, error) {
+	fields, err := r.client.HGetAll(context.Background(), redisDayPrefix+dayKey(day)).Result()
+	if err != nil {
+		return DailyStats{}, errors.Wrap(err, "Unable to get the statistics from Redis")
+	}
+	stats := DailyStats{
+		FailuresByCategory: make(map[string]int64),
+		Subreddits:         make(map[string]int64),
+	}
+	for field, value := range fields {
+		count, _ := strconv.ParseInt(value, 10, 64)
+		switch {
+		case field == redisDayRequestsField:
+			stats.Requests = count
+		case field == redisDayFailuresField:
+			stats.Failures = count
+		case strings.HasPrefix(field, redisDayFailureCategoryField):
+			stats.FailuresByCategory[strings.TrimPrefix(field, redisDayFailureCategoryField)] = count
+		case strings.HasPrefix(field, redisDaySubredditField):
+			stats.Subreddits[strings.TrimPrefix(field, redisDaySubredditField)] = count
+		}
+	}
+	return stats, nil
+}
+
+func (r RedisStore) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,44 @@
+package usage
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// TestRedisStore needs a Redis server. Its address is read from REDIS_TEST_ADDRESS and defaults
+// to localhost:6379. The test is skipped if the server is not available. Only the keys of the
+// test users and days are removed, so the other data on the server is kept.
+func TestRedisStore(t *testing.T) {
+	address := os.Getenv("REDIS_TEST_ADDRESS")
+	if address == "" {
+		address = "localhost:6379"
+	}
+	store, err := NewRedisStore(address, os.Getenv("REDIS_TEST_PASSWORD"))
+	if err != nil {
+		_ = store.Close()
+		t.Skipf("Redis is not available at %s: %s", address, err)
+	}
+	defer store.Close()
+	testStore(t, func(t *testing.T) Store {
+		cleanUpRedisStore(t, store)
+		t.Cleanup(func() { cleanUpRedisStore(t, store) })
+		return store
+	})
+}
+
+// cleanUpRedisStore removes the keys which are created by testStore
+func cleanUpRedisStore(t *testing.T, store RedisStore) {
+	ctx := context.Background()
+	var keys []string
+	for _, id := range []int64{testUserID, testOtherUserID} {
+		keys = append(keys, redisUserKey(id), redisRequestsPrefix+strconv.FormatInt(id, 10))
+		assert.NoError(t, store.client.SRem(ctx, redisUserIDsKey, id).Err())
+	}
+	for _, day := range []string{dayKey(testDay), dayKey(testOtherDay)} {
+		keys = append(keys, redisDayPrefix+day)
+	}
+	assert.NoError(t, store.client.Del(ctx, keys...).Err())
+}
@@ -0,0 +1,101 @@
+// Package usage stores the users of the bot and their requests. The admin commands use it to
+// show statistics and to send announcements.
+package usage
+
+import (
+	"github.com/go-faster/errors"
+	"time"
+)
+
+// NotFoundErr will be returned if the user does not exist in the store
+var NotFoundErr = errors.New("user not found")
+
+// recentRequestsPerUser is the number of requests of each user which are kept
+const recentRequestsPerUser = 20
+
+// recentRequestsTTL is the time which the requests of a user are kept after their last request
+const recentRequestsTTL = 30 * 24 * time.Hour
+
+// dailyStatsTTL is the time which the statistics of each day are kept
+const dailyStatsTTL = 31 * 24 * time.Hour
+
+// User is someone who has sent a message to the bot
+type User struct {
+	ID        int64
+	Username  string
+	FirstName string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// True if the user does not want to receive the announcements
+	OptedOut bool
+	// How the quality of the media is chosen for this user. Empty means the default of the bot.
+	QualityMode QualityMode
+	// True if the user has blocked the bot. Such users do not receive the announcements
+	// until they send a message again.
+	Blocked bool
+}
+
+// QualityMode says how the quality of a media with multiple qualities is chosen
+type QualityMode string
+
+const (
+	// QualityModeDefault uses the default mode of the bot
+	QualityModeDefault QualityMode = ""
+	// QualityModeAuto downloads the best quality which can be uploaded to Telegram
+	QualityModeAuto QualityMode = "auto"
+	// QualityModeAsk asks the user to choose the quality
+	QualityModeAsk QualityMode = "ask"
+)
+
+// Request is a link which a user has sent to the bot
+type Request struct {
+	UserID  int64
+	Time    time.Time
+	PostURL string
+	// The subreddit of the post without the r/ prefix. Empty if unknown.
+	Subreddit string
+	// The type of the post, like "video". Empty if the request has failed.
+	Type string
+	// The category of the error if the request has failed
+	Error string
+}
+
+// DailyStats are the statistics of the requests of a single day (UTC)
+type DailyStats struct {
+	Requests int64
+	Failures int64
+	// The number of the failed requests per error category
+	FailuresByCategory map[string]int64
+	// The number of requests per subreddit
+	Subreddits map[string]int64
+}
+
+// Store keeps the users and their requests
+type Store interface {
+	// TouchUser adds a user or updates its name and last seen time. The FirstSeen, OptedOut and
+	// QualityMode fields of existing users are kept. Blocked is cleared.
+	TouchUser(user User) error
+	// GetUser gets a user. If it does not exist, returns NotFoundErr as error
+	GetUser(id int64) (User, error)
+	// Users returns all the users
+	Users() ([]User, error)
+	// SetOptedOut changes if a user receives the announcements
+	SetOptedOut(id int64, optedOut bool) error
+	// SetQualityMode changes how the quality of the media is chosen for a user
+	SetQualityMode(id int64, mode QualityMode) error
+	// SetBlocked marks a user as someone who has blocked the bot
+	SetBlocked(id int64, blocked bool) error
+	// AddRequest records a request. It is counted in the statistics of the day of the request.
+	AddRequest(request Request) error
+	// RecentRequests returns the last requests of a user, newest first
+	RecentRequests(userID int64) ([]Request, error)
+	// DailyStats returns the statistics of a day
+	DailyStats(day time.Time) (DailyStats, error)
+	// Close must flush the pending changes and close the underlying storage
+	Close() error
+}
+
+// dayKey returns the key of the statistics of the day of t
+func dayKey(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
@@ -0,0 +1,194 @@
+package usage
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// The users and the days which are used by testStore. The days are long gone, so they never
+// collide with the statistics of a real bot which shares the Redis server.
+const (
+	testUserID      int64 = -1001
+	testOtherUserID int64 = -1002
+)
+
+var (
+	testDay      = time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+	testOtherDay = testDay.AddDate(0, 0, 1)
+)
+
+// testStore runs the tests which every Store must pass. newStore must return an empty store.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	t.Run("TouchUser", func(t *testing.T) {
+		firstSeen := testDay.Add(-time.Hour)
+		tests := []struct {
+			Name     string
+			Existing *User
+			Touch    User
+			Expected User
+		}{
+			{
+				Name:     "new_user",
+				Touch:    User{ID: testUserID, Username: "user", FirstName: "User", LastSeen: testDay},
+				Expected: User{ID: testUserID, Username: "user", FirstName: "User", FirstSeen: testDay, LastSeen: testDay},
+			},
+			{
+				Name:     "new_user_with_first_seen",
+				Touch:    User{ID: testUserID, FirstSeen: firstSeen, LastSeen: testDay},
+				Expected: User{ID: testUserID, FirstSeen: firstSeen, LastSeen: testDay},
+			},
+			{
+				Name: "existing_user",
+				Existing: &User{
+					ID:          testUserID,
+					Username:    "old",
+					FirstSeen:   firstSeen,
+					LastSeen:    firstSeen,
+					OptedOut:    true,
+					QualityMode: QualityModeAsk,
+					Blocked:     true,
+				},
+				Touch: User{ID: testUserID, Username: "new", FirstSeen: testDay, LastSeen: testDay, Blocked: true},
+				Expected: User{
+					ID:          testUserID,
+					Username:    "new",
+					FirstSeen:   firstSeen,
+					LastSeen:    testDay,
+					OptedOut:    true,
+					QualityMode: QualityModeAsk,
+				},
+			},
+		}
+		for _, test := range tests {
+			t.Run(test.Name, func(t *testing.T) {
+				store := newStore(t)
+				if test.Existing != nil {
+					assert.NoError(t, store.TouchUser(*test.Existing))
+					assert.NoError(t, store.SetOptedOut(test.Existing.ID, test.Existing.OptedOut))
+					assert.NoError(t, store.SetQualityMode(test.Existing.ID, test.Existing.QualityMode))
+				}
+				assert.NoError(t, store.TouchUser(test.Touch))
+				user, err := store.GetUser(test.Touch.ID)
+				assert.NoError(t, err)
+				assert.Equal(t, test.Expected, user)
+			})
+		}
+	})
+
+	t.Run("GetUser", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.GetUser(testUserID)
+		assert.ErrorIs(t, err, NotFoundErr)
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		store := newStore(t)
+		users, err := store.Users()
+		assert.NoError(t, err)
+		assert.Empty(t, testUsers(users))
+		assert.NoError(t, store.TouchUser(User{ID: testUserID, LastSeen: testDay}))
+		assert.NoError(t, store.TouchUser(User{ID: testOtherUserID, LastSeen: testDay}))
+		users, err = store.Users()
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []User{
+			{ID: testUserID, FirstSeen: testDay, LastSeen: testDay},
+			{ID: testOtherUserID, FirstSeen: testDay, LastSeen: testDay},
+		}, testUsers(users))
+	})
+
+	t.Run("SetOptedOut", func(t *testing.T) {
+		store := newStore(t)
+		assert.ErrorIs(t, store.SetOptedOut(testUserID, true), NotFoundErr)
+		assert.NoError(t, store.TouchUser(User{ID: testUserID, LastSeen: testDay}))
+		for _, optedOut := range []bool{true, false} {
+			assert.NoError(t, store.SetOptedOut(testUserID, optedOut))
+			user, err := store.GetUser(testUserID)
+			assert.NoError(t, err)
+			assert.Equal(t, optedOut, user.OptedOut)
+		}
+	})
+
+	t.Run("SetBlocked", func(t *testing.T) {
+		store := newStore(t)
+		assert.ErrorIs(t, store.SetBlocked(testUserID, true), NotFoundErr)
+		assert.NoError(t, store.TouchUser(User{ID: testUserID, LastSeen: testDay}))
+		assert.NoError(t, store.SetBlocked(testUserID, true))
+		user, err := store.GetUser(testUserID)
+		assert.NoError(t, err)
+		assert.True(t, user.Blocked)
+		// Sending a message again unblocks the user
+		assert.NoError(t, store.TouchUser(User{ID: testUserID, LastSeen: testOtherDay}))
+		user, err = store.GetUser(testUserID)
+		assert.NoError(t, err)
+		assert.False(t, user.Blocked)
+	})
+
+	t.Run("RecentRequests", func(t *testing.T) {
+		store := newStore(t)
+		const added = recentRequestsPerUser + 5
+		for i := range added {
+			assert.NoError(t, store.AddRequest(Request{
+				UserID:  testUserID,
+				Time:    testDay.Add(time.Duration(i) * time.Second),
+				PostURL: fmt.Sprintf("https://reddit.com/%d", i),
+			}))
+		}
+		requests, err := store.RecentRequests(testUserID)
+		assert.NoError(t, err)
+		// Only the newest requests are kept, newest first
+		if assert.Len(t, requests, recentRequestsPerUser) {
+			for i, request := range requests {
+				assert.Equal(t, fmt.Sprintf("https://reddit.com/%d", added-1-i), request.PostURL)
+			}
+		}
+		requests, err = store.RecentRequests(testOtherUserID)
+		assert.NoError(t, err)
+		assert.Empty(t, requests)
+	})
+
+	t.Run("DailyStats", func(t *testing.T) {
+		store := newStore(t)
+		requests := []Request{
+			{UserID: testUserID, Time: testDay, Subreddit: "pics", Type: "photo"},
+			{UserID: testUserID, Time: testDay.Add(time.Hour), Subreddit: "pics", Type: "photo"},
+			{UserID: testOtherUserID, Time: testDay, Subreddit: "videos", Error: "network"},
+			{UserID: testOtherUserID, Time: testDay, Error: "network"},
+			{UserID: testOtherUserID, Time: testDay, Error: "not_found"},
+			{UserID: testUserID, Time: testOtherDay, Subreddit: "pics", Type: "photo"},
+		}
+		for _, request := range requests {
+			assert.NoError(t, store.AddRequest(request))
+		}
+		stats, err := store.DailyStats(testDay)
+		assert.NoError(t, err)
+		assert.Equal(t, DailyStats{
+			Requests:           5,
+			Failures:           3,
+			FailuresByCategory: map[string]int64{"network": 2, "not_found": 1},
+			Subreddits:         map[string]int64{"pics": 2, "videos": 1},
+		}, stats)
+		stats, err = store.DailyStats(testOtherDay)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), stats.Requests)
+		assert.Zero(t, stats.Failures)
+		assert.Empty(t, stats.FailuresByCategory)
+		assert.Equal(t, map[string]int64{"pics": 1}, stats.Subreddits)
+		stats, err = store.DailyStats(testOtherDay.AddDate(0, 0, 1))
+		assert.NoError(t, err)
+		assert.Zero(t, stats.Requests)
+		assert.Empty(t, stats.Subreddits)
+	})
+}
+
+// testUsers returns the users which are created by testStore
+func testUsers(users []User) []User {
+	var result []User
+	for _, user := range users {
+		if user.ID == testUserID || user.ID == testOtherUserID {
+			result = append(result, user)
+		}
+	}
+	return result
+}
@@ -0,0 +1,102 @@
+// Package logging sets up the structured logger of the bot. It also carries the loggers
+// of the requests, which contain their correlation IDs, in contexts.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/go-faster/errors"
+)
+
+// CorrelationIDKey is the attribute key of the correlation ID of each update
+const CorrelationIDKey = "correlation_id"
+
+// Format is the output format of the logs
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// loggerKey is the key of the logger in contexts
+type loggerKey struct{}
+
+// level is the minimum level of the logs. It can be changed with SetLevel.
+var level slog.LevelVar
+
+// ParseLevel parses a log level like "debug", "info", "warn", or "error".
+// An empty string is parsed as info.
+func ParseLevel(name string) (slog.Level, error) {
+	var result slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := result.UnmarshalText([]byte(name)); err != nil {
+		return 0, errors.Wrap(err, "invalid log level")
+	}
+	return result, nil
+}
+
+// ParseFormat parses a log format. An empty string is parsed as text.
+func ParseFormat(format string) (Format, error) {
+	switch Format(strings.ToLower(format)) {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	default:
+		return "", errors.Errorf("invalid log format %q", format)
+	}
+}
+
+// Setup sets the default logger of slog and the log package. All the records are redacted
+// before being written; see Redact.
+func Setup(minLevel slog.Level, format Format) {
+	level.Set(minLevel)
+	options := &slog.HandlerOptions{Level: &level}
+	var handler slog.Handler
+	if format == FormatJSON {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(redactingHandler{handler}))
+}
+
+// SetLevel changes the minimum level of the logs
+func SetLevel(minLevel slog.Level) {
+	level.Set(minLevel)
+}
+
+// Fatal logs an error and exits the program
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// NewCorrelationID creates a random ID to correlate the logs of a single update
+func NewCorrelationID() string {
+	var id [8]byte
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// WithLogger returns a context which carries the logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger of a context. If the context does not have any logger,
+// the default logger is returned.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
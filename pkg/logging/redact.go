@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redacted replaces the secrets in the logs
+const redacted = "[REDACTED]"
+
+// secretPatterns match the secrets which might appear in error messages, like the bot token
+// in the URLs of the Bot API or the tokens in the responses of Reddit
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// Telegram bot tokens
+	{regexp.MustCompile(`\d{5,}:[A-Za-z0-9_-]{30,}`), redacted},
+	// Authorization headers
+	{regexp.MustCompile(`(?i)\b(bearer:?)\s+[A-Za-z0-9._~+/=-]+`), "${1} " + redacted},
+	{regexp.MustCompile(`(?i)\b(basic)\s+[A-Za-z0-9+/=]{16,}`), "${1} " + redacted},
+	// Query parameters and JSON fields
+	{regexp.MustCompile(`(?i)\b(access_token|refresh_token|client_secret|token|secret|password)("?[=:]"?)[^&\s",}]+`), "${1}${2}" + redacted},
+}
+
+// secrets are the known secrets which must never be logged, like the bot token
+var secrets struct {
+	values []string
+	lock   sync.RWMutex
+}
+
+// AddSecret registers a secret which is replaced in all the logs
+func AddSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	secrets.lock.Lock()
+	defer secrets.lock.Unlock()
+	for _, s := range secrets.values {
+		if s == secret {
+			return
+		}
+	}
+	secrets.values = append(secrets.values, secret)
+}
+
+// RemoveSecret removes a secret which was registered with AddSecret. It is used when a
+// secret like an access token is replaced.
+func RemoveSecret(secret string) {
+	secrets.lock.Lock()
+	defer secrets.lock.Unlock()
+	for i, s := range secrets.values {
+		if s == secret {
+			secrets.values = append(secrets.values[:i], secrets.values[i+1:]...)
+			return
+		}
+	}
+}
+
+// Redact removes the registered secrets and the values which look like secrets from a text
+func Redact(text string) string {
+	secrets.lock.RLock()
+	for _, secret := range secrets.values {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	secrets.lock.RUnlock()
+	for _, p := range secretPatterns {
+		text = p.pattern.ReplaceAllString(text, p.replacement)
+	}
+	return text
+}
+
+// isSecretKey checks if the value of an attribute must never be logged because of its key
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "token") || strings.Contains(key, "secret") || strings.Contains(key, "password")
+}
+
+// redactAttr redacts the value of an attribute
+func redactAttr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	if isSecretKey(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, Redact(attr.Value.String()))
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		redactedGroup := make([]any, len(group))
+		for i := range group {
+			redactedGroup[i] = redactAttr(group[i])
+		}
+		return slog.Group(attr.Key, redactedGroup...)
+	case slog.KindAny:
+		switch value := attr.Value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, Redact(value.Error()))
+		case fmt.Stringer:
+			return slog.String(attr.Key, Redact(value.String()))
+		}
+	}
+	return attr
+}
+
+// redactingHandler is a slog.Handler which redacts the secrets of the records before
+// passing them to another handler
+type redactingHandler struct {
+	slog.Handler
+}
+
+func (h redactingHandler) Handle(ctx context.Context, record slog.Record) error {
+	result := slog.NewRecord(record.Time, record.Level, Redact(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		result.AddAttrs(redactAttr(attr))
+		return true
+	})
+	return h.Handler.Handle(ctx, result)
+}
+
+func (h redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redactedAttrs := make([]slog.Attr, len(attrs))
+	for i := range attrs {
+		redactedAttrs[i] = redactAttr(attrs[i])
+	}
+	return redactingHandler{h.Handler.WithAttrs(redactedAttrs)}
+}
+
+func (h redactingHandler) WithGroup(name string) slog.Handler {
+	return redactingHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	AddSecret("hunter2-client-secret")
+	defer RemoveSecret("hunter2-client-secret")
+	tests := []struct {
+		TestName string
+		Text     string
+		Expected string
+	}{
+		{
+			TestName: "Nothing to redact",
+			Text:     "Unable to download GIF https://i.redd.it/abc.gif",
+			Expected: "Unable to download GIF https://i.redd.it/abc.gif",
+		},
+		{
+			TestName: "Bot token in URL",
+			Text:     `Post "https://api.telegram.org/bot123456789:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw/sendVideo": context canceled`,
+			Expected: `Post "https://api.telegram.org/bot[REDACTED]/sendVideo": context canceled`,
+		},
+		{
+			TestName: "Registered secret",
+			Text:     "cannot authorize with hunter2-client-secret",
+			Expected: "cannot authorize with [REDACTED]",
+		},
+		{
+			TestName: "Authorization header",
+			Text:     "Authorization: bearer: eyJhbGciOiJSUzI1NiIsImtpZCI6IlNIQTI1NjpzS3dsMnlsV0VtMjVmcXhwTU40cWY4MXE2OWFFdWFyMnpLMUdhVGxjdWNZIiwidHlwIjoiSldUIn0",
+			Expected: "Authorization: bearer: [REDACTED]",
+		},
+		{
+			TestName: "Access token in JSON",
+			Text:     `Body starts with: {"access_token":"eyJhbGciOiJSUzI1NiIsImtp","token_type":"bearer"}`,
+			Expected: `Body starts with: {"access_token":"[REDACTED]","token_type":"bearer"}`,
+		},
+		{
+			TestName: "Token in query",
+			Text:     "GET https://example.com/video.mp4?token=abcdef&quality=720",
+			Expected: "GET https://example.com/video.mp4?token=[REDACTED]&quality=720",
+		},
+		{
+			TestName: "Token in a sentence",
+			Text:     "cannot re-generate token: status code is not 200",
+			Expected: "cannot re-generate token: status code is not 200",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			assert.Equal(t, test.Expected, Redact(test.Text))
+		})
+	}
+}
+
+func TestRedactingHandler(t *testing.T) {
+	var output bytes.Buffer
+	logger := slog.New(redactingHandler{slog.NewTextHandler(&output, nil)})
+	logger.With("client_secret", "abc").Info("Cannot fetch",
+		"error", errors.New("GET https://api.reddit.com?access_token=xyz failed"),
+		slog.Group("request", "authorization", "bearer: xyz"))
+	assert.NotContains(t, output.String(), "abc")
+	assert.NotContains(t, output.String(), "xyz")
+	assert.Contains(t, output.String(), "access_token=[REDACTED]")
+}
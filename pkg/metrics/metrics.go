@@ -0,0 +1,125 @@
+// Package metrics contains the Prometheus metrics of the bot
+package metrics
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace is the prefix of all metrics
+const namespace = "reddit_downloader"
+
+// Outcomes of requests and uploads
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Requests counts the posts which users have requested by the type of post and the outcome.
+// The post type is "unknown" if the post could not be fetched.
+var Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "requests_total",
+	Help:      "Number of requested posts by post type and outcome.",
+}, []string{"post_type", "outcome"})
+
+// FetchErrors counts the errors of fetching the posts by their category
+var FetchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "fetch_errors_total",
+	Help:      "Number of errors while fetching posts by category.",
+}, []string{"category"})
+
+// DownloadBytes counts the downloaded bytes per host
+var DownloadBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "download_bytes_total",
+	Help:      "Number of downloaded bytes per host.",
+}, []string{"host"})
+
+// DownloadDuration is the time which each download takes per host
+var DownloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "download_duration_seconds",
+	Help:      "Duration of downloads per host.",
+	Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+}, []string{"host"})
+
+// FfmpegMergeDuration is the time which merging the video and audio takes
+var FfmpegMergeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "ffmpeg_merge_duration_seconds",
+	Help:      "Duration of merging videos and audios with ffmpeg.",
+	Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+})
+
+// FfmpegMergeFailures counts the failed merges of video and audio
+var FfmpegMergeFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "ffmpeg_merge_failures_total",
+	Help:      "Number of failed merges of videos and audios with ffmpeg.",
+})
+
+// UploadDuration is the time which uploading each media kind to Telegram takes
+var UploadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "upload_duration_seconds",
+	Help:      "Duration of uploads to Telegram by media kind and outcome.",
+	Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+}, []string{"kind", "outcome"})
+
+// RedditRateLimitRemaining is the number of requests which we can send to Reddit in the current period
+var RedditRateLimitRemaining = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "reddit_ratelimit_remaining",
+	Help:      "Remaining Reddit API requests in the current rate limit period.",
+})
+
+// CacheRequests counts the cache lookups per backend, cache, and result (hit or miss)
+var CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "cache_requests_total",
+	Help:      "Number of cache lookups by backend, cache, and result.",
+}, []string{"backend", "cache", "result"})
+
+// QueueDepth is the number of jobs which are waiting in the queue
+var QueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "queue_depth",
+	Help:      "Number of jobs waiting in the queue.",
+})
+
+// RunningJobs is the number of jobs which are running
+var RunningJobs = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "running_jobs",
+	Help:      "Number of jobs which are running.",
+})
+
+// Handler returns the HTTP handler which serves the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// CacheLookup records a cache lookup. found is the result of the lookup.
+func CacheLookup(backend, cache string, found bool) {
+	result := "miss"
+	if found {
+		result = "hit"
+	}
+	CacheRequests.WithLabelValues(backend, cache, result).Inc()
+}
+
+// HostOfLink returns the host of a link to be used as a label. Returns "unknown" if the
+// link cannot be parsed.
+func HostOfLink(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Hostname() == "" {
+		return "unknown"
+	}
+	return u.Hostname()
+}
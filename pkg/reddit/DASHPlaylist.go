@@ -2,42 +2,120 @@ package reddit
 
 import (
 	"RedditDownloaderBot/pkg/common"
+	"cmp"
+	"context"
 	"encoding/xml"
 	"github.com/go-faster/errors"
 	"io"
+	"math"
 	"net/http"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // numberRegex will only match numbers in a string
 var numberRegex = regexp.MustCompile("(\\d+)")
 
+// durationRegex matches the ISO 8601 durations which are used in DASH playlists. For example, PT2M5.5S
+var durationRegex = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
 // DashPlaylistXML is the root of
 type DashPlaylistXML struct {
-	XMLName xml.Name `xml:"MPD"`
-	Period  struct {
+	XMLName  xml.Name `xml:"MPD"`
+	Duration string   `xml:"mediaPresentationDuration,attr"`
+	Period   struct {
 		XMLName    xml.Name                     `xml:"Period"`
 		MediaTypes []DashPlaylistApplicationSet `xml:"AdaptationSet"`
 	}
 }
 
-// DashPlaylistApplicationSet represents the audio or video urls of current video
+// DashPlaylistApplicationSet represents the audio or video urls of current video.
+// Its attributes are the defaults of its representations.
 type DashPlaylistApplicationSet struct {
-	XMLName     xml.Name                     `xml:"AdaptationSet"`
-	ContentType string                       `xml:"contentType,attr"`
-	Qualities   []DashPlaylistRepresentation `xml:"Representation"`
+	XMLName         xml.Name                     `xml:"AdaptationSet"`
+	ContentType     string                       `xml:"contentType,attr"`
+	MimeType        string                       `xml:"mimeType,attr"`
+	Codecs          string                       `xml:"codecs,attr"`
+	SegmentTemplate *DashSegmentTemplate         `xml:"SegmentTemplate"`
+	Qualities       []DashPlaylistRepresentation `xml:"Representation"`
 }
 
 // DashPlaylistRepresentation represents the link to each media type
 type DashPlaylistRepresentation struct {
-	XMLName xml.Name `xml:"Representation"`
-	BaseURL string   `xml:"BaseURL"`
-	ID      string   `xml:"id,attr"`
-	Width   string   `xml:"width,attr"`
-	Height  string   `xml:"height,attr"`
+	XMLName           xml.Name `xml:"Representation"`
+	BaseURL           string   `xml:"BaseURL"`
+	ID                string   `xml:"id,attr"`
+	Width             string   `xml:"width,attr"`
+	Height            string   `xml:"height,attr"`
+	Bandwidth         int64    `xml:"bandwidth,attr"`
+	Codecs            string   `xml:"codecs,attr"`
+	FrameRate         string   `xml:"frameRate,attr"`
+	MimeType          string   `xml:"mimeType,attr"`
+	AudioSamplingRate string   `xml:"audioSamplingRate,attr"`
+	// The number of the audio channels is in its value attribute
+	AudioChannelConfiguration struct {
+		Value string `xml:"value,attr"`
+	} `xml:"AudioChannelConfiguration"`
+	SegmentBase     *DashSegmentBase     `xml:"SegmentBase"`
+	SegmentTemplate *DashSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *DashSegmentList     `xml:"SegmentList"`
+}
+
+// DashSegmentBase describes a representation which is a single file at its BaseURL
+type DashSegmentBase struct {
+	// The byte range of the segment index, like 845-912
+	IndexRange string `xml:"indexRange,attr"`
+	// The units of time in a second
+	Timescale      int64                     `xml:"timescale,attr"`
+	Initialization DashSegmentInitialization `xml:"Initialization"`
+}
+
+// DashSegmentInitialization is the initialization segment of a DashSegmentBase or a DashSegmentList
+type DashSegmentInitialization struct {
+	// The link of the initialization segment. Empty means the BaseURL.
+	SourceURL string `xml:"sourceURL,attr"`
+	// The byte range of the initialization segment, like 0-844
+	Range string `xml:"range,attr"`
+}
+
+// DashSegmentTemplate describes a representation which is split into an initialization segment and
+// media segments whose links are created from templates like DASH_$Number$.m4s
+type DashSegmentTemplate struct {
+	Initialization string `xml:"initialization,attr"`
+	Media          string `xml:"media,attr"`
+	// The number of the first segment. If nil, it is 1.
+	StartNumber *int64 `xml:"startNumber,attr"`
+	// The units of time in a second
+	Timescale int64 `xml:"timescale,attr"`
+	// The duration of each segment in Timescale if SegmentTimeline is not used
+	Duration int64 `xml:"duration,attr"`
+	// The start times and durations of the segments
+	Timeline []DashSegmentTimelineEntry `xml:"SegmentTimeline>S"`
+}
+
+// DashSegmentList describes a representation whose segments are listed one by one
+type DashSegmentList struct {
+	Initialization *DashSegmentInitialization `xml:"Initialization"`
+	Segments       []DashSegmentURL           `xml:"SegmentURL"`
+}
+
+// DashSegmentURL is a media segment of a DashSegmentList
+type DashSegmentURL struct {
+	// The link of the segment. Empty means the BaseURL.
+	Media string `xml:"media,attr"`
+	// The byte range of the segment, like 913-50000
+	MediaRange string `xml:"mediaRange,attr"`
+}
+
+// DashSegmentTimelineEntry is an S element of SegmentTimeline. It describes R+1 segments
+// with the duration of D which start at T.
+type DashSegmentTimelineEntry struct {
+	T *int64 `xml:"t,attr"`
+	D int64  `xml:"d,attr"`
+	R int64  `xml:"r,attr"`
 }
 
 // Dimension will get the dimension of the given video
@@ -50,14 +128,55 @@ func (d DashPlaylistRepresentation) Dimension() Dimension {
 	}
 }
 
+// contentType guesses if a representation of set is a video or an audio. Empty means unknown.
+func (d DashPlaylistRepresentation) contentType(set DashPlaylistApplicationSet) string {
+	if set.ContentType != "" {
+		return set.ContentType
+	}
+	mimeType := cmp.Or(d.MimeType, set.MimeType)
+	switch {
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	case strings.HasPrefix(d.ID, "VIDEO"): // Used in very old videos. See tests
+		return "video"
+	case strings.HasPrefix(d.ID, "AUDIO"):
+		return "audio"
+	}
+	return ""
+}
+
 // AvailableVideo represents a single available video quality for a video on reddit
 type AvailableVideo struct {
+	ID        string
 	BaseURL   string
 	Dimension Dimension
+	// The average bitrate of the video in bits per second. Zero if unknown.
+	Bandwidth int64
+	// The codecs of the video, like avc1.4d401f
+	Codecs string
+	// The frames per second. Zero if unknown.
+	FrameRate float64
+	MimeType  string
+	// How the video is split into segments. At most one of them is set.
+	SegmentBase     *DashSegmentBase
+	SegmentTemplate *DashSegmentTemplate
+	SegmentList     *DashSegmentList
+}
+
+// EstimatedSize estimates the size of the video in bytes from its bandwidth.
+// Zero is returned if the bandwidth or the duration is unknown.
+func (v AvailableVideo) EstimatedSize(duration time.Duration) int64 {
+	return int64(float64(v.Bandwidth) / 8 * duration.Seconds())
 }
 
-// Quality gets the quality of a video
+// Quality gets the quality of a video. It is the shorter side of the video, or the first number
+// in its link if the dimension is unknown.
 func (v AvailableVideo) Quality() string {
+	if side := v.Dimension.shortSide(); side > 0 {
+		return strconv.FormatInt(side, 10)
+	}
 	numbers := numberRegex.FindStringSubmatch(v.BaseURL)
 	if len(numbers) < 2 {
 		return "NA"
@@ -65,13 +184,67 @@ func (v AvailableVideo) Quality() string {
 	return numbers[1]
 }
 
+// Label is the name of the quality which is shown to the users, like 720p or 1080p60
+func (v AvailableVideo) Label() string {
+	return videoQualityLabel(v.Quality(), v.FrameRate)
+}
+
+// videoQualityLabel creates the label of a video quality from its height and frame rate.
+// The frame rate is only shown if it is higher than 30.
+func videoQualityLabel(height string, frameRate float64) string {
+	label := height + "p"
+	if frameRate > 30.5 {
+		label += strconv.FormatFloat(math.Round(frameRate), 'f', 0, 64)
+	}
+	return label
+}
+
 // AvailableAudio represents a single available audio quality for a video on reddit
-type AvailableAudio string
+type AvailableAudio struct {
+	ID      string
+	BaseURL string
+	// The average bitrate of the audio in bits per second. Zero if unknown.
+	Bandwidth int64
+	// The codecs of the audio, like mp4a.40.2
+	Codecs   string
+	MimeType string
+	// The sampling rate in Hz. Zero if unknown.
+	SamplingRate int64
+	// The number of channels. Zero if unknown.
+	Channels int64
+	// How the audio is split into segments. At most one of them is set.
+	SegmentBase     *DashSegmentBase
+	SegmentTemplate *DashSegmentTemplate
+	SegmentList     *DashSegmentList
+}
+
+// EstimatedSize estimates the size of the audio in bytes from its bandwidth.
+// Zero is returned if the bandwidth or the duration is unknown.
+func (a AvailableAudio) EstimatedSize(duration time.Duration) int64 {
+	return int64(float64(a.Bandwidth) / 8 * duration.Seconds())
+}
 
 // AvailableMedia represents the available medias for a video on reddit
 type AvailableMedia struct {
 	AvailableVideos []AvailableVideo
 	AvailableAudios []AvailableAudio
+	// The duration of the media. Zero if unknown.
+	Duration time.Duration
+}
+
+// BestAudio returns the audio with the highest bandwidth. If the bandwidths are unknown,
+// the last audio is returned because Reddit lists them in increasing order.
+func (m AvailableMedia) BestAudio() (AvailableAudio, bool) {
+	if len(m.AvailableAudios) == 0 {
+		return AvailableAudio{}, false
+	}
+	best := m.AvailableAudios[len(m.AvailableAudios)-1]
+	for _, audio := range m.AvailableAudios {
+		if audio.Bandwidth > best.Bandwidth {
+			best = audio
+		}
+	}
+	return best, true
 }
 
 // parseDashPlaylist will parse the DashPlaylist file from Reddit
@@ -84,42 +257,98 @@ func parseDashPlaylist(r io.Reader) (AvailableMedia, error) {
 	}
 	// Convert to result
 	var result AvailableMedia
-	for _, media := range parsedXML.Period.MediaTypes {
-		switch media.ContentType {
-		case "video":
-			result.AvailableVideos = make([]AvailableVideo, len(media.Qualities))
-			for i, video := range media.Qualities {
-				result.AvailableVideos[i] = AvailableVideo{
-					BaseURL:   video.BaseURL,
-					Dimension: video.Dimension(),
-				}
-			}
-		case "audio":
-			result.AvailableAudios = make([]AvailableAudio, len(media.Qualities))
-			for i, audio := range media.Qualities {
-				result.AvailableAudios[i] = AvailableAudio(audio.BaseURL)
+	result.Duration, _ = parseISO8601Duration(parsedXML.Duration)
+	for _, set := range parsedXML.Period.MediaTypes {
+		for _, representation := range set.Qualities {
+			segmentTemplate := representation.SegmentTemplate
+			if segmentTemplate == nil && representation.SegmentBase == nil && representation.SegmentList == nil {
+				segmentTemplate = set.SegmentTemplate
 			}
-		case "": // Used in very old videos. See tests
-			for _, m := range media.Qualities {
-				if strings.HasPrefix(m.ID, "VIDEO") {
-					result.AvailableVideos = append(result.AvailableVideos, AvailableVideo{BaseURL: m.BaseURL, Dimension: m.Dimension()})
-				} else if strings.HasPrefix(m.ID, "AUDIO") {
-					result.AvailableAudios = append(result.AvailableAudios, AvailableAudio(m.BaseURL))
-				}
+			switch representation.contentType(set) {
+			case "video":
+				result.AvailableVideos = append(result.AvailableVideos, AvailableVideo{
+					ID:              representation.ID,
+					BaseURL:         representation.BaseURL,
+					Dimension:       representation.Dimension(),
+					Bandwidth:       representation.Bandwidth,
+					Codecs:          cmp.Or(representation.Codecs, set.Codecs),
+					FrameRate:       parseFrameRate(representation.FrameRate),
+					MimeType:        cmp.Or(representation.MimeType, set.MimeType),
+					SegmentBase:     representation.SegmentBase,
+					SegmentTemplate: segmentTemplate,
+					SegmentList:     representation.SegmentList,
+				})
+			case "audio":
+				samplingRate, _ := strconv.ParseInt(representation.AudioSamplingRate, 10, 64)
+				channels, _ := strconv.ParseInt(representation.AudioChannelConfiguration.Value, 10, 64)
+				result.AvailableAudios = append(result.AvailableAudios, AvailableAudio{
+					ID:              representation.ID,
+					BaseURL:         representation.BaseURL,
+					Bandwidth:       representation.Bandwidth,
+					Codecs:          cmp.Or(representation.Codecs, set.Codecs),
+					MimeType:        cmp.Or(representation.MimeType, set.MimeType),
+					SamplingRate:    samplingRate,
+					Channels:        channels,
+					SegmentBase:     representation.SegmentBase,
+					SegmentTemplate: segmentTemplate,
+					SegmentList:     representation.SegmentList,
+				})
 			}
 		}
 	}
 	return result, nil
 }
 
+// parseFrameRate parses the frame rates like 30 or 15360/512. Zero is returned if it is invalid.
+func parseFrameRate(frameRate string) float64 {
+	numerator, denominator, isFraction := strings.Cut(frameRate, "/")
+	result, err := strconv.ParseFloat(numerator, 64)
+	if err != nil {
+		return 0
+	}
+	if isFraction {
+		divisor, err := strconv.ParseFloat(denominator, 64)
+		if err != nil || divisor == 0 {
+			return 0
+		}
+		result /= divisor
+	}
+	return result
+}
+
+// parseISO8601Duration parses the durations such as PT1H2M3.5S. Years, months and weeks are not supported.
+func parseISO8601Duration(duration string) (time.Duration, error) {
+	parts := durationRegex.FindStringSubmatch(duration)
+	if parts == nil || duration == "P" || strings.HasSuffix(duration, "T") {
+		return 0, errors.Errorf("invalid duration %q", duration)
+	}
+	var result time.Duration
+	units := []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second}
+	for i, unit := range units {
+		if parts[i+1] == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(parts[i+1], 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "invalid duration %q", duration)
+		}
+		result += time.Duration(value * float64(unit))
+	}
+	return result, nil
+}
+
 // ParseDashPlaylistFromID will parse the dash playlist file for a DASHPlaylist.mpd url
-func ParseDashPlaylistFromID(dashURL string) (AvailableMedia, error) {
+func ParseDashPlaylistFromID(ctx context.Context, dashURL string) (AvailableMedia, error) {
 	// Check if vidID is empty
 	if dashURL == "" {
 		return AvailableMedia{}, errors.New("empty vidID")
 	}
 	// Request the dash file
-	resp, err := common.GlobalHttpClient.Get(dashURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", dashURL, nil)
+	if err != nil {
+		return AvailableMedia{}, errors.Wrap(err, "cannot create request")
+	}
+	resp, err := common.GlobalHttpClient.Do(req)
 	if err != nil {
 		return AvailableMedia{}, errors.Wrap(err, "cannot get url")
 	}
@@ -141,14 +370,18 @@ func (s sortableVideoQualities) Len() int {
 func (s sortableVideoQualities) Less(i, j int) bool {
 	q1, _ := strconv.Atoi(s[i].Quality())
 	q2, _ := strconv.Atoi(s[j].Quality())
-	return q1 > q2
+	if q1 != q2 {
+		return q1 > q2
+	}
+	return s[i].Bandwidth > s[j].Bandwidth
 }
 
 func (s sortableVideoQualities) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 }
 
-// SortVideoQualities will sort the video qualities based on their qualities
+// SortVideoQualities will sort the video qualities based on their heights, from the best to the worst.
+// The videos with the same height are sorted by their bandwidth.
 func SortVideoQualities(videos []AvailableVideo) {
-	sort.Sort(sortableVideoQualities(videos))
+	sort.Stable(sortableVideoQualities(videos))
 }
@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestVideoQuality(t *testing.T) {
@@ -22,6 +23,16 @@ func TestVideoQuality(t *testing.T) {
 			Data:     AvailableVideo{BaseURL: "DASH_1080"},
 			Expected: "1080",
 		},
+		{
+			Name:     "height",
+			Data:     AvailableVideo{BaseURL: "CMAF_a1b2c3.mp4", Dimension: Dimension{Width: 1280, Height: 720}},
+			Expected: "720",
+		},
+		{
+			Name:     "portrait",
+			Data:     AvailableVideo{BaseURL: "CMAF_1920.mp4", Dimension: Dimension{Width: 1080, Height: 1920}},
+			Expected: "1080",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
@@ -46,6 +57,32 @@ func TestSortVideoQualities(t *testing.T) {
 			Input:    []AvailableVideo{{BaseURL: "DASH_220.mp4"}, {BaseURL: "DASH_240.mp4"}, {BaseURL: "DASH_480.mp4"}, {BaseURL: "blah"}},
 			Expected: []AvailableVideo{{BaseURL: "DASH_480.mp4"}, {BaseURL: "DASH_240.mp4"}, {BaseURL: "DASH_220.mp4"}, {BaseURL: "blah"}},
 		},
+		{
+			Name: "heights_and_bandwidths",
+			Input: []AvailableVideo{
+				{BaseURL: "CMAF_1.mp4", Dimension: Dimension{Height: 480}, Bandwidth: 900},
+				{BaseURL: "CMAF_2.mp4", Dimension: Dimension{Height: 1080}, Bandwidth: 3000},
+				{BaseURL: "CMAF_3.mp4", Dimension: Dimension{Height: 1080}, Bandwidth: 6000},
+			},
+			Expected: []AvailableVideo{
+				{BaseURL: "CMAF_3.mp4", Dimension: Dimension{Height: 1080}, Bandwidth: 6000},
+				{BaseURL: "CMAF_2.mp4", Dimension: Dimension{Height: 1080}, Bandwidth: 3000},
+				{BaseURL: "CMAF_1.mp4", Dimension: Dimension{Height: 480}, Bandwidth: 900},
+			},
+		},
+		{
+			Name: "portrait",
+			Input: []AvailableVideo{
+				{BaseURL: "CMAF_1.mp4", Dimension: Dimension{Width: 720, Height: 1280}, Bandwidth: 2000},
+				{BaseURL: "CMAF_2.mp4", Dimension: Dimension{Width: 1080, Height: 1920}, Bandwidth: 5000},
+				{BaseURL: "CMAF_3.mp4", Dimension: Dimension{Width: 480, Height: 854}, Bandwidth: 900},
+			},
+			Expected: []AvailableVideo{
+				{BaseURL: "CMAF_2.mp4", Dimension: Dimension{Width: 1080, Height: 1920}, Bandwidth: 5000},
+				{BaseURL: "CMAF_1.mp4", Dimension: Dimension{Width: 720, Height: 1280}, Bandwidth: 2000},
+				{BaseURL: "CMAF_3.mp4", Dimension: Dimension{Width: 480, Height: 854}, Bandwidth: 900},
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.Name, func(t *testing.T) {
@@ -56,6 +93,7 @@ func TestSortVideoQualities(t *testing.T) {
 }
 
 func TestStructParser(t *testing.T) {
+	startNumber := int64(1)
 	tests := []struct {
 		Name     string
 		Data     string
@@ -68,35 +106,105 @@ func TestStructParser(t *testing.T) {
 			Expected: AvailableMedia{
 				AvailableVideos: []AvailableVideo{
 					{
+						ID:      "1",
 						BaseURL: "DASH_220.mp4",
 						Dimension: Dimension{
 							Width:  266,
 							Height: 220,
 						},
+						Bandwidth: 188176,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "845-912",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-844"},
+						},
 					},
 					{
+						ID:      "2",
 						BaseURL: "DASH_270.mp4",
 						Dimension: Dimension{
 							Width:  328,
 							Height: 270,
 						},
+						Bandwidth: 245208,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "847-914",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-846"},
+						},
 					},
 					{
+						ID:      "3",
 						BaseURL: "DASH_360.mp4",
 						Dimension: Dimension{
 							Width:  436,
 							Height: 360,
 						},
+						Bandwidth: 360766,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "847-914",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-846"},
+						},
 					},
 					{
+						ID:      "4",
 						BaseURL: "DASH_480.mp4",
 						Dimension: Dimension{
 							Width:  582,
 							Height: 480,
 						},
+						Bandwidth: 528108,
+						Codecs:    "avc1.4d401f",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "847-914",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-846"},
+						},
 					},
 				},
-				AvailableAudios: []AvailableAudio{"DASH_AUDIO_64.mp4", "DASH_AUDIO_128.mp4"},
+				AvailableAudios: []AvailableAudio{
+					{
+						ID:           "5",
+						BaseURL:      "DASH_AUDIO_64.mp4",
+						Bandwidth:    67281,
+						Codecs:       "mp4a.40.2",
+						MimeType:     "audio/mp4",
+						SamplingRate: 48000,
+						Channels:     2,
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "820-887",
+							Timescale:      48000,
+							Initialization: DashSegmentInitialization{Range: "0-819"},
+						},
+					},
+					{
+						ID:           "6",
+						BaseURL:      "DASH_AUDIO_128.mp4",
+						Bandwidth:    134610,
+						Codecs:       "mp4a.40.2",
+						MimeType:     "audio/mp4",
+						SamplingRate: 48000,
+						Channels:     2,
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "820-887",
+							Timescale:      48000,
+							Initialization: DashSegmentInitialization{Range: "0-819"},
+						},
+					},
+				},
+				Duration: 13 * time.Second,
 			},
 		},
 		{ // From https://v.redd.it/dbelx9ulpacb1/DASHPlaylist.mpd
@@ -105,42 +213,108 @@ func TestStructParser(t *testing.T) {
 			Expected: AvailableMedia{
 				AvailableVideos: []AvailableVideo{
 					{
+						ID:      "1",
 						BaseURL: "DASH_220.mp4",
 						Dimension: Dimension{
 							Width:  124,
 							Height: 220,
 						},
+						Bandwidth: 89938,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "825-880",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-824"},
+						},
 					},
 					{
+						ID:      "2",
 						BaseURL: "DASH_240.mp4",
 						Dimension: Dimension{
 							Width:  136,
 							Height: 240,
 						},
+						Bandwidth: 96794,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "825-880",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-824"},
+						},
 					},
 					{
+						ID:      "3",
 						BaseURL: "DASH_360.mp4",
 						Dimension: Dimension{
 							Width:  202,
 							Height: 360,
 						},
+						Bandwidth: 159264,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "826-881",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-825"},
+						},
 					},
 					{
+						ID:      "4",
 						BaseURL: "DASH_480.mp4",
 						Dimension: Dimension{
 							Width:  270,
 							Height: 480,
 						},
+						Bandwidth: 225456,
+						Codecs:    "avc1.4d401f",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "824-879",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-823"},
+						},
 					},
 					{
+						ID:      "5",
 						BaseURL: "DASH_720.mp4",
 						Dimension: Dimension{
 							Width:  406,
 							Height: 720,
 						},
+						Bandwidth: 366750,
+						Codecs:    "avc1.4d401f",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "826-881",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-825"},
+						},
+					},
+				},
+				AvailableAudios: []AvailableAudio{
+					{
+						ID:           "7",
+						BaseURL:      "DASH_audio.mp4",
+						Bandwidth:    135442,
+						Codecs:       "mp4a.40.2",
+						MimeType:     "audio/mp4",
+						SamplingRate: 48000,
+						Channels:     2,
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "820-875",
+							Timescale:      48000,
+							Initialization: DashSegmentInitialization{Range: "0-819"},
+						},
 					},
 				},
-				AvailableAudios: []AvailableAudio{"DASH_audio.mp4"},
+				Duration: 9 * time.Second,
 			},
 		},
 		{ // From https://v.redd.it/jzsvg42m78eb1/DASHPlaylist.mpd
@@ -149,42 +323,93 @@ func TestStructParser(t *testing.T) {
 			Expected: AvailableMedia{
 				AvailableVideos: []AvailableVideo{
 					{
+						ID:      "1",
 						BaseURL: "DASH_220.mp4",
 						Dimension: Dimension{
 							Width:  392,
 							Height: 220,
 						},
+						Bandwidth: 263590,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "845-1248",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-844"},
+						},
 					},
 					{
+						ID:      "2",
 						BaseURL: "DASH_240.mp4",
 						Dimension: Dimension{
 							Width:  426,
 							Height: 240,
 						},
+						Bandwidth: 707294,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "845-1248",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-844"},
+						},
 					},
 					{
+						ID:      "3",
 						BaseURL: "DASH_360.mp4",
 						Dimension: Dimension{
 							Width:  640,
 							Height: 360,
 						},
+						Bandwidth: 916668,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "827-1230",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-826"},
+						},
 					},
 					{
+						ID:      "4",
 						BaseURL: "DASH_480.mp4",
 						Dimension: Dimension{
 							Width:  854,
 							Height: 480,
 						},
+						Bandwidth: 1398892,
+						Codecs:    "avc1.4d401f",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "847-1250",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-846"},
+						},
 					},
 					{
+						ID:      "5",
 						BaseURL: "DASH_720.mp4",
 						Dimension: Dimension{
 							Width:  1280,
 							Height: 720,
 						},
+						Bandwidth: 2790330,
+						Codecs:    "avc1.4d401f",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "825-1228",
+							Timescale:      15360,
+							Initialization: DashSegmentInitialization{Range: "0-824"},
+						},
 					},
 				},
 				AvailableAudios: nil,
+				Duration:        2*time.Minute + 5*time.Second,
 			},
 		},
 		{ // From https://v.redd.it/l81cm9bcwtp41/DASHPlaylist.mpd
@@ -193,35 +418,86 @@ func TestStructParser(t *testing.T) {
 			Expected: AvailableMedia{
 				AvailableVideos: []AvailableVideo{
 					{
+						ID:      "VIDEO-1",
 						BaseURL: "DASH_720",
 						Dimension: Dimension{
 							Width:  404,
 							Height: 720,
 						},
+						Bandwidth: 2264565,
+						Codecs:    "avc1.4d401f",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "978-1093",
+							Initialization: DashSegmentInitialization{Range: "0-977"},
+						},
 					},
 					{
+						ID:      "VIDEO-2",
 						BaseURL: "DASH_480",
 						Dimension: Dimension{
 							Width:  270,
 							Height: 480,
 						},
+						Bandwidth: 1138311,
+						Codecs:    "avc1.4d401f",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "975-1090",
+							Initialization: DashSegmentInitialization{Range: "0-974"},
+						},
 					},
 					{
+						ID:      "VIDEO-3",
 						BaseURL: "DASH_360",
 						Dimension: Dimension{
 							Width:  202,
 							Height: 360,
 						},
+						Bandwidth: 756236,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "978-1093",
+							Initialization: DashSegmentInitialization{Range: "0-977"},
+						},
 					},
 					{
+						ID:      "VIDEO-4",
 						BaseURL: "DASH_240",
 						Dimension: Dimension{
 							Width:  134,
 							Height: 240,
 						},
+						Bandwidth: 568151,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "978-1093",
+							Initialization: DashSegmentInitialization{Range: "0-977"},
+						},
+					},
+				},
+				AvailableAudios: []AvailableAudio{
+					{
+						ID:           "AUDIO-1",
+						BaseURL:      "audio",
+						Bandwidth:    130325,
+						Codecs:       "mp4a.40.2",
+						MimeType:     "audio/mp4",
+						SamplingRate: 48000,
+						Channels:     2,
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "892-995",
+							Initialization: DashSegmentInitialization{Range: "0-891"},
+						},
 					},
 				},
-				AvailableAudios: []AvailableAudio{"audio"},
+				Duration: 28500 * time.Millisecond,
 			},
 		},
 		{ // From https://v.redd.it/o8y2x0z8jsq41/DASHPlaylist.mpd
@@ -230,28 +506,163 @@ func TestStructParser(t *testing.T) {
 			Expected: AvailableMedia{
 				AvailableVideos: []AvailableVideo{
 					{
+						ID:      "VIDEO-1",
 						BaseURL: "DASH_480",
 						Dimension: Dimension{
 							Width:  480,
 							Height: 480,
 						},
+						Bandwidth: 1168724,
+						Codecs:    "avc1.4d401f",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "918-1045",
+							Initialization: DashSegmentInitialization{Range: "0-917"},
+						},
 					},
 					{
+						ID:      "VIDEO-2",
 						BaseURL: "DASH_360",
 						Dimension: Dimension{
 							Width:  360,
 							Height: 360,
 						},
+						Bandwidth: 780377,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "919-1046",
+							Initialization: DashSegmentInitialization{Range: "0-918"},
+						},
 					},
 					{
+						ID:      "VIDEO-3",
 						BaseURL: "DASH_240",
 						Dimension: Dimension{
 							Width:  240,
 							Height: 240,
 						},
+						Bandwidth: 589445,
+						Codecs:    "avc1.4d401e",
+						FrameRate: 30,
+						MimeType:  "video/mp4",
+						SegmentBase: &DashSegmentBase{
+							IndexRange:     "917-1044",
+							Initialization: DashSegmentInitialization{Range: "0-916"},
+						},
 					},
 				},
 				AvailableAudios: nil,
+				Duration:        30900 * time.Millisecond,
+			},
+		},
+		{
+			Name: "segment_template",
+			Data: `<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" mediaPresentationDuration="PT6S" type="static">
+  <Period>
+    <AdaptationSet mimeType="video/mp4" codecs="avc1.64001f">
+      <SegmentTemplate initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/$Number$.m4s" startNumber="1" timescale="1000">
+        <SegmentTimeline>
+          <S t="0" d="4000" />
+          <S d="2000" />
+        </SegmentTimeline>
+      </SegmentTemplate>
+      <Representation id="low" bandwidth="500000" width="640" height="360" frameRate="60000/1001" />
+      <Representation id="high" bandwidth="2000000" width="1280" height="720" frameRate="60" />
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <Representation id="audio" bandwidth="128000" codecs="mp4a.40.2" audioSamplingRate="44100">
+        <AudioChannelConfiguration value="1" />
+        <SegmentTemplate initialization="audio/init.mp4" media="audio/$Number$.m4s" startNumber="1" timescale="1000" duration="2000" />
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`,
+			Expected: AvailableMedia{
+				AvailableVideos: []AvailableVideo{
+					{
+						ID:        "low",
+						Dimension: Dimension{Width: 640, Height: 360},
+						Bandwidth: 500000,
+						Codecs:    "avc1.64001f",
+						FrameRate: 60000.0 / 1001,
+						MimeType:  "video/mp4",
+						SegmentTemplate: &DashSegmentTemplate{
+							Initialization: "$RepresentationID$/init.mp4",
+							Media:          "$RepresentationID$/$Number$.m4s",
+							StartNumber:    &startNumber,
+							Timescale:      1000,
+							Timeline:       []DashSegmentTimelineEntry{{T: new(int64), D: 4000}, {D: 2000}},
+						},
+					},
+					{
+						ID:        "high",
+						Dimension: Dimension{Width: 1280, Height: 720},
+						Bandwidth: 2000000,
+						Codecs:    "avc1.64001f",
+						FrameRate: 60,
+						MimeType:  "video/mp4",
+						SegmentTemplate: &DashSegmentTemplate{
+							Initialization: "$RepresentationID$/init.mp4",
+							Media:          "$RepresentationID$/$Number$.m4s",
+							StartNumber:    &startNumber,
+							Timescale:      1000,
+							Timeline:       []DashSegmentTimelineEntry{{T: new(int64), D: 4000}, {D: 2000}},
+						},
+					},
+				},
+				AvailableAudios: []AvailableAudio{
+					{
+						ID:           "audio",
+						Bandwidth:    128000,
+						Codecs:       "mp4a.40.2",
+						MimeType:     "audio/mp4",
+						SamplingRate: 44100,
+						Channels:     1,
+						SegmentTemplate: &DashSegmentTemplate{
+							Initialization: "audio/init.mp4",
+							Media:          "audio/$Number$.m4s",
+							StartNumber:    &startNumber,
+							Timescale:      1000,
+							Duration:       2000,
+						},
+					},
+				},
+				Duration: 6 * time.Second,
+			},
+		},
+		{
+			Name: "segment_list",
+			Data: `<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" mediaPresentationDuration="PT4S" type="static">
+  <Period>
+    <AdaptationSet contentType="video">
+      <Representation id="video" bandwidth="500000" width="640" height="360">
+        <BaseURL>DASH_360.mp4</BaseURL>
+        <SegmentList>
+          <Initialization range="0-844" />
+          <SegmentURL mediaRange="845-5000" />
+          <SegmentURL media="DASH_360_2.m4s" />
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`,
+			Expected: AvailableMedia{
+				AvailableVideos: []AvailableVideo{
+					{
+						ID:        "video",
+						BaseURL:   "DASH_360.mp4",
+						Dimension: Dimension{Width: 640, Height: 360},
+						Bandwidth: 500000,
+						SegmentList: &DashSegmentList{
+							Initialization: &DashSegmentInitialization{Range: "0-844"},
+							Segments:       []DashSegmentURL{{MediaRange: "845-5000"}, {Media: "DASH_360_2.m4s"}},
+						},
+					},
+				},
+				Duration: 4 * time.Second,
 			},
 		},
 	}
@@ -263,3 +674,95 @@ func TestStructParser(t *testing.T) {
 		})
 	}
 }
+
+func TestParseISO8601Duration(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Data     string
+		Expected time.Duration
+		Error    bool
+	}{
+		{Name: "seconds", Data: "PT13S", Expected: 13 * time.Second},
+		{Name: "fraction", Data: "PT28.5S", Expected: 28500 * time.Millisecond},
+		{Name: "minutes", Data: "PT2M5S", Expected: 2*time.Minute + 5*time.Second},
+		{Name: "hours", Data: "PT1H0M1.25S", Expected: time.Hour + 1250*time.Millisecond},
+		{Name: "days", Data: "P1DT1H", Expected: 25 * time.Hour},
+		{Name: "empty", Data: "", Error: true},
+		{Name: "no_parts", Data: "PT", Error: true},
+		{Name: "invalid", Data: "13 seconds", Error: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			got, err := parseISO8601Duration(test.Data)
+			if test.Error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.Expected, got)
+		})
+	}
+}
+
+func TestVideoLabel(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Data     AvailableVideo
+		Expected string
+	}{
+		{Name: "unknown_frame_rate", Data: AvailableVideo{BaseURL: "DASH_720.mp4"}, Expected: "720p"},
+		{Name: "30fps", Data: AvailableVideo{Dimension: Dimension{Height: 1080}, FrameRate: 30}, Expected: "1080p"},
+		{Name: "60fps", Data: AvailableVideo{Dimension: Dimension{Height: 1080}, FrameRate: 60000.0 / 1001}, Expected: "1080p60"},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, test.Data.Label())
+		})
+	}
+}
+
+func TestParseFrameRate(t *testing.T) {
+	tests := []struct {
+		Data     string
+		Expected float64
+	}{
+		{"30", 30},
+		{"15360/512", 30},
+		{"30000/1001", 30000.0 / 1001},
+		{"30/0", 0},
+		{"", 0},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.Expected, parseFrameRate(test.Data), test.Data)
+	}
+}
+
+func TestBestAudio(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Data     AvailableMedia
+		Expected string
+		Has      bool
+	}{
+		{Name: "none", Data: AvailableMedia{}},
+		{
+			Name:     "bandwidth",
+			Data:     AvailableMedia{AvailableAudios: []AvailableAudio{{BaseURL: "high", Bandwidth: 128000}, {BaseURL: "low", Bandwidth: 64000}}},
+			Expected: "high",
+			Has:      true,
+		},
+		{
+			Name:     "unknown_bandwidth",
+			Data:     AvailableMedia{AvailableAudios: []AvailableAudio{{BaseURL: "DASH_AUDIO_64.mp4"}, {BaseURL: "DASH_AUDIO_128.mp4"}}},
+			Expected: "DASH_AUDIO_128.mp4",
+			Has:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			audio, has := test.Data.BestAudio()
+			assert.Equal(t, test.Has, has)
+			assert.Equal(t, test.Expected, audio.BaseURL)
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package reddit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-faster/errors"
+	"math"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dashRepresentationKey is the key in the fragment of the links of segmented DASH representations
+// which holds the ID of the representation
+const dashRepresentationKey = "representation"
+
+// maxDashSegments is the maximum number of segments which are created for a representation.
+// It protects us from playlists with absurd durations.
+const maxDashSegments = 100_000
+
+// dashTemplateRegex matches the identifiers of the segment templates like $Number%05d$ or $$
+var dashTemplateRegex = regexp.MustCompile(`\$(RepresentationID|Number|Time|Bandwidth)?(%0\d+d)?\$`)
+
+// dashRepresentation is the part of a video or audio representation which is needed to download it
+type dashRepresentation struct {
+	ID              string
+	BaseURL         string
+	Bandwidth       int64
+	SegmentTemplate *DashSegmentTemplate
+	SegmentList     *DashSegmentList
+}
+
+// segmented checks if the representation is split into segments instead of being a single file at its BaseURL
+func (r dashRepresentation) segmented() bool {
+	return r.SegmentTemplate != nil || r.SegmentList != nil
+}
+
+// representation gets the downloadable parts of the video
+func (v AvailableVideo) representation() dashRepresentation {
+	return dashRepresentation{
+		ID:              v.ID,
+		BaseURL:         v.BaseURL,
+		Bandwidth:       v.Bandwidth,
+		SegmentTemplate: v.SegmentTemplate,
+		SegmentList:     v.SegmentList,
+	}
+}
+
+// representation gets the downloadable parts of the audio
+func (a AvailableAudio) representation() dashRepresentation {
+	return dashRepresentation{
+		ID:              a.ID,
+		BaseURL:         a.BaseURL,
+		Bandwidth:       a.Bandwidth,
+		SegmentTemplate: a.SegmentTemplate,
+		SegmentList:     a.SegmentList,
+	}
+}
+
+// findRepresentation finds a video or audio representation by its ID
+func (m AvailableMedia) findRepresentation(id string) (dashRepresentation, bool) {
+	for _, video := range m.AvailableVideos {
+		if video.ID == id {
+			return video.representation(), true
+		}
+	}
+	for _, audio := range m.AvailableAudios {
+		if audio.ID == id {
+			return audio.representation(), true
+		}
+	}
+	return dashRepresentation{}, false
+}
+
+// dashRepresentationLink creates the link of a segmented representation of a DASH playlist.
+// It is the link of the playlist with the ID of the representation in its fragment, so the
+// downloader can find the segments in the playlist.
+func dashRepresentationLink(playlistURL, id string) string {
+	return playlistURL + "#" + url.Values{dashRepresentationKey: {id}}.Encode()
+}
+
+// isDASHLink checks if a link is a segmented representation of a DASH playlist
+func isDASHLink(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && strings.HasSuffix(u.Path, ".mpd")
+}
+
+// dashSegments gets the initialization segment and the media segments of a segmented representation.
+// The relative links are resolved against base, which is the link of the playlist.
+// duration is the duration of the playlist which is needed for the templates without a timeline.
+func dashSegments(representation dashRepresentation, base *url.URL, duration time.Duration) ([]mediaSegment, error) {
+	if representation.BaseURL != "" {
+		if u, err := url.Parse(resolvePlaylistLink(base, representation.BaseURL)); err == nil {
+			base = u
+		}
+	}
+	switch {
+	case representation.SegmentList != nil:
+		return dashListSegments(*representation.SegmentList, base)
+	case representation.SegmentTemplate != nil:
+		return dashTemplateSegments(representation, *representation.SegmentTemplate, base, duration)
+	}
+	return nil, errors.New("the representation is not segmented")
+}
+
+// dashListSegments gets the segments of a SegmentList
+func dashListSegments(list DashSegmentList, base *url.URL) ([]mediaSegment, error) {
+	if len(list.Segments) == 0 {
+		return nil, errors.New("the segment list is empty")
+	}
+	segments := make([]mediaSegment, 0, len(list.Segments)+1)
+	if list.Initialization != nil {
+		segments = append(segments, dashSegment(base, list.Initialization.SourceURL, list.Initialization.Range))
+	}
+	for _, segment := range list.Segments {
+		segments = append(segments, dashSegment(base, segment.Media, segment.MediaRange))
+	}
+	return segments, nil
+}
+
+// dashSegment creates a segment from a link relative to base and a byte range like 0-844.
+// An empty link means base itself.
+func dashSegment(base *url.URL, link, byteRange string) mediaSegment {
+	segment := mediaSegment{Link: base.String()}
+	if link != "" {
+		segment.Link = resolvePlaylistLink(base, link)
+	}
+	if byteRange != "" {
+		segment.Range = "bytes=" + byteRange
+	}
+	return segment
+}
+
+// dashTemplateSegments creates the links of the segments of a SegmentTemplate
+func dashTemplateSegments(representation dashRepresentation, template DashSegmentTemplate, base *url.URL, duration time.Duration) ([]mediaSegment, error) {
+	if template.Media == "" {
+		return nil, errors.New("the segment template has no media")
+	}
+	number := int64(1)
+	if template.StartNumber != nil {
+		number = *template.StartNumber
+	}
+	timescale := template.Timescale
+	if timescale <= 0 {
+		timescale = 1
+	}
+	end := int64(math.Ceil(duration.Seconds() * float64(timescale)))
+	// The start time of each segment in timescale
+	var times []int64
+	switch {
+	case len(template.Timeline) > 0:
+		var t int64
+		for i, entry := range template.Timeline {
+			if entry.T != nil {
+				t = *entry.T
+			}
+			if entry.D <= 0 {
+				return nil, errors.New("invalid segment duration")
+			}
+			repeat := entry.R
+			if repeat < 0 {
+				// Repeat until the next entry or the end of the playlist
+				until := end
+				if i+1 < len(template.Timeline) && template.Timeline[i+1].T != nil {
+					until = *template.Timeline[i+1].T
+				}
+				repeat = (until-t+entry.D-1)/entry.D - 1
+			}
+			for range repeat + 1 {
+				if len(times) >= maxDashSegments {
+					return nil, errors.New("too many segments")
+				}
+				times = append(times, t)
+				t += entry.D
+			}
+		}
+	case template.Duration > 0:
+		if end <= 0 {
+			return nil, errors.New("the duration of the playlist is unknown")
+		}
+		count := (end + template.Duration - 1) / template.Duration
+		if count > maxDashSegments {
+			return nil, errors.New("too many segments")
+		}
+		times = make([]int64, count)
+		for i := range times {
+			times[i] = int64(i) * template.Duration
+		}
+	default:
+		return nil, errors.New("the segment template has no timeline or duration")
+	}
+	segments := make([]mediaSegment, 0, len(times)+1)
+	if template.Initialization != "" {
+		link := fillDashTemplate(template.Initialization, representation, 0, 0)
+		segments = append(segments, dashSegment(base, link, ""))
+	}
+	for i, t := range times {
+		link := fillDashTemplate(template.Media, representation, number+int64(i), t)
+		segments = append(segments, dashSegment(base, link, ""))
+	}
+	return segments, nil
+}
+
+// fillDashTemplate replaces the identifiers of a segment template like $Number$ with their values
+func fillDashTemplate(template string, representation dashRepresentation, number, startTime int64) string {
+	return dashTemplateRegex.ReplaceAllStringFunc(template, func(identifier string) string {
+		parts := dashTemplateRegex.FindStringSubmatch(identifier)
+		format := "%d"
+		if parts[2] != "" {
+			format = parts[2]
+		}
+		switch parts[1] {
+		case "":
+			if parts[2] != "" { // Not a valid identifier
+				return identifier
+			}
+			return "$"
+		case "RepresentationID":
+			return representation.ID
+		case "Number":
+			return fmt.Sprintf(format, number)
+		case "Time":
+			return fmt.Sprintf(format, startTime)
+		case "Bandwidth":
+			return fmt.Sprintf(format, representation.Bandwidth)
+		}
+		return identifier
+	})
+}
+
+// downloadDASH downloads a segmented representation of a DASH playlist to f. The link is created
+// with dashRepresentationLink. The initialization segment and the media segments are concatenated,
+// which creates a fragmented MP4.
+func (o *Oauth) downloadDASH(ctx context.Context, link string, f *os.File, phase ProgressPhase, maxSize int64) error {
+	u, err := url.Parse(link)
+	if err != nil {
+		return errors.Wrap(err, "invalid link")
+	}
+	fragment, _ := url.ParseQuery(u.Fragment)
+	id := fragment.Get(dashRepresentationKey)
+	if id == "" {
+		return errors.New("no representation in the link")
+	}
+	u.Fragment = ""
+	body, base, err := getPlaylist(ctx, u.String())
+	if err != nil {
+		return err
+	}
+	media, err := parseDashPlaylist(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	representation, exists := media.findRepresentation(id)
+	if !exists {
+		return errors.Errorf("representation %q does not exist", id)
+	}
+	segments, err := dashSegments(representation, base, media.Duration)
+	if err != nil {
+		return errors.Wrapf(err, "cannot get the segments of %q", id)
+	}
+	return o.downloadSegments(ctx, segments, f, phase, maxSize)
+}
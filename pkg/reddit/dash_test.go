@@ -0,0 +1,175 @@
+package reddit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFillDashTemplate(t *testing.T) {
+	representation := dashRepresentation{ID: "video_720", Bandwidth: 2000000}
+	tests := []struct {
+		Name     string
+		Template string
+		Expected string
+	}{
+		{Name: "number", Template: "$RepresentationID$/$Number$.m4s", Expected: "video_720/7.m4s"},
+		{Name: "padded_number", Template: "seg-$Number%05d$.m4s", Expected: "seg-00007.m4s"},
+		{Name: "time_bandwidth", Template: "$Bandwidth$/$Time$.m4s", Expected: "2000000/12000.m4s"},
+		{Name: "escaped_dollar", Template: "a$$b-$Number$.m4s", Expected: "a$b-7.m4s"},
+		{Name: "unknown", Template: "$Unknown$.m4s", Expected: "$Unknown$.m4s"},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, fillDashTemplate(test.Template, representation, 7, 12000))
+		})
+	}
+}
+
+func TestDashSegments(t *testing.T) {
+	base, _ := url.Parse("https://v.redd.it/abc/DASHPlaylist.mpd?a=1")
+	startNumber := int64(0)
+	tests := []struct {
+		Name           string
+		Representation dashRepresentation
+		Duration       time.Duration
+		Expected       []mediaSegment
+		Error          bool
+	}{
+		{
+			Name: "timeline",
+			Representation: dashRepresentation{
+				ID: "720",
+				SegmentTemplate: &DashSegmentTemplate{
+					Initialization: "$RepresentationID$/init.mp4",
+					Media:          "$RepresentationID$/$Time$.m4s",
+					Timescale:      1000,
+					Timeline:       []DashSegmentTimelineEntry{{T: new(int64), D: 2000, R: 1}, {D: 1500}},
+				},
+			},
+			Expected: []mediaSegment{
+				{Link: "https://v.redd.it/abc/720/init.mp4"},
+				{Link: "https://v.redd.it/abc/720/0.m4s"},
+				{Link: "https://v.redd.it/abc/720/2000.m4s"},
+				{Link: "https://v.redd.it/abc/720/4000.m4s"},
+			},
+		},
+		{
+			Name: "timeline_repeat_until_end",
+			Representation: dashRepresentation{
+				ID: "720",
+				SegmentTemplate: &DashSegmentTemplate{
+					Media:       "$Number$.m4s",
+					StartNumber: &startNumber,
+					Timescale:   10,
+					Timeline:    []DashSegmentTimelineEntry{{D: 20, R: -1}},
+				},
+			},
+			Duration: 5 * time.Second,
+			Expected: []mediaSegment{
+				{Link: "https://v.redd.it/abc/0.m4s"},
+				{Link: "https://v.redd.it/abc/1.m4s"},
+				{Link: "https://v.redd.it/abc/2.m4s"},
+			},
+		},
+		{
+			Name: "duration",
+			Representation: dashRepresentation{
+				ID:      "audio",
+				BaseURL: "audio/",
+				SegmentTemplate: &DashSegmentTemplate{
+					Initialization: "init.mp4",
+					Media:          "$Number%03d$.m4s",
+					Timescale:      1000,
+					Duration:       2000,
+				},
+			},
+			Duration: 5 * time.Second,
+			Expected: []mediaSegment{
+				{Link: "https://v.redd.it/abc/audio/init.mp4"},
+				{Link: "https://v.redd.it/abc/audio/001.m4s"},
+				{Link: "https://v.redd.it/abc/audio/002.m4s"},
+				{Link: "https://v.redd.it/abc/audio/003.m4s"},
+			},
+		},
+		{
+			Name: "duration_unknown_length",
+			Representation: dashRepresentation{
+				SegmentTemplate: &DashSegmentTemplate{Media: "$Number$.m4s", Duration: 2},
+			},
+			Error: true,
+		},
+		{
+			Name: "list",
+			Representation: dashRepresentation{
+				BaseURL: "DASH_720.mp4",
+				SegmentList: &DashSegmentList{
+					Initialization: &DashSegmentInitialization{Range: "0-844"},
+					Segments:       []DashSegmentURL{{MediaRange: "845-5000"}, {Media: "https://cdn.example.com/2.m4s"}},
+				},
+			},
+			Expected: []mediaSegment{
+				{Link: "https://v.redd.it/abc/DASH_720.mp4", Range: "bytes=0-844"},
+				{Link: "https://v.redd.it/abc/DASH_720.mp4", Range: "bytes=845-5000"},
+				{Link: "https://cdn.example.com/2.m4s"},
+			},
+		},
+		{
+			Name:           "not_segmented",
+			Representation: dashRepresentation{BaseURL: "DASH_720.mp4"},
+			Error:          true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			segments, err := dashSegments(test.Representation, base, test.Duration)
+			if test.Error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.Expected, segments)
+		})
+	}
+}
+
+func TestDownloadDASH(t *testing.T) {
+	const playlist = `<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" mediaPresentationDuration="PT3S" type="static">
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/$Number$.m4s" startNumber="1" timescale="1" duration="1" />
+      <Representation id="low" bandwidth="500000" width="640" height="360" />
+      <Representation id="high" bandwidth="2000000" width="1280" height="720" />
+    </AdaptationSet>
+  </Period>
+</MPD>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".mpd") {
+			_, _ = w.Write([]byte(playlist))
+			return
+		}
+		_, _ = w.Write([]byte(r.URL.Path + ";"))
+	}))
+	defer server.Close()
+	link := dashRepresentationLink(server.URL+"/abc/DASHPlaylist.mpd", "high")
+	assert.True(t, isDASHLink(link))
+	assert.False(t, isDASHLink(server.URL+"/abc/DASH_720.mp4"))
+	f, err := os.CreateTemp("", "dash*.mp4")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	o := new(Oauth)
+	err = o.downloadMedia(context.Background(), link, f, ProgressPhaseDownloading, 1000)
+	assert.NoError(t, err)
+	data, _ := os.ReadFile(f.Name())
+	assert.Equal(t, "/abc/high/init.mp4;/abc/high/1.m4s;/abc/high/2.m4s;/abc/high/3.m4s;", string(data))
+	// Unknown representation
+	err = o.downloadDASH(context.Background(), dashRepresentationLink(server.URL+"/abc/DASHPlaylist.mpd", "none"), f, ProgressPhaseDownloading, 1000)
+	assert.Error(t, err)
+}
@@ -1,25 +1,33 @@
 package reddit
 
 import (
+	"RedditDownloaderBot/pkg/logging"
+	"RedditDownloaderBot/pkg/metrics"
 	"RedditDownloaderBot/pkg/util"
 	"bytes"
+	"context"
 	"github.com/go-faster/errors"
-	"log"
 	"net/url"
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
+	"time"
 )
 
-// We don't download anything more than this size
-const maxDownloadSize = 50 * 1000 * 1000
+// defaultMaxDownloadSize is the default of Options.MaxDownloadSize
+const defaultMaxDownloadSize = 50 * 1000 * 1000
+
+// MaxSplittableVideoSize is the maximum size of videos and their audio which are split into parts.
+// Videos larger than Options.MaxDownloadSize are only downloaded if ffmpeg is installed, so they can be split.
+const MaxSplittableVideoSize = 200 * 1000 * 1000
 
 // FileTooBigError indicates that this file is too big to be uploaded to Telegram
 // So we don't download it at first place
 var FileTooBigError = errors.New("The file is too large.")
 
 // DownloadPhoto downloads a photo from reddit and returns the saved file in it
-func (o *Oauth) DownloadPhoto(link string) (*os.File, error) {
+func (o *Oauth) DownloadPhoto(ctx context.Context, link string) (*os.File, error) {
 	// Get the file name
 	var fileName string
 	{
@@ -30,12 +38,12 @@ func (o *Oauth) DownloadPhoto(link string) (*os.File, error) {
 		fileName = u.Path[1:]
 	}
 	// Generate a temp file
-	tmpFile, err := os.CreateTemp("", "*."+fileName)
+	tmpFile, err := os.CreateTemp(o.tempDir, "*."+fileName)
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to create a temporary file")
 	}
 	// Download the file
-	err = o.downloadToFile(link, tmpFile)
+	err = o.downloadToFile(ctx, link, tmpFile, ProgressPhaseDownloading, o.maxDownloadSize)
 	if err != nil {
 		_ = os.Remove(tmpFile.Name())
 		return nil, errors.Wrap(err, "Unable to download the file")
@@ -44,11 +52,15 @@ func (o *Oauth) DownloadPhoto(link string) (*os.File, error) {
 	return tmpFile, nil
 }
 
-// DownloadVideo downloads a video from reddit
+// DownloadVideo downloads a video from reddit. The links can also be HLS playlists.
 // If necessary, it will merge the audio and video with ffmpeg
-func (o *Oauth) DownloadVideo(vidUrl, audioUrl string) (videoFile *os.File, err error) {
+func (o *Oauth) DownloadVideo(ctx context.Context, vidUrl, audioUrl string) (videoFile *os.File, err error) {
+	// Some HLS variants have their own audio
+	if variantURL, variantAudio, ok := hlsVariantAudio(vidUrl); ok {
+		vidUrl, audioUrl = variantURL, variantAudio
+	}
 	// Download the video in a temp file
-	videoFile, err = os.CreateTemp("", "*.mp4")
+	videoFile, err = os.CreateTemp(o.tempDir, "*.mp4")
 	if err != nil {
 		err = errors.Wrap(err, "Unable to create a temporary file for the video")
 		return
@@ -60,14 +72,18 @@ func (o *Oauth) DownloadVideo(vidUrl, audioUrl string) (videoFile *os.File, err
 			_ = os.Remove(videoFile.Name())
 		}
 	}()
-	err = o.downloadToFile(vidUrl, videoFile)
+	maxVideoSize := o.maxDownloadSize
+	if util.DoesFfmpegExists() {
+		maxVideoSize = max(maxVideoSize, MaxSplittableVideoSize)
+	}
+	err = o.downloadMedia(ctx, vidUrl, videoFile, ProgressPhaseDownloading, maxVideoSize)
 	if err != nil {
 		err = errors.Wrap(err, "Unable to download the file")
 		return
 	}
 	// Otherwise, search for an audio file
 	hasAudio := audioUrl != ""
-	audFile, err := os.CreateTemp("", "*.mp4")
+	audFile, err := os.CreateTemp(o.tempDir, "*.mp4")
 	if err != nil {
 		err = errors.Wrap(err, "Unable to create a temporary file for the audio")
 		return
@@ -78,10 +94,15 @@ func (o *Oauth) DownloadVideo(vidUrl, audioUrl string) (videoFile *os.File, err
 		_ = os.Remove(audFile.Name())
 	}()
 	if hasAudio {
-		if o.downloadToFile(audioUrl, audFile) != nil {
+		if o.downloadMedia(ctx, audioUrl, audFile, ProgressPhaseDownloadingAudio, maxVideoSize) != nil {
 			audioUrl = ""
 			hasAudio = false
 		}
+		// Do not send the video without audio if we are canceled
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			return
+		}
 	}
 	// Check ffmpeg; If it doesn't exist, just return the video file
 	if !util.DoesFfmpegExists() {
@@ -91,27 +112,36 @@ func (o *Oauth) DownloadVideo(vidUrl, audioUrl string) (videoFile *os.File, err
 	if hasAudio {
 		var finalFile *os.File
 		// Convert
-		finalFile, err = os.CreateTemp("", "*.mp4")
+		finalFile, err = os.CreateTemp(o.tempDir, "*.mp4")
 		if err != nil {
 			err = errors.Wrap(err, "Unable to create a temporary file for the converted video")
 			return
 		}
-		cmd := exec.Command("ffmpeg",
+		reportProgress(ctx, ProgressPhaseMerging, 0, -1)
+		cmd := exec.CommandContext(ctx, "ffmpeg",
 			"-i", videoFile.Name(),
 			"-i", audFile.Name(),
 			"-c", "copy",
 			finalFile.Name(), "-y")
 		var stderr bytes.Buffer
 		cmd.Stderr = &stderr
+		mergeStartTime := time.Now()
 		err = cmd.Run()
 		if err != nil {
-			log.Println("Unable to convert the video:", err, "\n", stderr.String())
 			_ = finalFile.Close()
 			_ = os.Remove(finalFile.Name())
+			if ctx.Err() != nil {
+				err = ctx.Err()
+				return
+			}
+			metrics.FfmpegMergeFailures.Inc()
+			logging.FromContext(ctx).Warn("Unable to merge the video and audio", "video", vidUrl, "error", err, "stderr", stderr.String())
+			reportError(ctx, "ffmpeg_merge", errors.Wrapf(err, "cannot merge %s with its audio: %s", vidUrl, lastLines(stderr.String(), 5)))
 			// We don't return error here
 			err = nil
 			return videoFile, nil
 		}
+		metrics.FfmpegMergeDuration.Observe(time.Since(mergeStartTime).Seconds())
 		// If we have reached here, it means that the conversion was fine
 		// So we swap the final file with video file and delete the video file
 		_ = videoFile.Close()
@@ -124,12 +154,12 @@ func (o *Oauth) DownloadVideo(vidUrl, audioUrl string) (videoFile *os.File, err
 }
 
 // DownloadGif downloads a gif from reddit
-func (o *Oauth) DownloadGif(link string) (*os.File, error) {
-	tmpFile, err := os.CreateTemp("", "*.mp4")
+func (o *Oauth) DownloadGif(ctx context.Context, link string) (*os.File, error) {
+	tmpFile, err := os.CreateTemp(o.tempDir, "*.mp4")
 	if err != nil {
 		return nil, err
 	}
-	err = o.downloadToFile(link, tmpFile)
+	err = o.downloadToFile(ctx, link, tmpFile, ProgressPhaseDownloading, o.maxDownloadSize)
 	if err != nil {
 		_ = tmpFile.Close()
 		_ = os.Remove(tmpFile.Name())
@@ -139,14 +169,14 @@ func (o *Oauth) DownloadGif(link string) (*os.File, error) {
 }
 
 // DownloadThumbnail is basically DownloadPhoto but without the filename
-func (o *Oauth) DownloadThumbnail(link string) (*os.File, error) {
-	tmpFile, err := os.CreateTemp("", "*.jpg")
+func (o *Oauth) DownloadThumbnail(ctx context.Context, link string) (*os.File, error) {
+	tmpFile, err := os.CreateTemp(o.tempDir, "*.jpg")
 	if err != nil {
-		log.Println("Unable to create a temporary file for the thumbnail:", err)
+		logging.FromContext(ctx).Error("Unable to create a temporary file for the thumbnail", "error", err)
 		return nil, err
 	}
 	// Download to file
-	err = o.downloadToFile(link, tmpFile)
+	err = o.downloadToFile(ctx, link, tmpFile, progressPhaseNone, o.maxDownloadSize) // thumbnails are too small to report
 	if err != nil {
 		_ = tmpFile.Close()
 		_ = os.Remove(tmpFile.Name())
@@ -157,14 +187,14 @@ func (o *Oauth) DownloadThumbnail(link string) (*os.File, error) {
 }
 
 // DownloadAudio simply downloads an audio file from reddit via direct link
-func (o *Oauth) DownloadAudio(audioUrl string) (*os.File, error) {
-	tmpFile, err := os.CreateTemp("", "*.m4a")
+func (o *Oauth) DownloadAudio(ctx context.Context, audioUrl string) (*os.File, error) {
+	tmpFile, err := os.CreateTemp(o.tempDir, "*.m4a")
 	if err != nil {
-		log.Println("Unable to create a temporary file for the audio:", err)
+		logging.FromContext(ctx).Error("Unable to create a temporary file for the audio", "error", err)
 		return nil, err
 	}
 	// Download to file
-	err = o.downloadToFile(audioUrl, tmpFile)
+	err = o.downloadMedia(ctx, audioUrl, tmpFile, ProgressPhaseDownloading, o.maxDownloadSize)
 	if err != nil {
 		_ = tmpFile.Close()
 		_ = os.Remove(tmpFile.Name())
@@ -209,3 +239,12 @@ func GetVideoDimensions(filename string) (Dimension, error) {
 	}
 	return result, nil
 }
+
+// lastLines returns the last n lines of the output of a command
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,226 @@
+package reddit
+
+import (
+	"RedditDownloaderBot/pkg/util"
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/go-faster/errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The bitrate of the audio of re-encoded videos in bits per second
+const reencodeAudioBitrate = 96 * 1000
+
+// The minimum bitrate of the video of re-encoded videos. Videos which need a lower bitrate to
+// fit are not re-encoded because the result would be unwatchable.
+const minReencodeVideoBitrate = 150 * 1000
+
+// reencodeSizeFactor is the fraction of the size limit which the re-encoded videos aim for.
+// The rest is left for the container and the inaccuracy of the rate control of the encoder.
+const reencodeSizeFactor = 0.93
+
+// reencodeHeights are the maximum heights of the re-encoded videos for each minimum video
+// bitrate. Low bitrates look better with smaller frames.
+var reencodeHeights = []struct {
+	minBitrate int64
+	maxHeight  int64
+}{
+	{2500 * 1000, 1080},
+	{1200 * 1000, 720},
+	{600 * 1000, 480},
+	{0, 360},
+}
+
+// ReencodeTimeoutError is returned when re-encoding a video takes longer than the timeout
+var ReencodeTimeoutError = errors.New("re-encoding the video took too long")
+
+// ReencodedVideo is the result of ReencodeVideo
+type ReencodedVideo struct {
+	// The re-encoded video. The caller must close and delete it.
+	File *os.File
+	// The size of the file in bytes
+	Size int64
+	// The dimension of the video. It is empty if it could not be determined.
+	Dimension Dimension
+	// The bitrate of the video stream in bits per second
+	VideoBitrate int64
+}
+
+// ReencodeVideo re-encodes a video with a lower bitrate so that it is smaller than maxSize.
+// If duration is not positive, it is determined with ffprobe. The video is scaled down if the
+// bitrate is too low for its resolution. Two-pass encoding is used unless the first pass fails.
+//
+// At most Options.ReencodeWorkers videos are re-encoded at once; others wait for their turn.
+// If re-encoding takes longer than Options.ReencodeTimeout, ReencodeTimeoutError is returned.
+func (o *Oauth) ReencodeVideo(ctx context.Context, filename string, duration float64, maxSize int64) (ReencodedVideo, error) {
+	if !util.DoesFfmpegExists() {
+		return ReencodedVideo{}, errors.New("ffmpeg is not installed")
+	}
+	var err error
+	if duration <= 0 {
+		duration, err = GetVideoDuration(filename)
+		if err != nil {
+			return ReencodedVideo{}, err
+		}
+	}
+	videoBitrate := int64(float64(maxSize)*8*reencodeSizeFactor/duration) - reencodeAudioBitrate
+	if videoBitrate < minReencodeVideoBitrate {
+		return ReencodedVideo{}, errors.New("the video is too long to fit in the size limit")
+	}
+	// Wait for our turn
+	reportProgress(ctx, ProgressPhaseWaitingToEncode, 0, -1)
+	select {
+	case o.reencodeSemaphore <- struct{}{}:
+		defer func() { <-o.reencodeSemaphore }()
+	case <-ctx.Done():
+		return ReencodedVideo{}, ctx.Err()
+	}
+	encodeCtx := ctx
+	if o.reencodeTimeout > 0 {
+		var cancel context.CancelFunc
+		encodeCtx, cancel = context.WithTimeout(ctx, o.reencodeTimeout)
+		defer cancel()
+	}
+	result, err := o.reencodeVideo(encodeCtx, filename, time.Duration(duration*float64(time.Second)), videoBitrate)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ReencodedVideo{}, ctx.Err()
+		}
+		if encodeCtx.Err() != nil {
+			return ReencodedVideo{}, ReencodeTimeoutError
+		}
+		return ReencodedVideo{}, err
+	}
+	if result.Size > maxSize {
+		_ = result.File.Close()
+		_ = os.Remove(result.File.Name())
+		return ReencodedVideo{}, errors.Errorf("the re-encoded video is still too large (%d bytes)", result.Size)
+	}
+	return result, nil
+}
+
+// reencodeVideo runs ffmpeg to re-encode a video with the given video bitrate
+func (o *Oauth) reencodeVideo(ctx context.Context, filename string, duration time.Duration, videoBitrate int64) (ReencodedVideo, error) {
+	outputFile, err := os.CreateTemp(o.tempDir, "*.mp4")
+	if err != nil {
+		return ReencodedVideo{}, errors.Wrap(err, "Unable to create a temporary file for the re-encoded video")
+	}
+	success := false
+	defer func() {
+		if !success {
+			_ = outputFile.Close()
+			_ = os.Remove(outputFile.Name())
+		}
+	}()
+	// The log of the first pass is kept in its own directory, so concurrent encodes do not mix
+	passLogDir, err := os.MkdirTemp(o.tempDir, "pass")
+	if err != nil {
+		return ReencodedVideo{}, errors.Wrap(err, "Unable to create a temporary directory for the pass log")
+	}
+	defer os.RemoveAll(passLogDir)
+	passLogFile := filepath.Join(passLogDir, "ffmpeg2pass")
+	videoArgs := []string{
+		"-i", filename,
+		"-map", "0:v:0",
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-b:v", strconv.FormatInt(videoBitrate, 10),
+		"-vf", "scale=-2:'min(ih," + strconv.FormatInt(reencodeMaxHeight(videoBitrate), 10) + ")'",
+		"-pix_fmt", "yuv420p",
+	}
+	// First pass
+	firstPassArgs := append(append([]string{}, videoArgs...), "-pass", "1", "-passlogfile", passLogFile, "-an", "-f", "null", os.DevNull)
+	twoPass := o.runEncoder(ctx, firstPassArgs, 1, 2, duration) == nil
+	if ctx.Err() != nil {
+		return ReencodedVideo{}, ctx.Err()
+	}
+	// Second (or the only) pass
+	secondPassArgs := append(append([]string{}, videoArgs...), "-map", "0:a:0?", "-c:a", "aac", "-b:a", strconv.Itoa(reencodeAudioBitrate))
+	pass, passes := 1, 1
+	if twoPass {
+		secondPassArgs = append(secondPassArgs, "-pass", "2", "-passlogfile", passLogFile)
+		pass, passes = 2, 2
+	} else {
+		// Without the first pass, limit the peaks of the bitrate instead
+		secondPassArgs = append(secondPassArgs,
+			"-maxrate", strconv.FormatInt(videoBitrate, 10),
+			"-bufsize", strconv.FormatInt(videoBitrate*2, 10))
+	}
+	secondPassArgs = append(secondPassArgs, "-movflags", "+faststart", outputFile.Name(), "-y")
+	if err = o.runEncoder(ctx, secondPassArgs, pass, passes, duration); err != nil {
+		return ReencodedVideo{}, err
+	}
+	stat, err := os.Stat(outputFile.Name())
+	if err != nil {
+		return ReencodedVideo{}, errors.Wrap(err, "Unable to get the size of the re-encoded video")
+	}
+	dimension, _ := GetVideoDimensions(outputFile.Name())
+	success = true
+	return ReencodedVideo{
+		File:         outputFile,
+		Size:         stat.Size(),
+		Dimension:    dimension,
+		VideoBitrate: videoBitrate,
+	}, nil
+}
+
+// runEncoder runs the given pass of ffmpeg with args and reports its progress. All the passes
+// of an encode are reported as a single phase.
+func (o *Oauth) runEncoder(ctx context.Context, args []string, pass, passes int, duration time.Duration) error {
+	args = append([]string{"-nostats", "-progress", "pipe:1"}, args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "Unable to create the output pipe of ffmpeg")
+	}
+	if err = cmd.Start(); err != nil {
+		return errors.Wrap(err, "Unable to start ffmpeg")
+	}
+	passDuration := int64(duration / time.Millisecond)
+	reportEncoderProgress(ctx, stdout, int64(pass-1)*passDuration, int64(passes)*passDuration)
+	if err = cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		reportError(ctx, "ffmpeg_encode", errors.Wrapf(err, "cannot re-encode the video: %s", lastLines(stderr.String(), 5)))
+		return errors.Wrap(err, "Unable to re-encode the video")
+	}
+	return nil
+}
+
+// reportEncoderProgress reads the progress of ffmpeg from output until it is closed and
+// reports it as ProgressPhaseEncoding in milliseconds of the encoded video
+func reportEncoderProgress(ctx context.Context, output io.Reader, offset, total int64) {
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), "out_time_us=")
+		if !found {
+			continue
+		}
+		if encodedTime, err := strconv.ParseInt(value, 10, 64); err == nil && encodedTime >= 0 {
+			reportProgress(ctx, ProgressPhaseEncoding, min(offset+encodedTime/1000, total), total)
+		}
+	}
+	// Do not block ffmpeg if the scanner has stopped early
+	_, _ = io.Copy(io.Discard, output)
+}
+
+// reencodeMaxHeight returns the maximum height of a video which is re-encoded with the given
+// video bitrate
+func reencodeMaxHeight(videoBitrate int64) int64 {
+	for _, height := range reencodeHeights {
+		if videoBitrate >= height.minBitrate {
+			return height.maxHeight
+		}
+	}
+	return reencodeHeights[len(reencodeHeights)-1].maxHeight
+}
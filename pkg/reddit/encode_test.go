@@ -0,0 +1,67 @@
+package reddit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestReencodeMaxHeight(t *testing.T) {
+	assertion := assert.New(t)
+	tests := []struct {
+		bitrate  int64
+		expected int64
+	}{
+		{5000 * 1000, 1080},
+		{2500 * 1000, 1080},
+		{2000 * 1000, 720},
+		{600 * 1000, 480},
+		{200 * 1000, 360},
+	}
+	for _, test := range tests {
+		assertion.Equal(test.expected, reencodeMaxHeight(test.bitrate), test.bitrate)
+	}
+}
+
+func TestReportEncoderProgress(t *testing.T) {
+	assertion := assert.New(t)
+	tests := []struct {
+		name             string
+		output           string
+		offset           int64
+		total            int64
+		expectedReported []int64
+	}{
+		{
+			name:             "First pass",
+			output:           "frame=10\nout_time_us=500000\nprogress=continue\nout_time_us=1000000\nprogress=end\n",
+			offset:           0,
+			total:            4000,
+			expectedReported: []int64{500, 1000},
+		},
+		{
+			name:             "Second pass",
+			output:           "out_time_us=1500000\nout_time_us=N/A\nout_time_us=2500000\n",
+			offset:           2000,
+			total:            4000,
+			expectedReported: []int64{3500, 4000},
+		},
+		{
+			name:             "No progress",
+			output:           "frame=10\nprogress=end\n",
+			total:            4000,
+			expectedReported: nil,
+		},
+	}
+	for _, test := range tests {
+		var reported []int64
+		ctx := WithProgress(context.Background(), func(phase ProgressPhase, encoded, total int64) {
+			assertion.Equal(ProgressPhaseEncoding, phase, test.name)
+			assertion.Equal(test.total, total, test.name)
+			reported = append(reported, encoded)
+		})
+		reportEncoderProgress(ctx, strings.NewReader(test.output), test.offset, test.total)
+		assertion.Equal(test.expectedReported, reported, test.name)
+	}
+}
@@ -2,75 +2,106 @@ package reddit
 
 import (
 	"RedditDownloaderBot/pkg/common"
+	"RedditDownloaderBot/pkg/logging"
 	"RedditDownloaderBot/pkg/util"
+	"context"
 	"fmt"
 	"github.com/PuerkitoBio/goquery"
+	"github.com/go-faster/errors"
 	"html"
-	"log"
 	"net/url"
 	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
 )
 
-// If this variable is true, it means that we don't allow nsfw posts to be downloaded
-var denyNsfw = util.ParseEnvironmentVariableBool("DENY_NSFW")
-
-// This error is returned if NSFW posts are disabled via denyNsfw and a nsfw post is requested
+// This error is returned if NSFW posts are disabled via Settings.DenyNSFW and a nsfw post is requested
 var nsfwNotAllowedErr = &FetchError{
 	NormalError: "",
 	BotError:    "NSFW posts are disabled.",
+	Category:    FetchErrorCategoryNSFW,
 }
 
 var giphyCommentRegex = regexp.MustCompile(`!\[gif]\(giphy\|(\w+)(?:\|downsized)?\)`)
 
+// The prefixes of fullnames of Reddit things.
+// See https://www.reddit.com/dev/api/#fullnames
+const (
+	fullnameCommentPrefix = "t1_"
+	fullnamePostPrefix    = "t3_"
+)
+
 // StartFetch gets the post info from url
 // The fetchResult can be one of the following types:
 // FetchResultText
 // FetchResultComment
 // FetchResultMedia
 // FetchResultAlbum
-func (o *Oauth) StartFetch(postUrl string) (fetchResult interface{}, realPostUrl string, fetchError *FetchError) {
+//
+// fullname is the Reddit fullname of the fetched post or comment (like t3_xxxxxx).
+// It can be converted back to a link with LinkFromFullname.
+func (o *Oauth) StartFetch(ctx context.Context, postUrl string) (fetchResult interface{}, realPostUrl, fullname string, fetchError *FetchError) {
 	// Don't crash the whole application
 	defer func() {
 		if r := recover(); r != nil {
 			fetchError = &FetchError{
 				NormalError: fmt.Sprintf("Recovering from panic in StartFetch. Error encountered: %v; URL: %v", r, postUrl),
 				BotError:    "Unable to get the data.\nMaybe a deleted post or invalid URL?",
+				Category:    FetchErrorCategoryInternal,
+				Stack:       string(debug.Stack()),
 			}
 		}
 	}()
+	logging.FromContext(ctx).Debug("Fetching the post", "url", postUrl)
 	// Get the post ID
-	postId, realPostUrl, isComment, fetchError := o.getPostID(postUrl)
+	postId, realPostUrl, isComment, fetchError := o.getPostID(ctx, postUrl)
 	if fetchError != nil {
 		return
 	}
 	if isComment {
-		root, err := o.GetComment(postId)
+		root, err := o.GetComment(ctx, postId)
 		if err != nil {
-			return nil, "", &FetchError{
+			return nil, "", "", &FetchError{
 				NormalError: "Unable to fetch the comment: " + err.Error(),
 				BotError:    "Unable to fetch the comment",
+				Category:    FetchErrorCategoryRequest,
 			}
 		}
-		return getCommentFromRoot(root), realPostUrl, nil
+		return getCommentFromRoot(root), realPostUrl, fullnameCommentPrefix + postId, nil
 	}
+	fullname = fullnamePostPrefix + postId
 	// Now download the json
-	root, err := o.GetPost(postId)
+	root, err := o.GetPost(ctx, postId)
 	if err != nil {
 		fetchError = &FetchError{
 			NormalError: "Unable to get the post data: " + err.Error(),
 			BotError:    "Unable to get the post data",
+			Category:    FetchErrorCategoryRequest,
 		}
 		return
 	}
-	fetchResult, fetchError = getPost(postUrl, root)
+	fetchResult, fetchError = getPost(ctx, postUrl, root, o.getSettings().DenyNSFW)
 	return
 }
 
+// LinkFromFullname creates a link which can be passed to StartFetch from a
+// fullname returned by StartFetch. Returns an empty string if the fullname
+// does not belong to a post or a comment.
+func LinkFromFullname(fullname string) string {
+	if id, ok := strings.CutPrefix(fullname, fullnamePostPrefix); ok && id != "" {
+		return "https://redd.it/" + id
+	}
+	if id, ok := strings.CutPrefix(fullname, fullnameCommentPrefix); ok && id != "" {
+		// Subreddit, post ID and title does not matter when fetching a comment
+		return "https://www.reddit.com/r/_/comments/_/_/" + id
+	}
+	return ""
+}
+
 // Gets the post ID from a post URL.
 // If you use this function, pass false for secondPass.
-func (o *Oauth) getPostID(postUrl string) (postID, realPostUrl string, isComment bool, err *FetchError) {
+func (o *Oauth) getPostID(ctx context.Context, postUrl string) (postID, realPostUrl string, isComment bool, err *FetchError) {
 	var u *url.URL = nil
 	// Check all lines for links. In new reddit update, sharing via Telegram adds the post title at its first
 	lines := strings.Split(postUrl, "\n")
@@ -95,7 +126,7 @@ func (o *Oauth) getPostID(postUrl string) (postID, realPostUrl string, isComment
 			return p, realPostUrl, false, nil
 		}
 		if u.Host == "v.redd.it" {
-			followedUrl, err := o.FollowRedirect(line)
+			followedUrl, err := o.FollowRedirect(ctx, line)
 			if err != nil {
 				continue
 			}
@@ -112,6 +143,7 @@ func (o *Oauth) getPostID(postUrl string) (postID, realPostUrl string, isComment
 		err = &FetchError{
 			NormalError: "",
 			BotError:    "Unable to parse the URL. Please make sure your message contains a valid Reddit link.",
+			Category:    FetchErrorCategoryInvalidURL,
 		}
 		return
 	}
@@ -123,15 +155,17 @@ func (o *Oauth) getPostID(postUrl string) (postID, realPostUrl string, isComment
 		err = &FetchError{
 			NormalError: "",
 			BotError:    "Unable to parse the URL. Please make sure your message contains a valid Reddit link.",
+			Category:    FetchErrorCategoryInvalidURL,
 		}
 		return
 	}
 	if split[3] == "s" { // new shared reddit URL like this: https://reddit.com/r/UkraineWarVideoReport/s/AKk56RlMN6
-		followedUrl, err2 := o.FollowRedirect(u.String())
+		followedUrl, err2 := o.FollowRedirect(ctx, u.String())
 		if err2 != nil {
 			err = &FetchError{
 				NormalError: "Unable to follow the shared URL: " + err2.Error(),
 				BotError:    "Unable to follow the shared URL",
+				Category:    FetchErrorCategoryRequest,
 			}
 			return
 		}
@@ -139,10 +173,11 @@ func (o *Oauth) getPostID(postUrl string) (postID, realPostUrl string, isComment
 			err = &FetchError{
 				NormalError: "Recursion detected: " + postID,
 				BotError:    "Corrupted or unsupported URL. Paste the link in your browser, then send the redirected link to the bot.",
+				Category:    FetchErrorCategoryInvalidURL,
 			}
 			return
 		}
-		return o.getPostID(followedUrl)
+		return o.getPostID(ctx, followedUrl)
 	}
 	if len(split) >= 7 && split[6] != "" {
 		return split[6], realPostUrl, true, nil
@@ -170,14 +205,15 @@ func getCommentFromRoot(root map[string]interface{}) interface{} {
 	return FetchResultComment{text}
 }
 
-// getPost will get the post from the parsed root API.
+// getPost will get the post from the parsed root API. If denyNsfw is true, NSFW posts
+// are rejected with nsfwNotAllowedErr.
 // The result is one of these types:
 // FetchResultText
 // FetchResultMedia
 // FetchResultAlbum
 //
 // This function is seperated from Oauth.StartFetch to write tests for it
-func getPost(postUrl string, root map[string]interface{}) (fetchResult interface{}, fetchError *FetchError) {
+func getPost(ctx context.Context, postUrl string, root map[string]interface{}, denyNsfw bool) (fetchResult interface{}, fetchError *FetchError) {
 	// Get post type
 	// To do so, I check data->children[0]->data->post_hint
 	{
@@ -186,6 +222,7 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 			fetchError = &FetchError{
 				NormalError: "Unable to parse the page data: couldn’t find node `data`",
 				BotError:    "Unable to parse the page data: couldn’t find node `data`",
+				Category:    FetchErrorCategoryParse,
 			}
 			return
 		}
@@ -194,6 +231,7 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 			fetchError = &FetchError{
 				NormalError: "Unable to parse the page data: couldn’t find node `data->children`",
 				BotError:    "Unable to parse the page data: couldn’t find node `data->children`",
+				Category:    FetchErrorCategoryParse,
 			}
 			return
 		}
@@ -203,6 +241,7 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 			fetchError = &FetchError{
 				NormalError: "Unable to parse the page data: couldn’t find node `data->children[0]->data`",
 				BotError:    "Unable to parse the page data: couldn’t find node `data->children[0]->data`",
+				Category:    FetchErrorCategoryParse,
 			}
 			return
 		}
@@ -291,12 +330,23 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 			redditVideo := root["media"].(map[string]interface{})["reddit_video"].(map[string]interface{})
 			duration, _ := redditVideo["duration"].(float64) // Do not panic if duration does not exist. Just let the Telegram handle it
 			fallbackURL := redditVideo["fallback_url"].(string)
-			dashURL := redditVideo["dash_url"].(string)
-			qualities, err := extractVideoQualities(dashURL)
+			dashURL, _ := redditVideo["dash_url"].(string)
+			qualities, err := extractVideoQualities(ctx, dashURL)
+			// Use the HLS playlist if the DASH one is missing or broken
+			if hlsURL, hasHLS := redditVideo["hls_url"].(string); err != nil && hasHLS {
+				var hlsErr error
+				qualities, hlsErr = extractHLSQualities(ctx, html.UnescapeString(hlsURL), duration)
+				if hlsErr == nil {
+					err = nil
+				} else {
+					err = errors.Wrapf(err, "the HLS playlist failed too (%s)", hlsErr)
+				}
+			}
 			if err != nil {
 				return nil, &FetchError{
 					NormalError: "Unable to get qualities for video. The main URL was " + postUrl + "; Error was " + err.Error(),
 					BotError:    "Unable to get the video. Here is the direct link to video:\n" + fallbackURL,
+					Category:    FetchErrorCategoryMedia,
 				}
 			}
 			return FetchResultMedia{
@@ -328,11 +378,12 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 						fallback, hasUrl := vid.(map[string]interface{})["fallback_url"].(string)
 						dashURL, hasDash := vid.(map[string]interface{})["dash_url"].(string)
 						if hasUrl && hasDash {
-							qualities, err := extractVideoQualities(dashURL)
+							qualities, err := extractVideoQualities(ctx, dashURL)
 							if err != nil {
 								return nil, &FetchError{
 									NormalError: "Unable to get the qualities for Gfycat. The original link: " + postUrl + ". Error encountered: " + err.Error(),
 									BotError:    "Unable to get the video.\nHere is the link:" + fallback,
+									Category:    FetchErrorCategoryMedia,
 								}
 							}
 							return FetchResultMedia{
@@ -347,6 +398,7 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 					return nil, &FetchError{
 						NormalError: "Unable to get the media from Gfycat. The original link: " + postUrl,
 						BotError:    "Unable to get the video.\nHere is the link:" + root["url"].(string),
+						Category:    FetchErrorCategoryMedia,
 					}
 				case "streamable.com": // example: https://streamable.com/u2jzoo
 					// Download the source at first
@@ -355,6 +407,7 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 						return nil, &FetchError{
 							NormalError: "Unable to get the source code of " + root["url"].(string) + ": " + err.Error(),
 							BotError:    "Unable to get the source code of " + root["url"].(string),
+							Category:    FetchErrorCategoryMedia,
 						}
 					}
 					defer source.Body.Close()
@@ -364,6 +417,7 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 						return nil, &FetchError{
 							NormalError: "Unable to get the parse code of " + root["url"].(string) + ": " + err.Error(),
 							BotError:    "Unable to get the parse code of " + root["url"].(string),
+							Category:    FetchErrorCategoryMedia,
 						}
 					}
 					result := FetchResultMedia{
@@ -387,12 +441,14 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 					return nil, &FetchError{
 						NormalError: "",
 						BotError:    "This bot doesn’t support downloading from " + urlObject.(string) + "\nThe URL field in JSON is " + root["url"].(string),
+						Category:    FetchErrorCategoryUnsupported,
 					}
 				}
 			} else {
 				return nil, &FetchError{
 					NormalError: "",
 					BotError:    "The type of this post is rich:video but it does not contains `domain`",
+					Category:    FetchErrorCategoryUnsupported,
 				}
 			}
 		case "gallery":
@@ -401,18 +457,20 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 					return FetchResultAlbum{
 						Title:       title,
 						Description: description,
-						Album:       getGalleryData(data.(map[string]interface{}), gData.(map[string]interface{})["items"].([]interface{})),
+						Album:       getGalleryData(ctx, data.(map[string]interface{}), gData.(map[string]interface{})["items"].([]interface{})),
 					}, nil
 				}
 			}
 			return nil, &FetchError{
 				NormalError: "",
 				BotError:    "This post looks like a gallery but it's not. Please report this post: https://github.com/HirbodBehnam/RedditDownloaderBot/issues",
+				Category:    FetchErrorCategoryUnsupported,
 			}
 		default:
 			return nil, &FetchError{
 				NormalError: "",
 				BotError:    "This type of post is not supported: " + hint.(string),
+				Category:    FetchErrorCategoryUnsupported,
 			}
 		}
 	} else { // text or gallery
@@ -421,7 +479,7 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 				return FetchResultAlbum{
 					Title:       title,
 					Description: description,
-					Album:       getGalleryData(data.(map[string]interface{}), gData.(map[string]interface{})["items"].([]interface{})),
+					Album:       getGalleryData(ctx, data.(map[string]interface{}), gData.(map[string]interface{})["items"].([]interface{})),
 				}, nil
 			}
 		}
@@ -434,7 +492,7 @@ func getPost(postUrl string, root map[string]interface{}) (fetchResult interface
 }
 
 // getGalleryData extracts the gallery data from gallery json
-func getGalleryData(files map[string]interface{}, galleryDataItems []interface{}) []FetchResultAlbumEntry {
+func getGalleryData(ctx context.Context, files map[string]interface{}, galleryDataItems []interface{}) []FetchResultAlbumEntry {
 	album := make([]FetchResultAlbumEntry, 0, len(galleryDataItems))
 	for _, data := range galleryDataItems {
 		galleryRoot := files[data.(map[string]interface{})["media_id"].(string)]
@@ -508,7 +566,7 @@ func getGalleryData(files map[string]interface{}, galleryDataItems []interface{}
 				Type:    FetchResultMediaTypeVideo,
 			})
 		default:
-			log.Println("Unknown type in send gallery:", dataType)
+			logging.FromContext(ctx).Warn("Unknown type in gallery", "type", dataType)
 		}
 	}
 	return album
@@ -554,28 +612,38 @@ func extractPhotoGifQualities(data map[string]interface{}) []FetchResultMediaEnt
 }
 
 // extractVideoQualities gets all possible qualities from DASHPlaylist URL
-func extractVideoQualities(DASHPlaylistURL string) ([]FetchResultMediaEntry, error) {
+func extractVideoQualities(ctx context.Context, DASHPlaylistURL string) ([]FetchResultMediaEntry, error) {
 	// Get the list from dash playlist
-	qualities, err := ParseDashPlaylistFromID(html.UnescapeString(DASHPlaylistURL))
+	playlistURL := html.UnescapeString(DASHPlaylistURL)
+	qualities, err := ParseDashPlaylistFromID(ctx, playlistURL)
 	if err != nil {
 		return nil, err
 	}
 	SortVideoQualities(qualities.AvailableVideos)
 	base := getVideoVRedditBaseURL(DASHPlaylistURL)
+	// The segmented representations are downloaded from the playlist itself
+	link := func(representation dashRepresentation) string {
+		if representation.segmented() {
+			return dashRepresentationLink(playlistURL, representation.ID)
+		}
+		return base + representation.BaseURL
+	}
 	// Convert the qualities
 	result := make([]FetchResultMediaEntry, 0, len(qualities.AvailableVideos)+1)
 	for _, video := range qualities.AvailableVideos {
 		result = append(result, FetchResultMediaEntry{
-			Link:    base + video.BaseURL,
-			Quality: video.Quality() + "p",
+			Link:    link(video.representation()),
+			Quality: video.Label(),
 			Dim:     video.Dimension,
+			Size:    video.EstimatedSize(qualities.Duration),
 		})
 	}
 	// Check for audio
-	if len(qualities.AvailableAudios) != 0 {
+	if audio, hasAudio := qualities.BestAudio(); hasAudio {
 		result = append(result, FetchResultMediaEntry{
-			Link:    base + string(qualities.AvailableAudios[len(qualities.AvailableAudios)-1]),
+			Link:    link(audio.representation()),
 			Quality: DownloadAudioQuality,
+			Size:    audio.EstimatedSize(qualities.Duration),
 		})
 	}
 	return result, nil
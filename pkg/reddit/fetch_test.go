@@ -2,6 +2,7 @@ package reddit
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
@@ -243,7 +244,7 @@ func TestGetGalleryData(t *testing.T) {
 			assert.NoError(t, err, "not expecting error when decoding sample files")
 			err = json.NewDecoder(strings.NewReader(test.GalleryDataItems)).Decode(&galleryDataItems)
 			assert.NoError(t, err, "not expecting error when decoding sample gallery data items")
-			result := getGalleryData(files, galleryDataItems)
+			result := getGalleryData(context.Background(), files, galleryDataItems)
 			assert.Equal(t, test.ExpectedAlbum, result)
 		})
 	}
@@ -366,7 +367,7 @@ func TestGetPostId(t *testing.T) {
 	clientSecret := os.Getenv("CLIENT_SECRET")
 	if clientID != "" && clientSecret != "" {
 		var err error
-		oauth, err = NewRedditOauth(clientID, clientSecret)
+		oauth, err = NewRedditOauth(Options{ClientID: clientID, ClientSecret: clientSecret})
 		if err != nil {
 			t.Log("Ouath failed:", err)
 			oauth = new(Oauth)
@@ -383,13 +384,13 @@ func TestGetPostId(t *testing.T) {
 					t.Skip("This test needs oauth")
 					return
 				}
-				if _, err := oauth.FollowRedirect(test.Url); err != nil {
+				if _, err := oauth.FollowRedirect(context.Background(), test.Url); err != nil {
 					t.Skip("cannot connect to internet:", err)
 					return
 				}
 			}
 			// Get the id
-			id, realPostUrl, isComment, err := oauth.getPostID(test.Url)
+			id, realPostUrl, isComment, err := oauth.getPostID(context.Background(), test.Url)
 			if err != nil {
 				assert.Equal(t, test.ExpectedError, err.BotError)
 			}
@@ -404,6 +405,52 @@ func TestGetPostId(t *testing.T) {
 	}
 }
 
+func TestLinkFromFullname(t *testing.T) {
+	tests := []struct {
+		TestName          string
+		Fullname          string
+		ExpectedID        string
+		ExpectedIsComment bool
+		ExpectedEmpty     bool
+	}{
+		{
+			TestName:   "Post",
+			Fullname:   "t3_kmi4d3",
+			ExpectedID: "kmi4d3",
+		},
+		{
+			TestName:          "Comment",
+			Fullname:          "t1_icm3y72",
+			ExpectedID:        "icm3y72",
+			ExpectedIsComment: true,
+		},
+		{
+			TestName:      "Subreddit",
+			Fullname:      "t5_2qh1i",
+			ExpectedEmpty: true,
+		},
+		{
+			TestName:      "Empty ID",
+			Fullname:      "t3_",
+			ExpectedEmpty: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.TestName, func(t *testing.T) {
+			link := LinkFromFullname(test.Fullname)
+			if test.ExpectedEmpty {
+				assert.Empty(t, link)
+				return
+			}
+			// The link must be parsable by getPostID without internet
+			id, _, isComment, err := new(Oauth).getPostID(context.Background(), link)
+			assert.Nil(t, err)
+			assert.Equal(t, test.ExpectedID, id)
+			assert.Equal(t, test.ExpectedIsComment, isComment)
+		})
+	}
+}
+
 func TestGetCommentFromRoot(t *testing.T) {
 	tests := []struct {
 		TestName string
@@ -831,6 +878,7 @@ func TestGetPost(t *testing.T) {
 			ExpectedError: &FetchError{
 				NormalError: "",
 				BotError:    "This bot doesn’t support downloading from youtu.be\nThe URL field in JSON is https://youtu.be/7ILCRfPmQxQ",
+				Category:    FetchErrorCategoryUnsupported,
 			},
 		},
 		{
@@ -845,39 +893,44 @@ func TestGetPost(t *testing.T) {
 				Medias: []FetchResultMediaEntry{
 					{
 						Link:    "%s/5scwdfq0wlj91/DASH_480.mp4",
-						Quality: "480p",
+						Quality: "424p",
 						Dim: Dimension{
 							Width:  424,
 							Height: 480,
 						},
+						Size: 781312,
 					},
 					{
 						Link:    "%s/5scwdfq0wlj91/DASH_360.mp4",
-						Quality: "360p",
+						Quality: "318p",
 						Dim: Dimension{
 							Width:  318,
 							Height: 360,
 						},
+						Size: 519348,
 					},
 					{
 						Link:    "%s/5scwdfq0wlj91/DASH_240.mp4",
-						Quality: "240p",
+						Quality: "212p",
 						Dim: Dimension{
 							Width:  212,
 							Height: 240,
 						},
+						Size: 389611,
 					},
 					{
 						Link:    "%s/5scwdfq0wlj91/DASH_220.mp4",
-						Quality: "220p",
+						Quality: "194p",
 						Dim: Dimension{
 							Width:  194,
 							Height: 220,
 						},
+						Size: 171307,
 					},
 					{
 						Link:    "%s/5scwdfq0wlj91/DASH_audio.mp4",
 						Quality: DownloadAudioQuality,
+						Size:    90012,
 					},
 				},
 				ThumbnailLinks: FetchedThumbnails{
@@ -928,35 +981,39 @@ func TestGetPost(t *testing.T) {
 				Medias: []FetchResultMediaEntry{
 					{
 						Link:    "%s/5scwdfq0wlj91/DASH_480.mp4",
-						Quality: "480p",
+						Quality: "424p",
 						Dim: Dimension{
 							Width:  424,
 							Height: 480,
 						},
+						Size: 781312,
 					},
 					{
 						Link:    "%s/5scwdfq0wlj91/DASH_360.mp4",
-						Quality: "360p",
+						Quality: "318p",
 						Dim: Dimension{
 							Width:  318,
 							Height: 360,
 						},
+						Size: 519348,
 					},
 					{
 						Link:    "%s/5scwdfq0wlj91/DASH_240.mp4",
-						Quality: "240p",
+						Quality: "212p",
 						Dim: Dimension{
 							Width:  212,
 							Height: 240,
 						},
+						Size: 389611,
 					},
 					{
 						Link:    "%s/5scwdfq0wlj91/DASH_220.mp4",
-						Quality: "220p",
+						Quality: "194p",
 						Dim: Dimension{
 							Width:  194,
 							Height: 220,
 						},
+						Size: 171307,
 					},
 				},
 				ThumbnailLinks: FetchedThumbnails{
@@ -1012,6 +1069,7 @@ func TestGetPost(t *testing.T) {
 							Width:  582,
 							Height: 480,
 						},
+						Size: 858175,
 					},
 					{
 						Link:    "%s/pw4v2kzgg0fb1/DASH_360.mp4",
@@ -1020,6 +1078,7 @@ func TestGetPost(t *testing.T) {
 							Width:  436,
 							Height: 360,
 						},
+						Size: 586244,
 					},
 					{
 						Link:    "%s/pw4v2kzgg0fb1/DASH_270.mp4",
@@ -1028,6 +1087,7 @@ func TestGetPost(t *testing.T) {
 							Width:  328,
 							Height: 270,
 						},
+						Size: 398463,
 					},
 					{
 						Link:    "%s/pw4v2kzgg0fb1/DASH_220.mp4",
@@ -1036,10 +1096,12 @@ func TestGetPost(t *testing.T) {
 							Width:  266,
 							Height: 220,
 						},
+						Size: 305786,
 					},
 					{
 						Link:    "%s/pw4v2kzgg0fb1/DASH_AUDIO_128.mp4",
 						Quality: DownloadAudioQuality,
+						Size:    218741,
 					},
 				},
 				ThumbnailLinks: FetchedThumbnails{
@@ -1347,7 +1409,7 @@ func TestGetPost(t *testing.T) {
 			var root map[string]interface{}
 			err := json.Unmarshal(test.Root, &root)
 			assert.NoError(t, err, "not expecting error when decoding sample root")
-			result, fetchError := getPost(test.PostUrl, root)
+			result, fetchError := getPost(context.Background(), test.PostUrl, root, false)
 			if fetchError != nil && test.ExpectedError != nil {
 				assert.Equal(t, *test.ExpectedError, *fetchError)
 			} else if fetchError != nil && test.ExpectedError == nil {
@@ -0,0 +1,393 @@
+package reddit
+
+import (
+	"RedditDownloaderBot/pkg/util"
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"github.com/go-faster/errors"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HLSVariant is a variant stream (EXT-X-STREAM-INF) of an HLS master playlist
+type HLSVariant struct {
+	// The link to the media playlist of the variant
+	URI string
+	// The peak bitrate of the variant in bits per second
+	Bandwidth int64
+	// The average bitrate of the variant in bits per second. Zero if unknown.
+	AverageBandwidth int64
+	Resolution       Dimension
+	Codecs           string
+	// The frames per second. Zero if unknown.
+	FrameRate float64
+	// The GROUP-ID of the audio renditions of this variant. Empty if the variant has no separate audio.
+	AudioGroup string
+}
+
+// EstimatedSize estimates the size of the variant in bytes from its bandwidth
+func (v HLSVariant) EstimatedSize(duration float64) int64 {
+	return int64(float64(cmp.Or(v.AverageBandwidth, v.Bandwidth)) / 8 * duration)
+}
+
+// HLSRendition is an alternative rendition (EXT-X-MEDIA) of an HLS master playlist
+type HLSRendition struct {
+	// The type of the rendition, like AUDIO or SUBTITLES
+	Type    string
+	GroupID string
+	Name    string
+	// The link to the media playlist of the rendition. Empty if the rendition is in the variant itself.
+	URI     string
+	Default bool
+}
+
+// HLSMasterPlaylist is a parsed HLS master playlist
+type HLSMasterPlaylist struct {
+	Variants   []HLSVariant
+	Renditions []HLSRendition
+}
+
+// audioOf returns the audio rendition of a variant which has a separate playlist.
+// The default rendition of the group is preferred.
+func (p HLSMasterPlaylist) audioOf(variant HLSVariant) (HLSRendition, bool) {
+	var result HLSRendition
+	found := false
+	for _, rendition := range p.Renditions {
+		if rendition.Type != "AUDIO" || rendition.GroupID != variant.AudioGroup || rendition.URI == "" {
+			continue
+		}
+		if !found || (rendition.Default && !result.Default) {
+			result, found = rendition, true
+		}
+	}
+	return result, found
+}
+
+// HLSMediaPlaylist is a parsed HLS media playlist
+type HLSMediaPlaylist struct {
+	// The initialization segment (EXT-X-MAP) of fragmented MP4 playlists. Nil for MPEG-TS playlists.
+	InitSegment *mediaSegment
+	Segments    []mediaSegment
+}
+
+// isHLSLink checks if a link points to an HLS playlist
+func isHLSLink(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && strings.HasSuffix(u.Path, ".m3u8")
+}
+
+// hlsAudioKey is the key in the fragment of the links of HLS variants which holds the link
+// of their own audio
+const hlsAudioKey = "audio"
+
+// hlsVariantLink creates the link of an HLS variant whose audio is not the audio entry of the
+// qualities. The link of its audio is in the fragment, like dashRepresentationLink, so
+// DownloadVideo merges the right audio into it. An empty audioLink means that the variant
+// has no separate audio.
+func hlsVariantLink(variantURL, audioLink string) string {
+	return variantURL + "#" + url.Values{hlsAudioKey: {audioLink}}.Encode()
+}
+
+// hlsVariantAudio gets the link of the variant and its audio from a link which was created by
+// hlsVariantLink. ok is false if the link was not created by hlsVariantLink.
+func hlsVariantAudio(link string) (variantURL, audioLink string, ok bool) {
+	u, err := url.Parse(link)
+	if err != nil || !strings.HasSuffix(u.Path, ".m3u8") {
+		return link, "", false
+	}
+	fragment, _ := url.ParseQuery(u.Fragment)
+	if !fragment.Has(hlsAudioKey) {
+		return link, "", false
+	}
+	u.Fragment = ""
+	return u.String(), fragment.Get(hlsAudioKey), true
+}
+
+// extractHLSQualities gets the qualities of a video from its HLS master playlist.
+// The videos are sorted from the best to the worst and the audio is the last entry, like extractVideoQualities.
+// The audio entry is the audio of the best variant. The variants with another audio have it in their links.
+// duration is the duration of the video in seconds which is used to estimate the sizes.
+func extractHLSQualities(ctx context.Context, playlistURL string, duration float64) ([]FetchResultMediaEntry, error) {
+	body, base, err := getPlaylist(ctx, playlistURL)
+	if err != nil {
+		return nil, err
+	}
+	playlist, err := parseHLSMasterPlaylist(bytes.NewReader(body), base)
+	if err != nil {
+		return nil, err
+	}
+	if len(playlist.Variants) == 0 {
+		return nil, errors.New("the HLS playlist has no variants")
+	}
+	variants := playlist.Variants
+	sort.SliceStable(variants, func(i, j int) bool {
+		if variants[i].Resolution.shortSide() != variants[j].Resolution.shortSide() {
+			return variants[i].Resolution.shortSide() > variants[j].Resolution.shortSide()
+		}
+		return variants[i].Bandwidth > variants[j].Bandwidth
+	})
+	result := make([]FetchResultMediaEntry, 0, len(variants)+1)
+	sharedAudio, hasSharedAudio := playlist.audioOf(variants[0])
+	for _, variant := range variants {
+		link := variant.URI
+		audio, hasAudio := playlist.audioOf(variant)
+		if hasAudio != hasSharedAudio || audio.URI != sharedAudio.URI {
+			link = hlsVariantLink(variant.URI, audio.URI)
+		}
+		quality := "NA"
+		if side := variant.Resolution.shortSide(); side > 0 {
+			quality = strconv.FormatInt(side, 10)
+		}
+		result = append(result, FetchResultMediaEntry{
+			Link:    link,
+			Quality: videoQualityLabel(quality, variant.FrameRate),
+			Dim:     variant.Resolution,
+			Size:    variant.EstimatedSize(duration),
+		})
+	}
+	if hasSharedAudio {
+		result = append(result, FetchResultMediaEntry{
+			Link:    sharedAudio.URI,
+			Quality: DownloadAudioQuality,
+		})
+	}
+	return result, nil
+}
+
+// parseHLSMasterPlaylist parses a master playlist. The relative links are resolved against base.
+func parseHLSMasterPlaylist(r io.Reader, base *url.URL) (HLSMasterPlaylist, error) {
+	var result HLSMasterPlaylist
+	var pendingVariant *HLSVariant
+	err := scanHLSPlaylist(r, func(tag, value string) error {
+		switch tag {
+		case "#EXT-X-STREAM-INF":
+			attributes := parseHLSAttributes(value)
+			bandwidth, _ := strconv.ParseInt(attributes["BANDWIDTH"], 10, 64)
+			averageBandwidth, _ := strconv.ParseInt(attributes["AVERAGE-BANDWIDTH"], 10, 64)
+			frameRate, _ := strconv.ParseFloat(attributes["FRAME-RATE"], 64)
+			pendingVariant = &HLSVariant{
+				Bandwidth:        bandwidth,
+				AverageBandwidth: averageBandwidth,
+				Resolution:       parseHLSResolution(attributes["RESOLUTION"]),
+				Codecs:           attributes["CODECS"],
+				FrameRate:        frameRate,
+				AudioGroup:       attributes["AUDIO"],
+			}
+		case "#EXT-X-MEDIA":
+			attributes := parseHLSAttributes(value)
+			rendition := HLSRendition{
+				Type:    attributes["TYPE"],
+				GroupID: attributes["GROUP-ID"],
+				Name:    attributes["NAME"],
+				Default: attributes["DEFAULT"] == "YES",
+			}
+			if attributes["URI"] != "" {
+				rendition.URI = resolvePlaylistLink(base, attributes["URI"])
+			}
+			result.Renditions = append(result.Renditions, rendition)
+		case "": // A link
+			if pendingVariant != nil {
+				pendingVariant.URI = resolvePlaylistLink(base, value)
+				result.Variants = append(result.Variants, *pendingVariant)
+				pendingVariant = nil
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// parseHLSMediaPlaylist parses a media playlist. The relative links are resolved against base.
+// Encrypted playlists are not supported.
+func parseHLSMediaPlaylist(r io.Reader, base *url.URL) (HLSMediaPlaylist, error) {
+	var result HLSMediaPlaylist
+	// The byte range of the next segment and the end of the last range of each link,
+	// which is the start of the next range if it has no offset
+	pendingRange := ""
+	rangeEnds := make(map[string]int64)
+	err := scanHLSPlaylist(r, func(tag, value string) error {
+		switch tag {
+		case "#EXT-X-KEY":
+			if method := parseHLSAttributes(value)["METHOD"]; method != "NONE" {
+				return errors.Errorf("encrypted HLS playlists (%s) are not supported", method)
+			}
+		case "#EXT-X-MAP":
+			attributes := parseHLSAttributes(value)
+			link := resolvePlaylistLink(base, attributes["URI"])
+			result.InitSegment = &mediaSegment{
+				Link:  link,
+				Range: hlsByteRange(attributes["BYTERANGE"], link, rangeEnds),
+			}
+		case "#EXT-X-BYTERANGE":
+			pendingRange = value
+		case "": // A link
+			link := resolvePlaylistLink(base, value)
+			result.Segments = append(result.Segments, mediaSegment{
+				Link:  link,
+				Range: hlsByteRange(pendingRange, link, rangeEnds),
+			})
+			pendingRange = ""
+		}
+		return nil
+	})
+	if err == nil && len(result.Segments) == 0 {
+		err = errors.New("the HLS playlist has no segments")
+	}
+	return result, err
+}
+
+// scanHLSPlaylist calls f for each tag and link of a playlist. The links are passed with an empty tag.
+// The other comments are skipped.
+func scanHLSPlaylist(r io.Reader, f func(tag, value string) error) error {
+	scanner := bufio.NewScanner(r)
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			if line != "#EXTM3U" {
+				return errors.New("not an HLS playlist")
+			}
+			first = false
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		var err error
+		if strings.HasPrefix(line, "#EXT") {
+			tag, value, _ := strings.Cut(line, ":")
+			err = f(tag, value)
+		} else if !strings.HasPrefix(line, "#") {
+			err = f("", line)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "cannot read the playlist")
+	}
+	if first {
+		return errors.New("empty HLS playlist")
+	}
+	return nil
+}
+
+// parseHLSAttributes parses an attribute list like BANDWIDTH=1000,CODECS="avc1.4d401f,mp4a.40.2"
+func parseHLSAttributes(list string) map[string]string {
+	result := make(map[string]string)
+	for list != "" {
+		name, rest, found := strings.Cut(list, "=")
+		if !found {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.IndexByte(rest[1:], '"')
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		result[strings.TrimSpace(name)] = value
+		list = rest
+	}
+	return result
+}
+
+// parseHLSResolution parses the resolutions like 1280x720
+func parseHLSResolution(resolution string) Dimension {
+	width, height, _ := strings.Cut(resolution, "x")
+	w, _ := strconv.ParseInt(width, 10, 64)
+	h, _ := strconv.ParseInt(height, 10, 64)
+	return Dimension{Width: w, Height: h}
+}
+
+// hlsByteRange converts an HLS byte range like 1000@200 to the value of the Range header.
+// If the range has no offset, it starts after the previous range of the link. rangeEnds is updated.
+func hlsByteRange(byteRange, link string, rangeEnds map[string]int64) string {
+	if byteRange == "" {
+		return ""
+	}
+	lengthText, offsetText, hasOffset := strings.Cut(byteRange, "@")
+	length, err := strconv.ParseInt(lengthText, 10, 64)
+	if err != nil || length <= 0 {
+		return ""
+	}
+	offset := rangeEnds[link]
+	if hasOffset {
+		offset, _ = strconv.ParseInt(offsetText, 10, 64)
+	}
+	rangeEnds[link] = offset + length
+	return "bytes=" + strconv.FormatInt(offset, 10) + "-" + strconv.FormatInt(offset+length-1, 10)
+}
+
+// downloadHLS downloads the media of an HLS media playlist to f. Fragmented MP4 segments are
+// concatenated as is. MPEG-TS segments are remuxed into MP4 with ffmpeg.
+func (o *Oauth) downloadHLS(ctx context.Context, playlistURL string, f *os.File, phase ProgressPhase, maxSize int64) error {
+	body, base, err := getPlaylist(ctx, playlistURL)
+	if err != nil {
+		return err
+	}
+	playlist, err := parseHLSMediaPlaylist(bytes.NewReader(body), base)
+	if err != nil {
+		return err
+	}
+	if playlist.InitSegment != nil {
+		segments := append([]mediaSegment{*playlist.InitSegment}, playlist.Segments...)
+		return o.downloadSegments(ctx, segments, f, phase, maxSize)
+	}
+	if !util.DoesFfmpegExists() {
+		return errors.New("ffmpeg is needed to download MPEG-TS playlists")
+	}
+	tsFile, err := os.CreateTemp(o.tempDir, "*.ts")
+	if err != nil {
+		return errors.Wrap(err, "Unable to create a temporary file for the segments")
+	}
+	defer func() {
+		_ = tsFile.Close()
+		_ = os.Remove(tsFile.Name())
+	}()
+	if err = o.downloadSegments(ctx, playlist.Segments, tsFile, phase, maxSize); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-i", tsFile.Name(),
+		"-map", "0",
+		"-c", "copy",
+		"-f", "mp4",
+		f.Name(), "-y")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		reportError(ctx, "ffmpeg_remux", errors.Wrapf(err, "cannot remux %s: %s", playlistURL, lastLines(stderr.String(), 5)))
+		return errors.Wrap(err, "Unable to remux the segments")
+	}
+	return nil
+}
+
+// downloadMedia downloads a video or audio to f. The link can be a single file, an HLS playlist
+// or a segmented representation of a DASH playlist.
+func (o *Oauth) downloadMedia(ctx context.Context, link string, f *os.File, phase ProgressPhase, maxSize int64) error {
+	if isHLSLink(link) {
+		return o.downloadHLS(ctx, link, f, phase, maxSize)
+	}
+	if isDASHLink(link) {
+		return o.downloadDASH(ctx, link, f, phase, maxSize)
+	}
+	return o.downloadToFile(ctx, link, f, phase, maxSize)
+}
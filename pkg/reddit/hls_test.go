@@ -0,0 +1,254 @@
+package reddit
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseHLSAttributes(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Data     string
+		Expected map[string]string
+	}{
+		{
+			Name:     "plain",
+			Data:     "BANDWIDTH=1000,RESOLUTION=1280x720",
+			Expected: map[string]string{"BANDWIDTH": "1000", "RESOLUTION": "1280x720"},
+		},
+		{
+			Name:     "quoted_comma",
+			Data:     `CODECS="avc1.4d401f,mp4a.40.2",AUDIO="audio-0",FRAME-RATE=30.000`,
+			Expected: map[string]string{"CODECS": "avc1.4d401f,mp4a.40.2", "AUDIO": "audio-0", "FRAME-RATE": "30.000"},
+		},
+		{
+			Name:     "empty",
+			Data:     "",
+			Expected: map[string]string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, parseHLSAttributes(test.Data))
+		})
+	}
+}
+
+func TestParseHLSMasterPlaylist(t *testing.T) {
+	base, _ := url.Parse("https://v.redd.it/abc/HLSPlaylist.m3u8?a=1")
+	data := `#EXTM3U
+#EXT-X-VERSION:6
+#EXT-X-INDEPENDENT-SEGMENTS
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio-0",NAME="64k",URI="HLS_AUDIO_64.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio-0",NAME="128k",DEFAULT=YES,URI="HLS_AUDIO_128.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=600000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2",AUDIO="audio-0",FRAME-RATE=30.000
+HLS_360.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,AVERAGE-BANDWIDTH=2000000,RESOLUTION=1280x720,CODECS="avc1.4d401f,mp4a.40.2",AUDIO="audio-0",FRAME-RATE=60.000
+https://cdn.example.com/HLS_720.m3u8
+`
+	playlist, err := parseHLSMasterPlaylist(strings.NewReader(data), base)
+	assert.NoError(t, err)
+	assert.Equal(t, HLSMasterPlaylist{
+		Variants: []HLSVariant{
+			{
+				URI:        "https://v.redd.it/abc/HLS_360.m3u8",
+				Bandwidth:  600000,
+				Resolution: Dimension{Width: 640, Height: 360},
+				Codecs:     "avc1.4d401e,mp4a.40.2",
+				FrameRate:  30,
+				AudioGroup: "audio-0",
+			},
+			{
+				URI:              "https://cdn.example.com/HLS_720.m3u8",
+				Bandwidth:        2500000,
+				AverageBandwidth: 2000000,
+				Resolution:       Dimension{Width: 1280, Height: 720},
+				Codecs:           "avc1.4d401f,mp4a.40.2",
+				FrameRate:        60,
+				AudioGroup:       "audio-0",
+			},
+		},
+		Renditions: []HLSRendition{
+			{Type: "AUDIO", GroupID: "audio-0", Name: "64k", URI: "https://v.redd.it/abc/HLS_AUDIO_64.m3u8"},
+			{Type: "AUDIO", GroupID: "audio-0", Name: "128k", URI: "https://v.redd.it/abc/HLS_AUDIO_128.m3u8", Default: true},
+		},
+	}, playlist)
+	audio, hasAudio := playlist.audioOf(playlist.Variants[0])
+	assert.True(t, hasAudio)
+	assert.Equal(t, "https://v.redd.it/abc/HLS_AUDIO_128.m3u8", audio.URI)
+	assert.Equal(t, int64(2000000/8*10), playlist.Variants[1].EstimatedSize(10))
+	// Not a playlist
+	_, err = parseHLSMasterPlaylist(strings.NewReader("<MPD></MPD>"), base)
+	assert.Error(t, err)
+}
+
+func TestExtractHLSQualities(t *testing.T) {
+	const playlist = `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio-high",NAME="128k",DEFAULT=YES,URI="HLS_AUDIO_128.m3u8"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio-low",NAME="64k",DEFAULT=YES,URI="HLS_AUDIO_64.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=800,RESOLUTION=640x360,AUDIO="audio-low"
+HLS_360.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=400,RESOLUTION=426x240
+HLS_240.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=1600,RESOLUTION=1280x720,AUDIO="audio-high"
+HLS_720.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=1200,RESOLUTION=854x480,AUDIO="audio-high"
+HLS_480.m3u8
+`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(playlist))
+	}))
+	defer server.Close()
+	qualities, err := extractHLSQualities(context.Background(), server.URL+"/abc/HLSPlaylist.m3u8", 10)
+	assert.NoError(t, err)
+	link := func(name string) string { return server.URL + "/abc/" + name }
+	assert.Equal(t, []FetchResultMediaEntry{
+		{Link: link("HLS_720.m3u8"), Quality: "720p", Dim: Dimension{Width: 1280, Height: 720}, Size: 2000},
+		{Link: link("HLS_480.m3u8"), Quality: "480p", Dim: Dimension{Width: 854, Height: 480}, Size: 1500},
+		{Link: hlsVariantLink(link("HLS_360.m3u8"), link("HLS_AUDIO_64.m3u8")), Quality: "360p", Dim: Dimension{Width: 640, Height: 360}, Size: 1000},
+		{Link: hlsVariantLink(link("HLS_240.m3u8"), ""), Quality: "240p", Dim: Dimension{Width: 426, Height: 240}, Size: 500},
+		{Link: link("HLS_AUDIO_128.m3u8"), Quality: DownloadAudioQuality},
+	}, qualities)
+	// The links of the variants give back their audio
+	variantURL, audioLink, ok := hlsVariantAudio(qualities[2].Link)
+	assert.True(t, ok)
+	assert.Equal(t, link("HLS_360.m3u8"), variantURL)
+	assert.Equal(t, link("HLS_AUDIO_64.m3u8"), audioLink)
+	variantURL, audioLink, ok = hlsVariantAudio(qualities[3].Link)
+	assert.True(t, ok)
+	assert.Equal(t, link("HLS_240.m3u8"), variantURL)
+	assert.Empty(t, audioLink)
+	_, _, ok = hlsVariantAudio(qualities[0].Link)
+	assert.False(t, ok)
+	// The fetch is canceled with its context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = extractHLSQualities(ctx, server.URL+"/abc/HLSPlaylist.m3u8", 10)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestParseHLSMediaPlaylist(t *testing.T) {
+	base, _ := url.Parse("https://v.redd.it/abc/HLS_720.m3u8")
+	tests := []struct {
+		Name     string
+		Data     string
+		Expected HLSMediaPlaylist
+		Error    bool
+	}{
+		{
+			Name: "ts",
+			Data: "#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-KEY:METHOD=NONE\n#EXTINF:4.000,\nHLS_720-0.ts\n#EXTINF:2.5,\nHLS_720-1.ts\n#EXT-X-ENDLIST\n",
+			Expected: HLSMediaPlaylist{
+				Segments: []mediaSegment{
+					{Link: "https://v.redd.it/abc/HLS_720-0.ts"},
+					{Link: "https://v.redd.it/abc/HLS_720-1.ts"},
+				},
+			},
+		},
+		{
+			Name: "fmp4_byte_ranges",
+			Data: "#EXTM3U\n#EXT-X-MAP:URI=\"HLS_720.mp4\",BYTERANGE=\"800@0\"\n#EXTINF:4,\n#EXT-X-BYTERANGE:1000@800\nHLS_720.mp4\n#EXTINF:4,\n#EXT-X-BYTERANGE:500\nHLS_720.mp4\n#EXT-X-ENDLIST\n",
+			Expected: HLSMediaPlaylist{
+				InitSegment: &mediaSegment{Link: "https://v.redd.it/abc/HLS_720.mp4", Range: "bytes=0-799"},
+				Segments: []mediaSegment{
+					{Link: "https://v.redd.it/abc/HLS_720.mp4", Range: "bytes=800-1799"},
+					{Link: "https://v.redd.it/abc/HLS_720.mp4", Range: "bytes=1800-2299"},
+				},
+			},
+		},
+		{
+			Name:  "encrypted",
+			Data:  "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\n#EXTINF:4,\nHLS_720-0.ts\n",
+			Error: true,
+		},
+		{
+			Name:  "no_segments",
+			Data:  "#EXTM3U\n#EXT-X-ENDLIST\n",
+			Error: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			playlist, err := parseHLSMediaPlaylist(strings.NewReader(test.Data), base)
+			if test.Error {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.Expected, playlist)
+		})
+	}
+}
+
+func TestDownloadSegments(t *testing.T) {
+	// The earlier segments are slower, so they finish out of order
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		time.Sleep(time.Duration(5-index) * 5 * time.Millisecond)
+		_, _ = w.Write([]byte(strconv.Itoa(index) + r.Header.Get("Range") + ";"))
+	}))
+	defer server.Close()
+	segments := make([]mediaSegment, 6)
+	for i := range segments {
+		segments[i].Link = server.URL + "/" + strconv.Itoa(i)
+	}
+	segments[2].Range = "bytes=0-9"
+	o := new(Oauth)
+	var output bytes.Buffer
+	var lastReported int64
+	ctx := WithProgress(context.Background(), func(phase ProgressPhase, downloaded, total int64) {
+		assert.Equal(t, ProgressPhaseDownloading, phase)
+		lastReported = downloaded
+	})
+	err := o.downloadSegments(ctx, segments, &output, ProgressPhaseDownloading, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, "0;1;2bytes=0-9;3;4;5;", output.String())
+	assert.Equal(t, int64(output.Len()), lastReported)
+	// Too large
+	output.Reset()
+	err = o.downloadSegments(context.Background(), segments, &output, ProgressPhaseDownloading, 10)
+	assert.ErrorIs(t, err, FileTooBigError)
+}
+
+func TestDownloadSegmentRetries(t *testing.T) {
+	downloadRetryDelay, downloadIdleTimeout = time.Millisecond, 50*time.Millisecond
+	defer func() {
+		downloadRetryDelay, downloadIdleTimeout = 500*time.Millisecond, 30*time.Second
+	}()
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/missing":
+			attempts.Add(1)
+			w.WriteHeader(http.StatusNotFound)
+		case attempts.Add(1) == 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case attempts.Load() == 2:
+			// Stall after the headers
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		default:
+			_, _ = w.Write([]byte("segment"))
+		}
+	}))
+	defer server.Close()
+	o := new(Oauth)
+	data, err := o.downloadSegment(context.Background(), mediaSegment{Link: server.URL + "/0"}, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, "segment", string(data))
+	assert.Equal(t, int32(3), attempts.Load())
+	// Client errors are not retried
+	attempts.Store(0)
+	_, err = o.downloadSegment(context.Background(), mediaSegment{Link: server.URL + "/missing"}, 100)
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), attempts.Load())
+}
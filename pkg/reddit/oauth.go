@@ -2,16 +2,18 @@ package reddit
 
 import (
 	"RedditDownloaderBot/pkg/common"
-	"RedditDownloaderBot/pkg/util"
+	"RedditDownloaderBot/pkg/logging"
+	"RedditDownloaderBot/pkg/metrics"
+	"context"
 	"encoding/json"
 	"github.com/go-faster/errors"
-	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -36,12 +38,55 @@ type Oauth struct {
 	clientId string
 	// The client secret of this app
 	clientSecret string
+	// The access token which is sent in authorizationHeader
+	accessToken string
 	// The authorization header we should send to each request
 	authorizationHeader string
 	// When we should make the next request in unix epoch
 	rateLimitFreedom int64
 	// The HTTP client for Imgur downloads (might use proxy)
 	imgurHTTPClient *http.Client
+	// The directory which all the downloaded files are stored in. It is deleted in Close
+	tempDir string
+	// Close this channel to stop the token refresh goroutine
+	done chan struct{}
+	// When the current access token expires
+	tokenExpiry time.Time
+	// The error of the last token refresh. Nil if it has succeeded.
+	tokenRefreshErr error
+	tokenLock       sync.Mutex
+	// The settings which can be changed while running. See UpdateSettings.
+	settings atomic.Pointer[Settings]
+	// Files larger than this are not downloaded
+	maxDownloadSize int64
+	// Limits the number of videos which are re-encoded at once
+	reencodeSemaphore chan struct{}
+	// The maximum time of re-encoding a video. Zero means no limit.
+	reencodeTimeout time.Duration
+}
+
+// Options configures a new Oauth
+type Options struct {
+	// The client ID and secret of the Reddit app
+	ClientID     string
+	ClientSecret string
+	// The proxy which the Imgur media are downloaded through. Empty means no proxy.
+	ImgurProxy string
+	// Files larger than this are not downloaded because they cannot be uploaded to Telegram.
+	// Defaults to 50 MB.
+	MaxDownloadSize int64
+	// The number of videos which can be re-encoded at once. Defaults to 1.
+	ReencodeWorkers int
+	// The maximum time of re-encoding a video. Zero means no limit.
+	ReencodeTimeout time.Duration
+	// The initial settings
+	Settings Settings
+}
+
+// Settings are the options of Oauth which can be changed while it is running
+type Settings struct {
+	// If true, NSFW posts are not downloaded
+	DenyNSFW bool
 }
 
 // tokenRequestResponse is the result of https://www.reddit.com/api/v1/access_token endpoint
@@ -51,23 +96,37 @@ type tokenRequestResponse struct {
 }
 
 // NewRedditOauth returns a new RedditOauth to be used to get posts from reddit
-func NewRedditOauth(clientId, clientSecret string) (*Oauth, error) {
+func NewRedditOauth(options Options) (*Oauth, error) {
 	redditOauth := &Oauth{
-		clientId:     clientId,
-		clientSecret: clientSecret,
+		clientId:          options.ClientID,
+		clientSecret:      options.ClientSecret,
+		done:              make(chan struct{}),
+		reencodeSemaphore: make(chan struct{}, max(options.ReencodeWorkers, 1)),
+		reencodeTimeout:   options.ReencodeTimeout,
+		maxDownloadSize:   options.MaxDownloadSize,
+	}
+	if redditOauth.maxDownloadSize <= 0 {
+		redditOauth.maxDownloadSize = defaultMaxDownloadSize
 	}
+	redditOauth.UpdateSettings(options.Settings)
 	// Get the token
 	nextRefresh, err := redditOauth.createToken()
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot create initial token")
 	}
+	// Create a directory for the downloads. This way, we can delete all the files
+	// on shutdown even if some of them are still being downloaded.
+	redditOauth.tempDir, err = os.MkdirTemp("", "RedditDownloaderBot-")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create the temporary directory")
+	}
 	// The proxy to download the Imgur media through it. Imgur sometimes
 	// blocks some IP addresses like Hetzner for example. It's interesting because
 	// even with authorization it does not work. Even accessing through the browser
 	// it does not work either. So, someone might use a proxy (like Cloudflare Warp)
 	// to bypass this restriction.
-	if imgurProxy := os.Getenv("IMGUR_PROXY"); imgurProxy != "" {
-		if imgurProxyUrl, _ := url.Parse(imgurProxy); imgurProxyUrl != nil {
+	if options.ImgurProxy != "" {
+		if imgurProxyUrl, _ := url.Parse(options.ImgurProxy); imgurProxyUrl != nil {
 			redditOauth.imgurHTTPClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(imgurProxyUrl)}}
 		}
 	}
@@ -76,21 +135,47 @@ func NewRedditOauth(clientId, clientSecret string) (*Oauth, error) {
 	return redditOauth, nil
 }
 
-// tokenRefresh refreshes the
+// UpdateSettings changes the settings of the Oauth. It is safe to call while the Oauth is being used.
+func (o *Oauth) UpdateSettings(settings Settings) {
+	o.settings.Store(&settings)
+}
+
+// getSettings returns the current settings
+func (o *Oauth) getSettings() Settings {
+	if settings := o.settings.Load(); settings != nil {
+		return *settings
+	}
+	return Settings{}
+}
+
+// Close stops the token refresh and deletes all the downloaded files
+func (o *Oauth) Close() error {
+	close(o.done)
+	return os.RemoveAll(o.tempDir)
+}
+
+// tokenRefresh refreshes the token before it expires. It returns when Oauth.done is closed.
 func (o *Oauth) tokenRefresh(nextRefresh time.Duration) {
 	for {
-		time.Sleep(nextRefresh - time.Minute)
+		if !o.sleep(nextRefresh - time.Minute) {
+			return
+		}
 		// Check rate limit
 		freedom := atomic.LoadInt64(&o.rateLimitFreedom)
 		if time.Now().Unix() < freedom {
-			time.Sleep(time.Now().Sub(time.Unix(freedom, 0)))
-			nextRefresh = 0 // do not wait in line "time.Sleep(nextRefresh - time.Minute)"
+			if !o.sleep(time.Until(time.Unix(freedom, 0))) {
+				return
+			}
+			nextRefresh = time.Minute // do not wait in line "o.sleep(nextRefresh - time.Minute)"
 			continue
 		}
 		// Request the token
 		nextRefreshCandidate, err := o.createToken()
+		o.tokenLock.Lock()
+		o.tokenRefreshErr = err
+		o.tokenLock.Unlock()
 		if err != nil {
-			log.Printf("cannot re-generate token: %s", err.Error())
+			slog.Error("Cannot re-generate the Reddit token", "error", err)
 			nextRefresh = 2 * time.Minute
 		} else {
 			nextRefresh = nextRefreshCandidate
@@ -98,6 +183,18 @@ func (o *Oauth) tokenRefresh(nextRefresh time.Duration) {
 	}
 }
 
+// sleep waits for the given duration. Returns false if the Oauth is closed meanwhile.
+func (o *Oauth) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-o.done:
+		return false
+	}
+}
+
 // createToken creates an RedditOauth.authorizationHeader and returns when will the next token expire
 func (o *Oauth) createToken() (time.Duration, error) {
 	// Build the request
@@ -115,7 +212,7 @@ func (o *Oauth) createToken() (time.Duration, error) {
 	if resp.StatusCode != http.StatusOK {
 		buffer := make([]byte, 100) // 100 chars is ok right?
 		n, _ := resp.Body.Read(buffer)
-		return 0, errors.Wrapf(err, "status code is not 200. It is %s. Body starts with: %s", resp.Status, string(buffer[:n]))
+		return 0, errors.Errorf("status code is not 200. It is %s. Body starts with: %s", resp.Status, string(buffer[:n]))
 	}
 	var body tokenRequestResponse
 	err = json.NewDecoder(resp.Body).Decode(&body)
@@ -123,23 +220,44 @@ func (o *Oauth) createToken() (time.Duration, error) {
 		return 0, errors.Wrap(err, "cannot parse response")
 	}
 	// Set the data
+	logging.AddSecret(body.AccessToken)
+	if o.accessToken != "" {
+		logging.RemoveSecret(o.accessToken)
+	}
+	o.accessToken = body.AccessToken
 	o.authorizationHeader = "bearer: " + body.AccessToken
+	o.tokenLock.Lock()
+	o.tokenExpiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	o.tokenLock.Unlock()
 	return time.Duration(body.ExpiresIn) * time.Second, nil
 }
 
+// TokenStatus returns when the access token expires and the error of the last
+// token refresh. The error is nil if the last refresh has succeeded.
+func (o *Oauth) TokenStatus() (expiry time.Time, lastRefreshErr error) {
+	o.tokenLock.Lock()
+	defer o.tokenLock.Unlock()
+	return o.tokenExpiry, o.tokenRefreshErr
+}
+
+// TempDir returns the directory which the downloaded files are stored in
+func (o *Oauth) TempDir() string {
+	return o.tempDir
+}
+
 // GetComment gets the info about a comment from reddit
-func (o *Oauth) GetComment(id string) (map[string]interface{}, error) {
-	return o.doGetJsonRequest(commentApiPoint + id)
+func (o *Oauth) GetComment(ctx context.Context, id string) (map[string]interface{}, error) {
+	return o.doGetJsonRequest(ctx, commentApiPoint+id)
 }
 
 // GetPost gets the info about a post from reddit
-func (o *Oauth) GetPost(id string) (map[string]interface{}, error) {
-	return o.doGetJsonRequest(postApiPoint + id)
+func (o *Oauth) GetPost(ctx context.Context, id string) (map[string]interface{}, error) {
+	return o.doGetJsonRequest(ctx, postApiPoint+id)
 }
 
 // FollowRedirect follows a page's redirect and returns the final URL
-func (o *Oauth) FollowRedirect(u string) (string, error) {
-	resp, err := o.head(u)
+func (o *Oauth) FollowRedirect(ctx context.Context, u string) (string, error) {
+	resp, err := o.head(ctx, u)
 	if err != nil {
 		return "", err
 	}
@@ -147,13 +265,13 @@ func (o *Oauth) FollowRedirect(u string) (string, error) {
 	return resp.Request.URL.String(), nil
 }
 
-func (o *Oauth) doGetJsonRequest(Url string) (map[string]interface{}, error) {
+func (o *Oauth) doGetJsonRequest(ctx context.Context, Url string) (map[string]interface{}, error) {
 	// Check rate limit
 	if time.Now().Unix() < atomic.LoadInt64(&o.rateLimitFreedom) {
 		return nil, RateLimitErr
 	}
 	// Build the request
-	req, err := http.NewRequest("GET", Url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", Url, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot create request")
 	}
@@ -166,6 +284,9 @@ func (o *Oauth) doGetJsonRequest(Url string) (map[string]interface{}, error) {
 	}
 	defer resp.Body.Close()
 	// Check the rate limit
+	if rateLimit, err := strconv.ParseFloat(resp.Header.Get("X-Ratelimit-Remaining"), 64); err == nil {
+		metrics.RedditRateLimitRemaining.Set(rateLimit)
+	}
 	if rateLimit, err := strconv.Atoi(resp.Header.Get("X-Ratelimit-Remaining")); err == nil && rateLimit == 0 {
 		freedom, _ := strconv.Atoi(resp.Header.Get("X-Ratelimit-Reset"))
 		atomic.StoreInt64(&o.rateLimitFreedom, time.Now().Unix()+int64(freedom))
@@ -178,13 +299,13 @@ func (o *Oauth) doGetJsonRequest(Url string) (map[string]interface{}, error) {
 }
 
 // head will do a head request. Useful to check redirects
-func (o *Oauth) head(Url string) (*http.Response, error) {
+func (o *Oauth) head(ctx context.Context, Url string) (*http.Response, error) {
 	// Check rate limit
 	if time.Now().Unix() < atomic.LoadInt64(&o.rateLimitFreedom) {
 		return nil, RateLimitErr
 	}
 	// Build the request
-	req, err := http.NewRequest("HEAD", Url, nil)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", Url, nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot create request")
 	}
@@ -192,42 +313,3 @@ func (o *Oauth) head(Url string) (*http.Response, error) {
 	req.Header.Set("Authorization", o.authorizationHeader)
 	return common.GlobalHttpClient.Do(req)
 }
-
-// downloadToFile downloads a link to a file
-// It also checks where the file is too big to be uploaded to Telegram or not
-// If the file is too big, it returns FileTooBigError
-func (o *Oauth) downloadToFile(link string, f *os.File) error {
-	// Check rate limit
-	if time.Now().Unix() < atomic.LoadInt64(&o.rateLimitFreedom) {
-		return RateLimitErr
-	}
-	// Build the request
-	req, err := http.NewRequest("GET", link, nil)
-	if err != nil {
-		return errors.Wrap(err, "cannot create request")
-	}
-	req.Header.Set("User-Agent", userAgent)
-	// Check Imgur and proxy
-	var client *http.Client
-	if o.imgurHTTPClient != nil && util.IsImgurLink(link) {
-		client = o.imgurHTTPClient
-	} else {
-		client = &common.GlobalHttpClient
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode/100 != 2 {
-		return errors.New("non 2xx status: " + resp.Status)
-	}
-	if resp.ContentLength == -1 {
-		return errors.New("Unknown length")
-	}
-	if resp.ContentLength > maxDownloadSize {
-		return FileTooBigError
-	}
-	_, err = io.Copy(f, resp.Body)
-	return err
-}
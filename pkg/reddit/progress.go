@@ -0,0 +1,67 @@
+package reddit
+
+import (
+	"context"
+	"io"
+)
+
+// ProgressPhase is a step of downloading a media which is reported to the ProgressFunc
+type ProgressPhase uint8
+
+const (
+	// progressPhaseNone is used for downloads which are not reported, like thumbnails
+	progressPhaseNone ProgressPhase = iota
+	// ProgressPhaseDownloading means that the main file (photo, GIF, video, or audio) is being downloaded
+	ProgressPhaseDownloading
+	// ProgressPhaseDownloadingAudio means that the audio of a video is being downloaded
+	ProgressPhaseDownloadingAudio
+	// ProgressPhaseMerging means that the audio and video are being merged with ffmpeg
+	ProgressPhaseMerging
+	// ProgressPhaseSplitting means that a large video is being split into parts with ffmpeg
+	ProgressPhaseSplitting
+	// ProgressPhaseWaitingToEncode means that a video is waiting for the other videos to be re-encoded
+	ProgressPhaseWaitingToEncode
+	// ProgressPhaseEncoding means that a large video is being re-encoded with ffmpeg. The progress
+	// is reported in milliseconds of the encoded video instead of bytes.
+	ProgressPhaseEncoding
+)
+
+// ProgressFunc is called when a download advances. total is -1 if the size is not known.
+// It is called from the downloading goroutine, so it must not block.
+type ProgressFunc func(phase ProgressPhase, downloaded, total int64)
+
+// progressKey is the key of ProgressFunc in contexts
+type progressKey struct{}
+
+// WithProgress returns a context which makes the downloads report their progress to f
+func WithProgress(ctx context.Context, f ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, f)
+}
+
+// reportProgress reports the progress to the ProgressFunc of the context, if there is any
+func reportProgress(ctx context.Context, phase ProgressPhase, downloaded, total int64) {
+	if phase == progressPhaseNone {
+		return
+	}
+	if f, ok := ctx.Value(progressKey{}).(ProgressFunc); ok {
+		f(phase, downloaded, total)
+	}
+}
+
+// progressReader is an io.Reader which reports the number of bytes read from it
+type progressReader struct {
+	ctx        context.Context
+	reader     io.Reader
+	phase      ProgressPhase
+	downloaded int64
+	total      int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.downloaded += int64(n)
+		reportProgress(r.ctx, r.phase, r.downloaded, r.total)
+	}
+	return n, err
+}
@@ -0,0 +1,68 @@
+package reddit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestProgressReader(t *testing.T) {
+	assertion := assert.New(t)
+	tests := []struct {
+		name             string
+		data             string
+		total            int64
+		phase            ProgressPhase
+		expectedReported []int64
+	}{
+		{
+			name:             "Known size",
+			data:             "abc",
+			total:            3,
+			phase:            ProgressPhaseDownloading,
+			expectedReported: []int64{1, 2, 3},
+		},
+		{
+			name:             "Unknown size",
+			data:             "ab",
+			total:            -1,
+			phase:            ProgressPhaseDownloadingAudio,
+			expectedReported: []int64{1, 2},
+		},
+		{
+			name:             "Not reported",
+			data:             "abc",
+			total:            3,
+			phase:            progressPhaseNone,
+			expectedReported: nil,
+		},
+	}
+	for _, test := range tests {
+		var reported []int64
+		ctx := WithProgress(context.Background(), func(phase ProgressPhase, downloaded, total int64) {
+			assertion.Equal(test.phase, phase, test.name)
+			assertion.Equal(test.total, total, test.name)
+			reported = append(reported, downloaded)
+		})
+		data, err := io.ReadAll(&progressReader{
+			ctx:    ctx,
+			reader: iotest.OneByteReader(strings.NewReader(test.data)),
+			phase:  test.phase,
+			total:  test.total,
+		})
+		assertion.NoError(err, test.name)
+		assertion.Equal(test.data, string(data), test.name)
+		assertion.Equal(test.expectedReported, reported, test.name)
+	}
+	// Contexts without ProgressFunc must not panic
+	_, err := io.ReadAll(&progressReader{
+		ctx:    context.Background(),
+		reader: strings.NewReader("abc"),
+		phase:  ProgressPhaseDownloading,
+		total:  3,
+	})
+	assertion.NoError(err)
+}
@@ -0,0 +1,23 @@
+package reddit
+
+import "context"
+
+// ErrorReporterFunc is called when an error happens which is not returned to the caller but
+// should be reported anyway, like a failed merge of a video and its audio.
+// category is a short name of the failed step.
+type ErrorReporterFunc func(category string, err error)
+
+// errorReporterKey is the key of ErrorReporterFunc in contexts
+type errorReporterKey struct{}
+
+// WithErrorReporter returns a context which makes the downloads report their swallowed errors to f
+func WithErrorReporter(ctx context.Context, f ErrorReporterFunc) context.Context {
+	return context.WithValue(ctx, errorReporterKey{}, f)
+}
+
+// reportError reports an error to the ErrorReporterFunc of the context, if there is any
+func reportError(ctx context.Context, category string, err error) {
+	if f, ok := ctx.Value(errorReporterKey{}).(ErrorReporterFunc); ok {
+		f(category, err)
+	}
+}
@@ -0,0 +1,189 @@
+package reddit
+
+import (
+	"RedditDownloaderBot/pkg/common"
+	"RedditDownloaderBot/pkg/metrics"
+	"context"
+	"github.com/go-faster/errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// segmentWorkers is the number of the segments of a media which are downloaded at once
+const segmentWorkers = 4
+
+// maxSegmentSize is the maximum size of a single segment. Segments are kept in memory until
+// they are written, so at most segmentWorkers*maxSegmentSize bytes are used.
+const maxSegmentSize = 32 * 1000 * 1000
+
+// mediaSegment is a part of a segmented media (HLS or DASH)
+type mediaSegment struct {
+	Link string
+	// The value of the Range header, like bytes=0-844. Empty means the whole file.
+	Range string
+}
+
+// downloadSegments downloads the segments concurrently and writes them to w in order.
+// If the total size becomes larger than maxSize, FileTooBigError is returned.
+// The progress is reported with the given phase. Because the size of the segments is not
+// known beforehand, the total size is estimated from the downloaded segments.
+func (o *Oauth) downloadSegments(ctx context.Context, segments []mediaSegment, w io.Writer, phase ProgressPhase, maxSize int64) error {
+	// The downloads are canceled and then waited for, so none of them outlive this function
+	var downloads sync.WaitGroup
+	defer downloads.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type segmentResult struct {
+		data []byte
+		err  error
+	}
+	results := make([]chan segmentResult, len(segments))
+	for i := range results {
+		results[i] = make(chan segmentResult, 1)
+	}
+	// Each slot is freed when its segment is written, so at most segmentWorkers segments are in memory
+	slots := make(chan struct{}, segmentWorkers)
+	downloads.Add(1)
+	go func() {
+		defer downloads.Done()
+		for i, segment := range segments {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			downloads.Add(1)
+			go func() {
+				defer downloads.Done()
+				data, err := o.downloadSegment(ctx, segment, maxSize)
+				results[i] <- segmentResult{data, err}
+			}()
+		}
+	}()
+	reportProgress(ctx, phase, 0, -1)
+	var written int64
+	for i := range segments {
+		var result segmentResult
+		select {
+		case result = <-results[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		<-slots
+		if result.err != nil {
+			return errors.Wrapf(result.err, "cannot download segment %d", i)
+		}
+		written += int64(len(result.data))
+		if written > maxSize {
+			return FileTooBigError
+		}
+		if _, err := w.Write(result.data); err != nil {
+			return errors.Wrap(err, "cannot write the segment")
+		}
+		reportProgress(ctx, phase, written, written*int64(len(segments))/int64(i+1))
+	}
+	return nil
+}
+
+// downloadSegment downloads a single segment into memory. Like downloadToFile, the transient
+// errors are retried and the requests are canceled if they stall instead of after a fixed time.
+func (o *Oauth) downloadSegment(ctx context.Context, segment mediaSegment, maxSize int64) ([]byte, error) {
+	client := o.downloadClient(segment.Link)
+	startTime := time.Now()
+	var data []byte
+	err := withRetries(ctx, func() (retry bool, err error) {
+		data, retry, err = downloadSegmentAttempt(ctx, client, segment, maxSize)
+		return retry, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	metrics.DownloadDuration.WithLabelValues(metrics.HostOfLink(segment.Link)).Observe(time.Since(startTime).Seconds())
+	return data, nil
+}
+
+// downloadSegmentAttempt tries to download a segment once. retry is true if the error is transient.
+// Segments larger than maxSegmentSize are rejected, because they are kept in memory.
+func downloadSegmentAttempt(parent context.Context, client *http.Client, segment mediaSegment, maxSize int64) (data []byte, retry bool, err error) {
+	ctx, idleTimer, cancel := idleContext(parent)
+	defer cancel(nil)
+	defer idleTimer.Stop()
+	defer func() {
+		if stalled(parent, ctx, err) {
+			retry, err = true, errDownloadStalled
+		}
+	}()
+	req, err := http.NewRequestWithContext(ctx, "GET", segment.Link, nil)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "cannot create request")
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if segment.Range != "" {
+		req.Header.Set("Range", segment.Range)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, parent.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, retryableStatus(resp.StatusCode), errors.New("non 2xx status: " + resp.Status)
+	}
+	limit := min(maxSize, maxSegmentSize)
+	if resp.ContentLength > limit {
+		return nil, false, segmentTooLarge(maxSize)
+	}
+	data, err = io.ReadAll(io.LimitReader(&idleReader{reader: resp.Body, timer: idleTimer}, limit+1))
+	metrics.DownloadBytes.WithLabelValues(metrics.HostOfLink(segment.Link)).Add(float64(len(data)))
+	if err != nil {
+		return nil, parent.Err() == nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, false, segmentTooLarge(maxSize)
+	}
+	return data, false, nil
+}
+
+// segmentTooLarge is the error of a segment which is larger than maxSegmentSize or the size
+// limit of the whole media
+func segmentTooLarge(maxSize int64) error {
+	if maxSize <= maxSegmentSize {
+		return FileTooBigError
+	}
+	return errors.New("the segment is too large")
+}
+
+// getPlaylist downloads an HLS or DASH playlist. The final URL of the playlist is returned to resolve the relative links.
+func getPlaylist(ctx context.Context, playlistURL string) ([]byte, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", playlistURL, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot create request")
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := common.GlobalHttpClient.Do(req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot get url")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, errors.Errorf("status code of playlist is not OK: it is %d (%s)", resp.StatusCode, resp.Status)
+	}
+	// Playlists are small. Anything larger is not a playlist.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot read the playlist")
+	}
+	return body, resp.Request.URL, nil
+}
+
+// resolvePlaylistLink resolves a link of a playlist against the link of the playlist
+func resolvePlaylistLink(base *url.URL, link string) string {
+	u, err := url.Parse(link)
+	if err != nil || base == nil {
+		return link
+	}
+	return base.ResolveReference(u).String()
+}
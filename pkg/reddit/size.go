@@ -0,0 +1,93 @@
+package reddit
+
+import (
+	"RedditDownloaderBot/pkg/common"
+	"RedditDownloaderBot/pkg/util"
+	"context"
+	"github.com/go-faster/errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sizeEstimateTimeout is the maximum time which estimating the sizes of a media takes
+const sizeEstimateTimeout = 5 * time.Second
+
+// maxConcurrentSizeRequests is the number of HEAD requests which are sent at once to estimate the sizes
+const maxConcurrentSizeRequests = 4
+
+// EstimateSizes fills the FetchResultMediaEntry.Size of the entries whose size is unknown
+// with the Content-Length of their links. The sizes which could not be determined are left zero.
+func (o *Oauth) EstimateSizes(ctx context.Context, medias FetchResultMediaEntries) {
+	ctx, cancel := context.WithTimeout(ctx, sizeEstimateTimeout)
+	defer cancel()
+	semaphore := make(chan struct{}, maxConcurrentSizeRequests)
+	var wg sync.WaitGroup
+	for i := range medias {
+		// The size of a playlist is not the size of its media
+		if medias[i].Size > 0 || medias[i].Link == "" || isHLSLink(medias[i].Link) || isDASHLink(medias[i].Link) {
+			continue
+		}
+		wg.Add(1)
+		go func(entry *FetchResultMediaEntry) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			size, err := o.contentLength(ctx, entry.Link)
+			if err == nil {
+				entry.Size = size
+			}
+		}(&medias[i])
+	}
+	wg.Wait()
+}
+
+// contentLength gets the size of a media from the Content-Length of a HEAD request
+func (o *Oauth) contentLength(ctx context.Context, link string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", link, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "cannot create request")
+	}
+	req.Header.Set("User-Agent", userAgent)
+	client := &common.GlobalHttpClient
+	if o.imgurHTTPClient != nil && util.IsImgurLink(link) {
+		client = o.imgurHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return 0, errors.New("non 2xx status: " + resp.Status)
+	}
+	if resp.ContentLength <= 0 {
+		return 0, errors.New("Unknown length")
+	}
+	return resp.ContentLength, nil
+}
+
+// SelectQuality returns the index of the best quality in Medias which is smaller than maxSize.
+// For videos, the size of the audio is included. If none of the qualities fit, the smallest one
+// is returned. The qualities whose size is unknown are skipped and if no size is known, -1 is
+// returned. Medias must be sorted from the best quality to the worst, like what fetch returns.
+func (f FetchResultMedia) SelectQuality(maxSize int64) int {
+	audioIndex, hasAudio := f.HasAudio()
+	var audioSize int64
+	if hasAudio {
+		audioSize = f.Medias[audioIndex].Size
+	}
+	smallest := -1
+	for i, media := range f.Medias {
+		if (hasAudio && i == audioIndex) || media.Size <= 0 {
+			continue
+		}
+		if media.Size+audioSize <= maxSize {
+			return i
+		}
+		if smallest == -1 || media.Size < f.Medias[smallest].Size {
+			smallest = i
+		}
+	}
+	return smallest
+}
@@ -0,0 +1,159 @@
+package reddit
+
+import (
+	"RedditDownloaderBot/pkg/util"
+	"bytes"
+	"context"
+	"github.com/go-faster/errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// splitAttempts is the number of times which SplitVideo tries to split a video with shorter
+// segments if some of the parts are larger than the limit
+const splitAttempts = 3
+
+// splitSafetyFactor is the fraction of the limit which SplitVideo aims for in each part.
+// Keyframes do not occur at exact times and the bitrate is not constant, so the parts are
+// not exactly equal.
+const splitSafetyFactor = 0.9
+
+// minSplitSegmentTime is the shortest part in seconds which SplitVideo creates. The cuts can
+// only happen on the keyframes, which are usually a few seconds apart, so shorter parts are
+// not possible.
+const minSplitSegmentTime = 2.0
+
+// SplitVideo cuts a video into sequential parts which are smaller than maxPartSize.
+// ffmpeg copies the streams without re-encoding, so the cuts happen on the keyframes.
+// If duration is not positive, it is determined with ffprobe. The caller must close and delete
+// the returned files.
+func (o *Oauth) SplitVideo(ctx context.Context, filename string, duration float64, maxPartSize int64) ([]*os.File, error) {
+	if !util.DoesFfmpegExists() {
+		return nil, errors.New("ffmpeg is not installed")
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to get the video size")
+	}
+	if duration <= 0 {
+		duration, err = GetVideoDuration(filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	segmentTime := splitSegmentTime(duration, stat.Size(), maxPartSize)
+	reportProgress(ctx, ProgressPhaseSplitting, 0, -1)
+	for range splitAttempts {
+		if segmentTime < minSplitSegmentTime {
+			return nil, errors.New("the parts of the video would be too short")
+		}
+		parts, err := o.splitVideo(ctx, filename, segmentTime)
+		if err != nil {
+			return nil, err
+		}
+		if allFilesSmallerThan(parts, maxPartSize) {
+			return parts, nil
+		}
+		closeAndRemoveFiles(parts)
+		segmentTime *= 0.7
+	}
+	return nil, errors.New("cannot split the video into small enough parts")
+}
+
+// splitSegmentTime returns the length in seconds of the parts of a video of the given duration
+// and size, so that each part is smaller than maxPartSize
+func splitSegmentTime(duration float64, size, maxPartSize int64) float64 {
+	return duration * float64(maxPartSize) * splitSafetyFactor / float64(size)
+}
+
+// splitVideo cuts a video into parts of about segmentTime seconds
+func (o *Oauth) splitVideo(ctx context.Context, filename string, segmentTime float64) ([]*os.File, error) {
+	dir, err := os.MkdirTemp(o.tempDir, "parts")
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create a temporary directory for the parts")
+	}
+	defer os.RemoveAll(dir) // the parts are opened before this is called
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-i", filename,
+		"-map", "0",
+		"-c", "copy",
+		"-f", "segment",
+		"-segment_time", strconv.FormatFloat(segmentTime, 'f', 3, 64),
+		"-reset_timestamps", "1",
+		filepath.Join(dir, "part%03d.mp4"), "-y")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		reportError(ctx, "ffmpeg_split", errors.Wrapf(err, "cannot split the video: %s", lastLines(stderr.String(), 5)))
+		return nil, errors.Wrap(err, "Unable to split the video")
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "part*.mp4"))
+	if err != nil || len(names) == 0 {
+		return nil, errors.New("ffmpeg did not create any parts")
+	}
+	sort.Strings(names)
+	// Move the parts out of the directory, so it can be deleted
+	parts := make([]*os.File, 0, len(names))
+	for _, name := range names {
+		newName := filepath.Join(o.tempDir, filepath.Base(dir)+"-"+filepath.Base(name))
+		err = os.Rename(name, newName)
+		var part *os.File
+		if err == nil {
+			part, err = os.Open(newName)
+		}
+		if err != nil {
+			_ = os.Remove(newName)
+			closeAndRemoveFiles(parts)
+			return nil, errors.Wrap(err, "Unable to move the part of the video")
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// GetVideoDuration will get the duration of a media file in seconds
+func GetVideoDuration(filename string) (float64, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "csv=p=0",
+		filename)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, errors.Wrap(errors.New(stderr.String()), "Unable to get the file duration")
+	}
+	duration, err := strconv.ParseFloat(string(bytes.TrimSpace(output)), 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "Cannot parse duration")
+	}
+	if duration <= 0 {
+		return 0, errors.New("Invalid duration")
+	}
+	return duration, nil
+}
+
+// allFilesSmallerThan checks if the size of every file is at most maxSize
+func allFilesSmallerThan(files []*os.File, maxSize int64) bool {
+	for _, file := range files {
+		if !util.CheckFileSize(file.Name(), maxSize) {
+			return false
+		}
+	}
+	return true
+}
+
+// closeAndRemoveFiles closes and deletes temporary files
+func closeAndRemoveFiles(files []*os.File) {
+	for _, file := range files {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}
+}
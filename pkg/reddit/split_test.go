@@ -0,0 +1,111 @@
+package reddit
+
+import (
+	"RedditDownloaderBot/pkg/util"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitSegmentTime(t *testing.T) {
+	assertion := assert.New(t)
+	tests := []struct {
+		name        string
+		duration    float64
+		size        int64
+		maxPartSize int64
+		expected    float64
+	}{
+		{"Two parts", 100, 100, 50, 45},
+		{"Ten parts", 600, 1000, 100, 54},
+		{"Smaller than the limit", 60, 10, 100, 540},
+	}
+	for _, test := range tests {
+		assertion.InDelta(test.expected, splitSegmentTime(test.duration, test.size, test.maxPartSize), 0.001, test.name)
+	}
+}
+
+func TestAllFilesSmallerThan(t *testing.T) {
+	assertion := assert.New(t)
+	dir := t.TempDir()
+	var files []*os.File
+	for _, content := range []string{"a", "abc", "abcde"} {
+		file, err := os.Create(filepath.Join(dir, content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		_, _ = file.WriteString(content)
+		files = append(files, file)
+	}
+	tests := []struct {
+		name     string
+		files    []*os.File
+		maxSize  int64
+		expected bool
+	}{
+		{"No files", nil, 0, true},
+		{"All smaller", files, 10, true},
+		{"Equal to the limit", files, 5, true},
+		{"One larger", files, 4, false},
+		{"All larger", files, 0, false},
+	}
+	for _, test := range tests {
+		assertion.Equal(test.expected, allFilesSmallerThan(test.files, test.maxSize), test.name)
+	}
+}
+
+func TestSplitVideo(t *testing.T) {
+	if !util.DoesFfmpegExists() || !util.DoesFfprobeExists() {
+		t.Skip("ffmpeg is not installed")
+	}
+	assertion := assert.New(t)
+	dir := t.TempDir()
+	// A noisy video with a keyframe every second, so it can be cut anywhere and the size of
+	// each second is about the same
+	filename := filepath.Join(dir, "video.mp4")
+	output, err := exec.Command("ffmpeg",
+		"-f", "lavfi", "-i", "nullsrc=size=320x240:rate=25:duration=20,geq=random(1)*255:128:128",
+		"-c:v", "libx264", "-g", "25", "-pix_fmt", "yuv420p",
+		filename, "-y").CombinedOutput()
+	if err != nil {
+		t.Fatalf("cannot create the test video: %s\n%s", err, output)
+	}
+	stat, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	duration, err := GetVideoDuration(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxPartSize := stat.Size() / 3
+	o := &Oauth{tempDir: dir}
+	parts, err := o.SplitVideo(context.Background(), filename, 0, maxPartSize)
+	if !assertion.NoError(err) {
+		return
+	}
+	defer closeAndRemoveFiles(parts)
+	assertion.GreaterOrEqual(len(parts), 3)
+	assertion.True(allFilesSmallerThan(parts, maxPartSize))
+	// The parts are in order and together they are the whole video
+	var totalDuration float64
+	for i, part := range parts {
+		if i > 0 {
+			assertion.Less(parts[i-1].Name(), part.Name())
+		}
+		assertion.True(strings.HasPrefix(part.Name(), dir))
+		partDuration, err := GetVideoDuration(part.Name())
+		if assertion.NoError(err) {
+			totalDuration += partDuration
+		}
+	}
+	assertion.InDelta(duration, totalDuration, 1)
+	// The parts would be shorter than the keyframes
+	_, err = o.SplitVideo(context.Background(), filename, 0, 1000)
+	assertion.Error(err)
+}
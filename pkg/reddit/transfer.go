@@ -0,0 +1,277 @@
+package reddit
+
+import (
+	"RedditDownloaderBot/pkg/common"
+	"RedditDownloaderBot/pkg/metrics"
+	"RedditDownloaderBot/pkg/util"
+	"context"
+	"github.com/go-faster/errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// downloadAttempts is the number of times which a download is tried before giving up
+const downloadAttempts = 5
+
+// downloadIdleTimeout is the time which a download can go without receiving any data.
+// Unlike the timeout of common.GlobalHttpClient, it does not limit how long a large file takes to download.
+// It is a variable so the tests can shorten it.
+var downloadIdleTimeout = 30 * time.Second
+
+// downloadRetryDelay is the delay before the first retry of a download. It is doubled on each retry.
+// It is a variable so the tests can shorten it.
+var downloadRetryDelay = 500 * time.Millisecond
+
+// maxDownloadRetryDelay is the maximum delay between two attempts of a download
+const maxDownloadRetryDelay = 8 * time.Second
+
+// errDownloadStalled is the cause of the downloads which are canceled by downloadIdleTimeout
+var errDownloadStalled = errors.New("the download stalled")
+
+// resumableDownload is the state of a download which is kept between its attempts
+type resumableDownload struct {
+	client  *http.Client
+	link    string
+	f       *os.File
+	phase   ProgressPhase
+	maxSize int64
+	// The position of f where the download started
+	offset int64
+	// The number of bytes which are written to f
+	written int64
+	// The size of the file. -1 if unknown.
+	total int64
+	// The ETag or Last-Modified of the file. It is sent in If-Range to make sure
+	// that the rest of the same file is downloaded when resuming.
+	validator string
+}
+
+// downloadToFile downloads a link to a file
+// It also checks where the file is larger than maxSize or not
+// If the file is too big, it returns FileTooBigError
+// The progress is reported to the ProgressFunc of ctx with the given phase.
+// The transient errors are retried with exponential backoff and the partial downloads are
+// resumed with Range requests if the server supports them.
+func (o *Oauth) downloadToFile(ctx context.Context, link string, f *os.File, phase ProgressPhase, maxSize int64) error {
+	// Check rate limit
+	if time.Now().Unix() < atomic.LoadInt64(&o.rateLimitFreedom) {
+		return RateLimitErr
+	}
+	offset, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.Wrap(err, "cannot get the position of the file")
+	}
+	download := &resumableDownload{
+		client:  o.downloadClient(link),
+		link:    link,
+		f:       f,
+		phase:   phase,
+		maxSize: maxSize,
+		offset:  offset,
+		total:   -1,
+	}
+	startTime := time.Now()
+	err = withRetries(ctx, func() (bool, error) {
+		return download.attempt(ctx)
+	})
+	if err == nil {
+		metrics.DownloadDuration.WithLabelValues(metrics.HostOfLink(link)).Observe(time.Since(startTime).Seconds())
+	}
+	return err
+}
+
+// withRetries calls attempt until it succeeds, fails with an error which is not transient,
+// or is called downloadAttempts times. The attempts are delayed with exponential backoff.
+func withRetries(ctx context.Context, attempt func() (retry bool, err error)) error {
+	for i := 1; ; i++ {
+		retry, err := attempt()
+		if err == nil || !retry || i == downloadAttempts {
+			return err
+		}
+		select {
+		case <-time.After(retryDelay(i)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// retryableStatus checks if a failed request with the status code might succeed if it is retried
+func retryableStatus(statusCode int) bool {
+	return statusCode/100 == 5 || statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests
+}
+
+// idleContext returns a context which is canceled with errDownloadStalled unless the returned
+// timer is reset within downloadIdleTimeout. Call the CancelCauseFunc to release the context.
+func idleContext(parent context.Context) (context.Context, *time.Timer, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	return ctx, time.AfterFunc(downloadIdleTimeout, func() { cancel(errDownloadStalled) }), cancel
+}
+
+// stalled checks if a download failed because ctx, which was created by idleContext, was canceled
+func stalled(parent, ctx context.Context, err error) bool {
+	return err != nil && parent.Err() == nil && errors.Is(context.Cause(ctx), errDownloadStalled)
+}
+
+// downloadClient gets the client which a link is downloaded with. It has no timeout
+// because the downloads use downloadIdleTimeout instead.
+func (o *Oauth) downloadClient(link string) *http.Client {
+	client := common.GlobalHttpClient
+	if o.imgurHTTPClient != nil && util.IsImgurLink(link) {
+		client = *o.imgurHTTPClient
+	}
+	client.Timeout = 0
+	return &client
+}
+
+// retryDelay is the delay before the next try after the given attempt. The delays are randomized
+// a little, so the downloads which failed together do not retry together.
+func retryDelay(attempt int) time.Duration {
+	delay := min(downloadRetryDelay<<(attempt-1), maxDownloadRetryDelay)
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// attempt downloads the rest of the file. retry is true if the error is transient.
+func (d *resumableDownload) attempt(parent context.Context) (retry bool, err error) {
+	ctx, idleTimer, cancel := idleContext(parent)
+	defer cancel(nil)
+	defer idleTimer.Stop()
+	// If the download is canceled because of being idle, report that instead of context.Canceled
+	defer func() {
+		if stalled(parent, ctx, err) {
+			retry, err = true, errDownloadStalled
+		}
+	}()
+	req, err := http.NewRequestWithContext(ctx, "GET", d.link, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "cannot create request")
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if d.written > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(d.written, 10)+"-")
+		if d.validator != "" {
+			req.Header.Set("If-Range", d.validator)
+		}
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return parent.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && d.written > 0:
+		start, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != d.written {
+			// We cannot use this part. Start over in the next attempt.
+			if err = d.restart(); err != nil {
+				return false, err
+			}
+			return true, errors.New("unexpected Content-Range: " + resp.Header.Get("Content-Range"))
+		}
+		if total != -1 {
+			d.total = total
+		}
+	case resp.StatusCode/100 == 2:
+		// The server has sent the whole file, because this is the first attempt or it cannot resume
+		if d.written > 0 {
+			if err = d.restart(); err != nil {
+				return false, err
+			}
+		}
+		d.total = resp.ContentLength
+		d.validator = resp.Header.Get("ETag")
+		if d.validator == "" || strings.HasPrefix(d.validator, "W/") {
+			// Weak ETags cannot be used in If-Range
+			d.validator = resp.Header.Get("Last-Modified")
+		}
+	case retryableStatus(resp.StatusCode):
+		return true, errors.New("non 2xx status: " + resp.Status)
+	default:
+		return false, errors.New("non 2xx status: " + resp.Status)
+	}
+	if d.total > d.maxSize {
+		return false, FileTooBigError
+	}
+	reportProgress(parent, d.phase, d.written, d.total)
+	// Read one more byte than what is allowed to find out if the file is too large
+	n, err := io.Copy(d.f, &progressReader{
+		ctx:        parent,
+		reader:     io.LimitReader(&idleReader{reader: resp.Body, timer: idleTimer}, d.maxSize-d.written+1),
+		phase:      d.phase,
+		downloaded: d.written,
+		total:      d.total,
+	})
+	d.written += n
+	metrics.DownloadBytes.WithLabelValues(metrics.HostOfLink(d.link)).Add(float64(n))
+	if d.written > d.maxSize {
+		return false, FileTooBigError
+	}
+	if err != nil {
+		return parent.Err() == nil, err
+	}
+	if d.total != -1 && d.written < d.total {
+		return true, io.ErrUnexpectedEOF
+	}
+	return false, nil
+}
+
+// restart removes what has been downloaded, so the download starts from the beginning
+func (d *resumableDownload) restart() error {
+	if err := d.f.Truncate(d.offset); err != nil {
+		return errors.Wrap(err, "cannot truncate the file")
+	}
+	if _, err := d.f.Seek(d.offset, io.SeekStart); err != nil {
+		return errors.Wrap(err, "cannot seek the file")
+	}
+	d.written, d.total, d.validator = 0, -1, ""
+	return nil
+}
+
+// parseContentRange parses the Content-Range header of partial responses like bytes 100-199/1000.
+// total is -1 if the size of the file is unknown.
+func parseContentRange(contentRange string) (start, total int64, ok bool) {
+	byteRange, found := strings.CutPrefix(contentRange, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	byteRange, totalText, found := strings.Cut(byteRange, "/")
+	if !found {
+		return 0, 0, false
+	}
+	startText, _, found := strings.Cut(byteRange, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(startText, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	total = -1
+	if totalText != "*" {
+		total, err = strconv.ParseInt(totalText, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+	}
+	return start, total, true
+}
+
+// idleReader pushes back the idle timer of a download whenever data is received
+type idleReader struct {
+	reader io.Reader
+	timer  *time.Timer
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		r.timer.Reset(downloadIdleTimeout)
+	}
+	return n, err
+}
@@ -0,0 +1,228 @@
+package reddit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		Name          string
+		Data          string
+		ExpectedStart int64
+		ExpectedTotal int64
+		ExpectedOk    bool
+	}{
+		{Name: "known_total", Data: "bytes 100-199/1000", ExpectedStart: 100, ExpectedTotal: 1000, ExpectedOk: true},
+		{Name: "unknown_total", Data: "bytes 100-199/*", ExpectedStart: 100, ExpectedTotal: -1, ExpectedOk: true},
+		{Name: "unsatisfied", Data: "bytes */1000"},
+		{Name: "empty", Data: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			start, total, ok := parseContentRange(test.Data)
+			assert.Equal(t, test.ExpectedOk, ok)
+			if test.ExpectedOk {
+				assert.Equal(t, test.ExpectedStart, start)
+				assert.Equal(t, test.ExpectedTotal, total)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempt := 1; attempt < 10; attempt++ {
+		delay := min(downloadRetryDelay<<(attempt-1), maxDownloadRetryDelay)
+		got := retryDelay(attempt)
+		assert.GreaterOrEqual(t, got, delay/2)
+		assert.LessOrEqual(t, got, delay)
+	}
+}
+
+func TestDownloadToFile(t *testing.T) {
+	downloadRetryDelay, downloadIdleTimeout = time.Millisecond, 50*time.Millisecond
+	defer func() {
+		downloadRetryDelay, downloadIdleTimeout = 500*time.Millisecond, 30*time.Second
+	}()
+	const content = "0123456789abcdefghijklmnopqrstuvwxyz"
+	// writeRange writes the content from the start of the Range header, like a server which supports resuming
+	writeRange := func(w http.ResponseWriter, r *http.Request) {
+		start := 0
+		if byteRange := r.Header.Get("Range"); byteRange != "" {
+			start, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(byteRange, "bytes="), "-"))
+			w.Header().Set("Content-Range", "bytes "+strconv.Itoa(start)+"-"+strconv.Itoa(len(content)-1)+"/"+strconv.Itoa(len(content)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		}
+		_, _ = w.Write([]byte(content[start:]))
+	}
+	// cutOff sends half of the content and closes the connection
+	cutOff := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("ETag", `"abc"`)
+		_, _ = w.Write([]byte(content[:len(content)/2]))
+		w.(http.Flusher).Flush()
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		_ = conn.Close()
+	}
+	tests := []struct {
+		Name     string
+		MaxSize  int64
+		Handler  func(attempt int32, w http.ResponseWriter, r *http.Request)
+		Attempts int32
+		Error    error
+		HasError bool
+	}{
+		{
+			Name:    "simple",
+			MaxSize: 100,
+			Handler: func(_ int32, w http.ResponseWriter, r *http.Request) {
+				writeRange(w, r)
+			},
+			Attempts: 1,
+		},
+		{
+			Name:    "chunked",
+			MaxSize: 100,
+			Handler: func(_ int32, w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(content[:10]))
+				w.(http.Flusher).Flush()
+				_, _ = w.Write([]byte(content[10:]))
+			},
+			Attempts: 1,
+		},
+		{
+			Name:    "chunked_too_large",
+			MaxSize: 10,
+			Handler: func(_ int32, w http.ResponseWriter, r *http.Request) {
+				w.(http.Flusher).Flush()
+				_, _ = w.Write([]byte(content))
+			},
+			Attempts: 1,
+			Error:    FileTooBigError,
+		},
+		{
+			Name:    "too_large",
+			MaxSize: 10,
+			Handler: func(_ int32, w http.ResponseWriter, r *http.Request) {
+				writeRange(w, r)
+			},
+			Attempts: 1,
+			Error:    FileTooBigError,
+		},
+		{
+			Name:    "server_error",
+			MaxSize: 100,
+			Handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				if attempt < 3 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				writeRange(w, r)
+			},
+			Attempts: 3,
+		},
+		{
+			Name:    "not_found",
+			MaxSize: 100,
+			Handler: func(_ int32, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+			},
+			Attempts: 1,
+			HasError: true,
+		},
+		{
+			Name:    "always_failing",
+			MaxSize: 100,
+			Handler: func(_ int32, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadGateway)
+			},
+			Attempts: downloadAttempts,
+			HasError: true,
+		},
+		{
+			Name:    "resume",
+			MaxSize: 100,
+			Handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				if attempt == 1 {
+					cutOff(w)
+					return
+				}
+				if r.Header.Get("Range") != "bytes=18-" || r.Header.Get("If-Range") != `"abc"` {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				writeRange(w, r)
+			},
+			Attempts: 2,
+		},
+		{
+			Name:    "resume_not_supported",
+			MaxSize: 100,
+			Handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				if attempt == 1 {
+					cutOff(w)
+					return
+				}
+				// Ignore the Range header
+				w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+				_, _ = w.Write([]byte(content))
+			},
+			Attempts: 2,
+		},
+		{
+			Name:    "stalled",
+			MaxSize: 100,
+			Handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				if attempt == 1 {
+					w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+					_, _ = w.Write([]byte(content[:5]))
+					w.(http.Flusher).Flush()
+					select {
+					case <-r.Context().Done():
+					case <-time.After(time.Second):
+					}
+					return
+				}
+				writeRange(w, r)
+			},
+			Attempts: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				test.Handler(attempts.Add(1), w, r)
+			}))
+			defer server.Close()
+			f, err := os.CreateTemp("", "download*")
+			assert.NoError(t, err)
+			defer os.Remove(f.Name())
+			defer f.Close()
+			o := new(Oauth)
+			err = o.downloadToFile(context.Background(), server.URL, f, ProgressPhaseDownloading, test.MaxSize)
+			assert.Equal(t, test.Attempts, attempts.Load())
+			if test.Error != nil || test.HasError {
+				assert.Error(t, err)
+				if test.Error != nil {
+					assert.ErrorIs(t, err, test.Error)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			data, _ := os.ReadFile(f.Name())
+			assert.Equal(t, content, string(data))
+		})
+	}
+}
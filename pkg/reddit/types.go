@@ -12,8 +12,33 @@ type FetchError struct {
 	NormalError string
 	// BotError on the other hand, must be sent to user. It should never be empty.
 	BotError string
+	// Category is the kind of this error. Used in metrics.
+	Category FetchErrorCategory
+	// Stack is the stack trace of the panic which has caused this error. Empty if the error
+	// was not caused by a panic.
+	Stack string
 }
 
+// FetchErrorCategory is the kind of FetchError
+type FetchErrorCategory string
+
+const (
+	// FetchErrorCategoryInvalidURL means that the link sent by user is not a valid Reddit link
+	FetchErrorCategoryInvalidURL FetchErrorCategory = "invalid_url"
+	// FetchErrorCategoryNSFW means that the post is NSFW and NSFW posts are disabled
+	FetchErrorCategoryNSFW FetchErrorCategory = "nsfw"
+	// FetchErrorCategoryRequest means that a request to Reddit has failed
+	FetchErrorCategoryRequest FetchErrorCategory = "request"
+	// FetchErrorCategoryParse means that the response of Reddit is not what we expected
+	FetchErrorCategoryParse FetchErrorCategory = "parse"
+	// FetchErrorCategoryMedia means that we could not get the media of the post
+	FetchErrorCategoryMedia FetchErrorCategory = "media"
+	// FetchErrorCategoryUnsupported means that the post type or host is not supported
+	FetchErrorCategoryUnsupported FetchErrorCategory = "unsupported"
+	// FetchErrorCategoryInternal means that a bug caused the error
+	FetchErrorCategoryInternal FetchErrorCategory = "internal"
+)
+
 // Error returns the normal error which might contain sensitive information
 func (e FetchError) Error() string {
 	return e.NormalError
@@ -42,6 +67,9 @@ type FetchResultMediaEntry struct {
 	// The dimensions of this media. Will be zeroed if there was
 	// any problem getting the dimension.
 	Dim Dimension
+	// The estimated size of this media in bytes. Zero if unknown.
+	// See Oauth.EstimateSizes.
+	Size int64
 }
 
 // FetchResultMediaEntries is a list of FetchResultMediaEntry
@@ -145,6 +173,16 @@ type Dimension struct {
 	Height int64
 }
 
+// shortSide gets the shorter side of the dimension, which is the number in the names of the
+// qualities like 1080p. A portrait 1080x1920 video is 1080p. It is the height if the width
+// is unknown.
+func (d Dimension) shortSide() int64 {
+	if d.Width > 0 && d.Height > 0 {
+		return min(d.Width, d.Height)
+	}
+	return d.Height
+}
+
 // Empty checks if both width and height of the dimension is zero. This means
 // that the bot couldn't get the width and height of the media.
 func (d Dimension) Empty() bool {
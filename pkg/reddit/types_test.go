@@ -354,3 +354,72 @@ func TestFetchedThumbnails_SelectThumbnail(t *testing.T) {
 		})
 	}
 }
+
+func TestFetchResultMedia_SelectQuality(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Input    FetchResultMedia
+		MaxSize  int64
+		Expected int
+	}{
+		{
+			Name: "BestFits",
+			Input: FetchResultMedia{
+				Medias: FetchResultMediaEntries{{Size: 40}, {Size: 20}, {Size: 10}},
+				Type:   FetchResultMediaTypePhoto,
+			},
+			MaxSize:  50,
+			Expected: 0,
+		},
+		{
+			Name: "SecondFits",
+			Input: FetchResultMedia{
+				Medias: FetchResultMediaEntries{{Size: 80}, {Size: 40}, {Size: 10}},
+				Type:   FetchResultMediaTypeGif,
+			},
+			MaxSize:  50,
+			Expected: 1,
+		},
+		{
+			Name: "AudioIncluded",
+			Input: FetchResultMedia{
+				Medias: FetchResultMediaEntries{{Size: 45}, {Size: 30}, {Quality: DownloadAudioQuality, Size: 10}},
+				Type:   FetchResultMediaTypeVideo,
+			},
+			MaxSize:  50,
+			Expected: 1,
+		},
+		{
+			Name: "UnknownSkipped",
+			Input: FetchResultMedia{
+				Medias: FetchResultMediaEntries{{Size: 0}, {Size: 30}},
+				Type:   FetchResultMediaTypePhoto,
+			},
+			MaxSize:  50,
+			Expected: 1,
+		},
+		{
+			Name: "NoneFits",
+			Input: FetchResultMedia{
+				Medias: FetchResultMediaEntries{{Size: 90}, {Size: 70}, {Size: 0}},
+				Type:   FetchResultMediaTypeVideo,
+			},
+			MaxSize:  50,
+			Expected: 1,
+		},
+		{
+			Name: "AllUnknown",
+			Input: FetchResultMedia{
+				Medias: FetchResultMediaEntries{{}, {}, {Quality: DownloadAudioQuality, Size: 10}},
+				Type:   FetchResultMediaTypeVideo,
+			},
+			MaxSize:  50,
+			Expected: -1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, test.Input.SelectQuality(test.MaxSize))
+		})
+	}
+}
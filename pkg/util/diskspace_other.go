@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package util
+
+import "github.com/go-faster/errors"
+
+// FreeDiskSpace is not supported on this platform
+func FreeDiskSpace(string) (uint64, error) {
+	return 0, errors.New("free disk space is not supported on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package util
+
+import "golang.org/x/sys/unix"
+
+// FreeDiskSpace returns the number of bytes which are available to us on the
+// file system of path
+func FreeDiskSpace(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
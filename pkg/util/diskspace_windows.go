@@ -0,0 +1,17 @@
+package util
+
+import "golang.org/x/sys/windows"
+
+// FreeDiskSpace returns the number of bytes which are available to us on the
+// file system of path
+func FreeDiskSpace(path string) (uint64, error) {
+	pathPointer, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	if err = windows.GetDiskFreeSpaceEx(pathPointer, &available, nil, nil); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
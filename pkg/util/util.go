@@ -4,11 +4,10 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"github.com/google/uuid"
-	"log"
+	"log/slog"
 	"net/url"
 	"os"
 	"os/exec"
-	"strconv"
 	"strings"
 	"unsafe"
 )
@@ -26,11 +25,17 @@ func DoesFfmpegExists() bool {
 	return err == nil
 }
 
+// DoesFfprobeExists returns true if ffprobe is found
+func DoesFfprobeExists() bool {
+	_, err := exec.LookPath("ffprobe")
+	return err == nil
+}
+
 // CheckFileSize checks the size of file before sending it to telegram
 func CheckFileSize(f string, allowed int64) bool {
 	fi, err := os.Stat(f)
 	if err != nil {
-		log.Println("Cannot get file size:", err.Error())
+		slog.Error("Cannot get file size", "file", f, "error", err)
 		return false
 	}
 	return fi.Size() <= allowed
@@ -54,13 +59,6 @@ func ToJsonString(object any) string {
 	return ByteToString(data)
 }
 
-// ParseEnvironmentVariableBool parses an environment variable which must represent a bool.
-// It returns false if the variable data is malformed or non-existent
-func ParseEnvironmentVariableBool(name string) bool {
-	result, _ := strconv.ParseBool(os.Getenv(name))
-	return result
-}
-
 func IsImgurLink(link string) bool {
 	u, _ := url.Parse(link)
 	if u == nil { // error probably